@@ -0,0 +1,483 @@
+package api
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	errArchiveTooLarge       = errors.New("Upload exceeds the extraction size limit.")
+	errArchiveTooManyEntries = errors.New("Upload exceeds the maximum number of entries.")
+	errArchiveEntryPath      = errors.New("Archive entry escapes the destination directory.")
+)
+
+// archiveExtractor writes uploaded files into a hidden staging directory,
+// enforcing the size and entry limits. Nothing reaches the destination
+// directory before the whole upload has been extracted.
+type archiveExtractor struct {
+	staging   string
+	remaining int64
+	entries   int64
+}
+
+// target maps an archive entry name into the staging directory, rejecting
+// absolute names and ".." elements (zip-slip).
+func (ex *archiveExtractor) target(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") {
+		return "", errArchiveEntryPath
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if elem == ".." {
+			return "", errArchiveEntryPath
+		}
+	}
+
+	target := filepath.Join(ex.staging, filepath.FromSlash(name))
+	if !isWithin(ex.staging, target) {
+		return "", errArchiveEntryPath
+	}
+	return target, nil
+}
+
+func (ex *archiveExtractor) count() error {
+	ex.entries--
+	if ex.entries < 0 {
+		return errArchiveTooManyEntries
+	}
+	return nil
+}
+
+// mkdir creates dirPath and its missing parents, owned like the staging
+// directory.
+func (ex *archiveExtractor) mkdir(dirPath string) error {
+	if dirPath == ex.staging {
+		return nil
+	}
+	if _, err := os.Stat(dirPath); err == nil {
+		return nil
+	}
+	if err := ex.mkdir(filepath.Dir(dirPath)); err != nil {
+		return err
+	}
+	if err := os.Mkdir(dirPath, 0755); err != nil {
+		return err
+	}
+	return chownLikeParent(dirPath)
+}
+
+func (ex *archiveExtractor) writeFile(target string, r io.Reader, mode os.FileMode) error {
+	if err := ex.mkdir(filepath.Dir(target)); err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+	written, err := io.CopyN(out, r, ex.remaining+1)
+	out.Close()
+	if err != nil && err != io.EOF {
+		return err
+	}
+	ex.remaining -= written
+	if ex.remaining < 0 {
+		return errArchiveTooLarge
+	}
+	return chownLikeParent(target)
+}
+
+func (ex *archiveExtractor) extractZip(file multipart.File, size int64) error {
+	zr, err := zip.NewReader(file, size)
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		if err := ex.count(); err != nil {
+			return err
+		}
+		target, err := ex.target(f.Name)
+		if err != nil {
+			return err
+		}
+
+		mode := f.FileInfo().Mode()
+		switch {
+		case mode.IsDir():
+			err = ex.mkdir(target)
+		case mode.IsRegular():
+			var rc io.ReadCloser
+			rc, err = f.Open()
+			if err != nil {
+				return err
+			}
+			err = ex.writeFile(target, rc, mode)
+			rc.Close()
+		}
+		// symlinks and other special files are skipped
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ex *archiveExtractor) extractTarGz(r io.Reader) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := ex.count(); err != nil {
+			return err
+		}
+		target, err := ex.target(header.Name)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = ex.mkdir(target)
+		case tar.TypeReg:
+			err = ex.writeFile(target, tr, header.FileInfo().Mode())
+		}
+		// symlinks, hard links and devices are skipped
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (ex *archiveExtractor) extract(header *multipart.FileHeader, extract bool) error {
+	file, err := header.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	name := strings.ToLower(header.Filename)
+	switch {
+	case extract && strings.HasSuffix(name, ".zip"):
+		return ex.extractZip(file, header.Size)
+	case extract && (strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")):
+		return ex.extractTarGz(file)
+	}
+
+	if err := ex.count(); err != nil {
+		return err
+	}
+	target, err := ex.target(filepath.Base(header.Filename))
+	if err != nil {
+		return err
+	}
+	return ex.writeFile(target, file, 0644)
+}
+
+// commit moves every top-level staged entry into destDir and returns their
+// new paths. Conflicts are checked for all entries before anything is moved;
+// prepare makes way for an entry when overwrite is set. When an entry cannot
+// be moved, those already moved go back to the staging directory, while
+// entries they replaced stay wherever prepare put them, e.g. in the trash.
+func (ex *archiveExtractor) commit(destDir string, overwrite bool, prepare func(dst string) error) ([]string, error) {
+	entries, err := os.ReadDir(ex.staging)
+	if err != nil {
+		return nil, err
+	}
+	if !overwrite {
+		for _, entry := range entries {
+			if _, err := os.Lstat(filepath.Join(destDir, entry.Name())); err == nil {
+				return nil, errDestinationExists
+			}
+		}
+	}
+	var moved []string
+	for _, entry := range entries {
+		dst := filepath.Join(destDir, entry.Name())
+		err := prepare(dst)
+		if err == nil {
+			err = os.Rename(filepath.Join(ex.staging, entry.Name()), dst)
+		}
+		if err != nil {
+			for _, path := range moved {
+				if err := os.Rename(path, filepath.Join(ex.staging, filepath.Base(path))); err != nil {
+					log.Println("cannot take back uploaded", path+":", err)
+				}
+			}
+			return nil, err
+		}
+		moved = append(moved, dst)
+	}
+	return moved, nil
+}
+
+func uploadErrorStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr), errors.Is(err, errArchiveTooLarge), errors.Is(err, errArchiveTooManyEntries):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errArchiveEntryPath), errors.Is(err, zip.ErrFormat), errors.Is(err, gzip.ErrHeader), errors.Is(err, tar.ErrHeader):
+		return http.StatusBadRequest
+	default:
+		return mutationErrorStatus(err)
+	}
+}
+
+type uploadRequest struct {
+	PathStr   string `form:"path_str" binding:"required"`
+	Extract   bool   `form:"extract"`
+	Overwrite bool   `form:"overwrite"`
+}
+
+// UploadFiles stores the multipart "files" into a workspace directory.
+// With extract set, .zip and .tar.gz uploads are unpacked in place.
+func (server *Server) UploadFiles(ctx *gin.Context) {
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, server.config.MaxUploadSize)
+
+	var req uploadRequest
+	if err := ctx.ShouldBind(&req); err != nil {
+		ctx.JSON(uploadErrorStatus(err), errorResponse(err))
+		return
+	}
+	form, err := ctx.MultipartForm()
+	if err != nil {
+		ctx.JSON(uploadErrorStatus(err), errorResponse(err))
+		return
+	}
+	files := form.File["files"]
+	if len(files) == 0 {
+		err = errors.New("No files were uploaded.")
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	destDir, err := ws.resolve(req.PathStr, accessReadWrite)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	if info, err := os.Stat(destDir); err != nil || !info.IsDir() {
+		err = errors.New("Destination is not a directory.")
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	staging, err := os.MkdirTemp(destDir, ".upload-")
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+	defer os.RemoveAll(staging)
+	if err := chownLikeParent(staging); err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+
+	ex := &archiveExtractor{
+		staging:   staging,
+		remaining: server.config.MaxExtractSize,
+		entries:   server.config.MaxArchiveEntries,
+	}
+	for _, header := range files {
+		if err := ex.extract(header, req.Extract); err != nil {
+			ctx.JSON(uploadErrorStatus(err), errorResponse(err))
+			return
+		}
+	}
+	release, ok := server.reserveQuota(ctx, destDir, server.config.MaxExtractSize-ex.remaining)
+	if !ok {
+		return
+	}
+	prepare := func(dst string) error {
+		return server.prepareDestination(ws, dst, req.Overwrite)
+	}
+	committed, err := ex.commit(destDir, req.Overwrite, prepare)
+	if err != nil {
+		release()
+		ctx.JSON(uploadErrorStatus(err), errorResponse(err))
+		return
+	}
+	// the index skips directories, so extracted trees are told file by file
+	for _, path := range committed {
+		filepath.WalkDir(path, func(path string, entry fs.DirEntry, err error) error {
+			if err == nil && !entry.IsDir() {
+				server.index.Notify(path)
+			}
+			return nil
+		})
+	}
+
+	res, err := server.newFileMutationResponse(destDir, "Success upload", destDir)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+// walkArchive calls fn for every regular file and directory below root with
+// its slash separated archive name. Symlinks are not followed nor archived.
+func walkArchive(root string, fn func(path, name string, info os.FileInfo) error) error {
+	base := filepath.Base(root)
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		return fn(path, filepath.ToSlash(filepath.Join(base, rel)), info)
+	})
+}
+
+func writeZip(w io.Writer, root string) error {
+	zw := zip.NewWriter(w)
+	err := walkArchive(root, func(path, name string, info os.FileInfo) error {
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if info.IsDir() {
+			header.Name += "/"
+			_, err = zw.CreateHeader(header)
+			return err
+		}
+		header.Method = zip.Deflate
+
+		out, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		return copyFileTo(out, path)
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writeTarGz(w io.Writer, root string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	err := walkArchive(root, func(path, name string, info os.FileInfo) error {
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		return copyFileTo(tw, path)
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func copyFileTo(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+type downloadRequest struct {
+	PathStr string `form:"path_str" binding:"required"`
+	Format  string `form:"format" binding:"omitempty,oneof=zip tar.gz"`
+}
+
+// Download streams a file, or a directory packed as a zip or tar.gz archive.
+func (server *Server) Download(ctx *gin.Context) {
+	var req downloadRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	path, err := ws.resolve(req.PathStr, accessReadOnly)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		ctx.JSON(mutationErrorStatus(err), errorResponse(err))
+		return
+	}
+	if !info.IsDir() {
+		ctx.Header("Content-Disposition", attachment(filepath.Base(path)))
+		ctx.File(path)
+		return
+	}
+
+	write, contentType, ext := writeZip, "application/zip", ".zip"
+	if req.Format == "tar.gz" {
+		write, contentType, ext = writeTarGz, "application/gzip", ".tar.gz"
+	}
+	ctx.Header("Content-Type", contentType)
+	ctx.Header("Content-Disposition", attachment(filepath.Base(path)+ext))
+	ctx.Status(http.StatusOK)
+
+	// the status is already sent, so errors can only end the stream early
+	if err := write(ctx.Writer, path); err != nil {
+		log.Println("cannot write archive:", err)
+	}
+}
+
+// attachment returns the Content-Disposition of a download saved as name.
+// Quotes and non-ASCII characters in file names are escaped.
+func attachment(name string) string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": name})
+}
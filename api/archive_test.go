@@ -0,0 +1,242 @@
+package api
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveExtractorTarget(t *testing.T) {
+	staging := t.TempDir()
+	ex := &archiveExtractor{staging: staging}
+
+	tests := []struct {
+		name   string
+		target string
+		err    error
+	}{
+		{"main.go", "main.go", nil},
+		{"src/lib/util.go", "src/lib/util.go", nil},
+		{"./src/../main.go", "", errArchiveEntryPath},
+		{"../main.go", "", errArchiveEntryPath},
+		{"src/../../main.go", "", errArchiveEntryPath},
+		{`..\main.go`, "", errArchiveEntryPath},
+		{"/etc/passwd", "", errArchiveEntryPath},
+		{`\etc\passwd`, "", errArchiveEntryPath},
+	}
+	for _, tc := range tests {
+		target, err := ex.target(tc.name)
+		if tc.err != nil {
+			require.ErrorIs(t, err, tc.err, tc.name)
+			continue
+		}
+		require.NoError(t, err, tc.name)
+		require.Equal(t, filepath.Join(staging, tc.target), target, tc.name)
+	}
+}
+
+// archiveEntry is a file, a directory when name ends in "/", or a symlink
+// to link.
+type archiveEntry struct {
+	name string
+	body string
+	link string
+}
+
+func buildZip(t *testing.T, entries []archiveEntry) *os.File {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		body := entry.body
+		switch {
+		case entry.link != "":
+			header.SetMode(os.ModeSymlink | 0777)
+			body = entry.link
+		case entry.name[len(entry.name)-1] == '/':
+			header.SetMode(os.ModeDir | 0755)
+		default:
+			header.SetMode(0644)
+		}
+		w, err := zw.CreateHeader(header)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "upload.zip"))
+	require.NoError(t, err)
+	t.Cleanup(func() { file.Close() })
+	_, err = file.Write(buf.Bytes())
+	require.NoError(t, err)
+	return file
+}
+
+func buildTarGz(t *testing.T, entries []archiveEntry) *bytes.Buffer {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(entry.body))}
+		switch {
+		case entry.link != "":
+			header.Typeflag, header.Linkname, header.Size = tar.TypeSymlink, entry.link, 0
+		case entry.name[len(entry.name)-1] == '/':
+			header.Typeflag, header.Mode = tar.TypeDir, 0755
+		}
+		require.NoError(t, tw.WriteHeader(header))
+		if header.Typeflag == tar.TypeReg {
+			_, err := tw.Write([]byte(entry.body))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return &buf
+}
+
+func TestExtractArchive(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []archiveEntry
+		size    int64
+		count   int64
+		err     error
+		// files expected in the staging directory with their content
+		files map[string]string
+	}{
+		{
+			name: "files",
+			entries: []archiveEntry{
+				{name: "src/"},
+				{name: "src/main.go", body: "package main"},
+				{name: "README.md", body: "# demo"},
+			},
+			files: map[string]string{"src/main.go": "package main", "README.md": "# demo"},
+		},
+		{
+			name: "symlink",
+			entries: []archiveEntry{
+				{name: "passwd", link: "/etc/passwd"},
+				{name: "main.go", body: "package main"},
+			},
+			files: map[string]string{"main.go": "package main"},
+		},
+		{
+			name:    "parent",
+			entries: []archiveEntry{{name: "../main.go", body: "package main"}},
+			err:     errArchiveEntryPath,
+		},
+		{
+			name:    "absolute",
+			entries: []archiveEntry{{name: "/tmp/main.go", body: "package main"}},
+			err:     errArchiveEntryPath,
+		},
+		{
+			name: "entries",
+			entries: []archiveEntry{
+				{name: "a.go", body: "package a"},
+				{name: "b.go", body: "package b"},
+				{name: "c.go", body: "package c"},
+			},
+			count: 2,
+			err:   errArchiveTooManyEntries,
+		},
+		{
+			name: "size",
+			entries: []archiveEntry{
+				{name: "a.go", body: "package a"},
+				{name: "b.go", body: "package b"},
+			},
+			size: 12,
+			err:  errArchiveTooLarge,
+		},
+	}
+
+	formats := []struct {
+		name    string
+		extract func(ex *archiveExtractor, entries []archiveEntry) error
+	}{
+		{"zip", func(ex *archiveExtractor, entries []archiveEntry) error {
+			file := buildZip(t, entries)
+			info, err := file.Stat()
+			require.NoError(t, err)
+			return ex.extractZip(file, info.Size())
+		}},
+		{"tar.gz", func(ex *archiveExtractor, entries []archiveEntry) error {
+			return ex.extractTarGz(buildTarGz(t, entries))
+		}},
+	}
+
+	for _, format := range formats {
+		for _, tc := range tests {
+			t.Run(format.name+"/"+tc.name, func(t *testing.T) {
+				ex := &archiveExtractor{staging: t.TempDir(), remaining: 1 << 20, entries: 100}
+				if tc.size > 0 {
+					ex.remaining = tc.size
+				}
+				if tc.count > 0 {
+					ex.entries = tc.count
+				}
+
+				err := format.extract(ex, tc.entries)
+				if tc.err != nil {
+					require.ErrorIs(t, err, tc.err)
+					return
+				}
+				require.NoError(t, err)
+
+				files := make(map[string]string)
+				err = filepath.Walk(ex.staging, func(path string, info os.FileInfo, err error) error {
+					if err != nil || info.IsDir() {
+						return err
+					}
+					rel, _ := filepath.Rel(ex.staging, path)
+					data, err := os.ReadFile(path)
+					files[filepath.ToSlash(rel)] = string(data)
+					return err
+				})
+				require.NoError(t, err)
+				require.Equal(t, tc.files, files)
+			})
+		}
+	}
+}
+
+func TestAttachment(t *testing.T) {
+	require.Equal(t, `attachment; filename=main.go`, attachment("main.go"))
+	require.Equal(t, `attachment; filename="my \"app\".zip"`, attachment(`my "app".zip`))
+	require.Equal(t, `attachment; filename*=utf-8''caf%C3%A9.zip`, attachment("café.zip"))
+}
+
+func TestArchiveExtractorCommitTakesBack(t *testing.T) {
+	ex := &archiveExtractor{staging: t.TempDir()}
+	for _, name := range []string{"a.go", "b.go", "c.go"} {
+		require.NoError(t, os.WriteFile(filepath.Join(ex.staging, name), []byte(name), 0644))
+	}
+	destDir := t.TempDir()
+
+	// the second entry cannot be put in place
+	_, err := ex.commit(destDir, true, func(dst string) error {
+		if filepath.Base(dst) == "b.go" {
+			return os.ErrPermission
+		}
+		return nil
+	})
+	require.ErrorIs(t, err, os.ErrPermission)
+	entries, err := os.ReadDir(destDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+	require.FileExists(t, filepath.Join(ex.staging, "a.go"))
+
+	committed, err := ex.commit(destDir, false, func(string) error { return nil })
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(destDir, "a.go"), filepath.Join(destDir, "b.go"), filepath.Join(destDir, "c.go")}, committed)
+}
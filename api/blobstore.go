@@ -0,0 +1,64 @@
+package api
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+var errInvalidBlobHash = errors.New("invalid blob hash")
+
+// blobStore keeps file contents addressed by their fileVersion hash, so
+// identical revisions are stored once.
+type blobStore struct {
+	dir string
+}
+
+func (store blobStore) path(hash string) (string, error) {
+	if len(hash) != 64 {
+		return "", errInvalidBlobHash
+	}
+	for _, c := range hash {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return "", errInvalidBlobHash
+		}
+	}
+	return filepath.Join(store.dir, hash[:2], hash[2:]), nil
+}
+
+// put stores data and returns its hash.
+func (store blobStore) put(data []byte) (string, error) {
+	hash := fileVersion(data)
+	path, err := store.path(hash)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return hash, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return hash, os.Rename(tmp.Name(), path)
+}
+
+func (store blobStore) get(hash string) ([]byte, error) {
+	path, err := store.path(hash)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
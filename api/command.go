@@ -2,21 +2,22 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"io/fs"
 	"io/ioutil"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
-	"slices"
-	"strconv"
+	"sort"
 	"strings"
 
-	db "github.com/diantanjung/wecom/db/sqlc"
-	"github.com/diantanjung/wecom/token"
+	"github.com/diantanjung/wecom/lang"
+	"github.com/diantanjung/wecom/util"
 	"github.com/gin-gonic/gin"
 )
 
@@ -24,10 +25,12 @@ type commandResponse struct {
 	Path    string `json:"path"`
 	Command string `json:"command"`
 	Message string `json:"message"`
+	RunID   string `json:"run_id,omitempty"`
 }
 
 type getFileContentResponse struct {
-	FileStr string `json:"file_str"`
+	fileContent
+	Version string `json:"version"`
 }
 
 type getFileContentRequest struct {
@@ -40,77 +43,166 @@ func (server *Server) GetFileContent(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, errorResponse(err))
 		return
 	}
-	file := req.PathStr
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	filePath, err := ws.resolve(req.PathStr, accessReadOnly)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+	if info.Size() > server.config.LargeFileSize {
+		ctx.JSON(http.StatusOK, getFileContentResponse{
+			fileContent: newLargeFileContent(filePath, info.Size()),
+		})
+		return
+	}
 
-	// file
-	filePath := "/" + file
 	fileString, err := ioutil.ReadFile(filePath)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
 		return
 	}
 	res := getFileContentResponse{
-		FileStr: strings.Trim(string(fileString), " "),
+		fileContent: newFileContent(filePath, fileString),
+		Version:     fileVersion(fileString),
 	}
+	ctx.Header("ETag", `"`+res.Version+`"`)
 	ctx.JSON(http.StatusOK, res)
 }
 
 type updateFileContentRequest struct {
 	PathStr string `json:"path_str" binding:"required"`
-	FileStr string `json:"file_str" binding:"required"`
+	FileStr string `json:"file_str"`
+	Version string `json:"version"`
+	// run the language formatter on the content before saving it
+	Format bool `json:"format"`
+	fileEncodingRequest
+}
+
+type updateFileContentResponse struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+	Version string `json:"version"`
+	// the saved content was reformatted and differs from file_str sent
+	Formatted bool   `json:"formatted,omitempty"`
+	FileStr   string `json:"file_str,omitempty"`
+	// why formatting failed; the content was saved as sent
+	Diagnostics []formatDiagnostic `json:"diagnostics,omitempty"`
+}
+
+type fileConflictResponse struct {
+	Error string `json:"error"`
+	fileContent
+	Version string `json:"version"`
+}
+
+// expectedVersion returns the version the client based its edit on, taken
+// from If-Match or from the request body.
+func expectedVersion(ctx *gin.Context, bodyVersion string) string {
+	ifMatch := strings.TrimSpace(ctx.GetHeader("If-Match"))
+	if ifMatch == "" {
+		return bodyVersion
+	}
+	return strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`)
+}
+
+// checkExpectedVersion compares the version the client expects with the
+// current content and answers 409 with that content when they differ.
+func checkExpectedVersion(ctx *gin.Context, bodyVersion, path string, current []byte, existed bool) bool {
+	expected := expectedVersion(ctx, bodyVersion)
+	if expected == "" || expected == "*" {
+		return true
+	}
+
+	currentVersion := ""
+	if existed {
+		currentVersion = fileVersion(current)
+	}
+	if currentVersion != expected {
+		ctx.JSON(http.StatusConflict, fileConflictResponse{
+			Error:       "File has been modified since it was opened.",
+			fileContent: newFileContent(path, current),
+			Version:     currentVersion,
+		})
+		return false
+	}
+	return true
 }
 
 func (server *Server) UpdateFileContent(ctx *gin.Context) {
-	//if !server.isUserHasDir(ctx) {
-	//	err := errors.New("Directory doesn't belong to the authenticated user")
-	//	ctx.JSON(http.StatusUnauthorized, errorResponse(err))
-	//	return
-	//}
 	var req updateFileContentRequest
 	if err := ctx.ShouldBindJSON(&req); err != nil {
 		ctx.JSON(http.StatusBadRequest, errorResponse(err))
 		return
 	}
-	file := req.PathStr
-
-	pathFile := "/" + file
-
-	err := ioutil.WriteFile(pathFile, []byte(req.FileStr), 0644)
+	ws, err := server.userWorkspace(ctx)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
 		return
 	}
-
-	res := commandResponse{
-		Message: "Success update file",
+	pathFile, err := ws.resolve(req.PathStr, accessReadWrite)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
 	}
 
-	ctx.JSON(http.StatusOK, res)
-}
+	unlock := server.fileLocks.lock(pathFile)
+	defer unlock()
 
-func (server *Server) isUserHasDir(ctx *gin.Context) (res bool) {
-	authPayload := ctx.MustGet(authorizationPayloadKey).(*token.Payload)
-	req := db.CheckUserDirParams{
-		UserID: authPayload.UserID,
-		Name:   ctx.Param("dir"),
+	current, err := os.ReadFile(pathFile)
+	existed := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
 	}
-	_, err := server.querier.CheckUserDir(ctx, req)
-	if err == nil {
-		return true
+	if !checkExpectedVersion(ctx, req.Version, pathFile, current, existed) {
+		return
 	}
-	return false
-}
 
-func (server *Server) isUserHasDirectory(ctx *gin.Context, userId int64, dirName string) (res bool) {
-	req := db.CheckUserDirParams{
-		UserID: userId,
-		Name:   dirName,
+	text := req.FileStr
+	var diags []formatDiagnostic
+	if req.Format && req.ContentBase64 == "" {
+		text, diags = server.formatOnSave(ctx, pathFile, text)
 	}
-	_, err := server.querier.CheckUserDir(ctx, req)
-	if err == nil {
-		return true
+	data, err := req.encode(text, current, existed)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	release, ok := server.reserveQuota(ctx, pathFile, int64(len(data)-len(current)))
+	if !ok {
+		return
+	}
+	err = writeFileAtomic(pathFile, data)
+	if err != nil {
+		release()
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+	server.recordSave(ctx, ws, pathFile, current, existed, data)
+	server.index.Notify(pathFile)
+
+	res := updateFileContentResponse{
+		Path:        req.PathStr,
+		Message:     "Success update file",
+		Version:     fileVersion(data),
+		Formatted:   text != req.FileStr,
+		Diagnostics: diags,
 	}
-	return false
+	if res.Formatted {
+		res.FileStr = text
+	}
+	ctx.Header("ETag", `"`+res.Version+`"`)
+	ctx.JSON(http.StatusOK, res)
 }
 
 type dirContent struct {
@@ -120,11 +212,96 @@ type dirContent struct {
 	Size     int64  `json:"size"`
 	Path     string `json:"path"`
 	ModTime  string `json:"mod_time"`
+	// the file exceeds the large file size and opens in paged mode
+	Large bool `json:"large"`
+	// permission bits in octal, e.g. "0755"
+	Mode       string `json:"mode"`
+	Owner      string `json:"owner"`
+	Executable bool   `json:"executable"`
+}
+
+// entryID identifies an entry by its absolute path, so the ID of a file
+// stays the same while other entries come and go.
+func entryID(path string) int {
+	h := fnv.New32a()
+	h.Write([]byte(path))
+	return int(h.Sum32())
+}
+
+// newDirContent describes the entry at path. Its path in the response is
+// pathStr, built from the path the client asked for.
+func newDirContent(path, pathStr string, info fs.FileInfo, largeFileSize int64) dirContent {
+	const layoutTime = "2006-01-02 15:04:05"
+	return dirContent{
+		Id:         entryID(path),
+		Filename:   info.Name(),
+		IsDir:      info.IsDir(),
+		Size:       info.Size(),
+		Path:       pathStr,
+		ModTime:    info.ModTime().Format(layoutTime),
+		Large:      !info.IsDir() && info.Size() > largeFileSize,
+		Mode:       formatMode(info.Mode()),
+		Owner:      ownerName(info),
+		Executable: isExecutable(info),
+	}
+}
+
+// listDirContent lists the entries of dirPath, hidden ones only when asked
+// to. Entry paths are built from pathStr so they match the path the client
+// asked for.
+func (server *Server) listDirContent(dirPath, pathStr string, showHidden bool) ([]dirContent, error) {
+	dirs, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []dirContent
+	for _, dir := range dirs {
+		if showHidden || !isHiddenName(dir.Name()) {
+			path := filepath.Join(dirPath, dir.Name())
+			res = append(res, newDirContent(path, pathStr+"/"+dir.Name(), dir, server.config.LargeFileSize))
+		}
+	}
+	return res, nil
 }
 
 type runCommandRequest struct {
 	PathStr  string `json:"path_str" binding:"required"`
 	Username string `json:"username" binding:"required"`
+	// ID to cancel the run with, generated when empty
+	RunID string `json:"run_id" binding:"omitempty,uuid"`
+}
+
+// runTarget resolves the file of a run request into the command running it
+// and its directory. It writes the error response and returns false when
+// the file cannot be run.
+func (server *Server) runTarget(ctx *gin.Context, pathStr string) ([]string, string, bool) {
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return nil, "", false
+	}
+	fullPath, err := ws.resolve(pathStr, accessReadWrite)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return nil, "", false
+	}
+	fileInfo, err := os.Stat(fullPath)
+	if err != nil || fileInfo.IsDir() {
+		err = errors.New("Command or file not found.")
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return nil, "", false
+	}
+
+	// files that are not executable themselves go through their language's
+	// runner, e.g. "python3 main.py"
+	command := []string{fullPath}
+	if fileInfo.Mode()&0111 == 0 {
+		if runner := server.fileLanguage(fullPath).Runner; len(runner) > 0 {
+			command = lang.Command(runner, fullPath, 0)
+		}
+	}
+	return command, filepath.Dir(fullPath), true
 }
 
 func (server *Server) RunCommand(ctx *gin.Context) {
@@ -133,24 +310,28 @@ func (server *Server) RunCommand(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, errorResponse(err))
 		return
 	}
+	command, runnerDir, ok := server.runTarget(ctx, req.PathStr)
+	if !ok {
+		return
+	}
 
-	// file
-	fullPath := "/" + req.PathStr
-	runnerArr := strings.Split(fullPath, "/")
-	runnerDir := strings.Join(runnerArr[:(len(runnerArr)-1)], "/")
-	if fileInfo, err := os.Stat(fullPath); err != nil || fileInfo.IsDir() {
-		err = errors.New("Command or file not found.")
-		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+	runCtx, run, finish, ok := server.startRun(ctx, req.RunID, req.PathStr)
+	if !ok {
 		return
 	}
+	defer finish()
 
-	exeCmd := exec.Command(fullPath)
+	exeCmd := newRunCmd(runCtx, command[0], command[1:]...)
 	exeCmd.Dir = runnerDir
 	var out bytes.Buffer
 	var stderr bytes.Buffer
 	exeCmd.Stdout = &out
 	exeCmd.Stderr = &stderr
-	err := exeCmd.Run()
+	err := runCmd(exeCmd)
+	if res, status, stopped := run.failure(runCtx, err); stopped {
+		ctx.JSON(status, res)
+		return
+	}
 	var message string
 	if err != nil {
 		message = stderr.String()
@@ -165,15 +346,29 @@ func (server *Server) RunCommand(ctx *gin.Context) {
 	res := commandResponse{
 		Path:    req.PathStr,
 		Message: message,
+		RunID:   run.ID,
 	}
 
 	ctx.JSON(http.StatusOK, res)
 }
 
+// funcRunner calls one function of a source file with named arguments and
+// returns the call it made together with its output.
+type funcRunner func(ctx context.Context, filePath, funcName string, args map[string]string) (functionCall, msg string, err error)
+
+// funcRunners are the built-in function runners languages refer to.
+var funcRunners = map[string]funcRunner{
+	"go":     runGoFunc,
+	"racket": runRacketFunc,
+	"rust":   runRustFunc,
+}
+
 type runFuncRequest struct {
 	PathStr  string `form:"path_str" binding:"required"`
 	Username string `form:"username" binding:"required"`
 	Args     string `form:"args" binding:"required"`
+	// ID to cancel the run with, generated when empty
+	RunID string `form:"run_id" binding:"omitempty,uuid"`
 }
 
 type getDirFileContentRequest struct {
@@ -182,12 +377,13 @@ type getDirFileContentRequest struct {
 }
 
 type getDirFileContentResponse struct {
-	IsDir    bool         `json:"is_dir"`
-	FileStr  string       `json:"file_str"`
+	IsDir bool `json:"is_dir"`
+	fileContent
 	DirList  []dirContent `json:"dir_list"`
 	Filepath string       `json:"filepath"`
 	Dirpath  string       `json:"dirpath"`
 	Language string       `json:"language"`
+	Version  string       `json:"version,omitempty"`
 }
 
 func (server *Server) GetDirFileContent(ctx *gin.Context) {
@@ -197,19 +393,16 @@ func (server *Server) GetDirFileContent(ctx *gin.Context) {
 		return
 	}
 
-	file := req.PathStr
-
-	// authPayload := ctx.MustGet(authorizationPayloadKey).(*token.Payload)
-
-	// if authPayload.Email != req.Username {
-	// 	err := errors.New("User not authorized to access file/directory.")
-	// 	ctx.JSON(http.StatusBadRequest, errorResponse(err))
-	// 	return
-	// }
-
-	// file
-	// filePath := server.config.BinPath + "/" + authPayload.Username + "/" + file
-	filePath := "/" + file
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	filePath, err := ws.resolve(req.PathStr, accessReadOnly)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
 
 	info, err := os.Stat(filePath)
 	if os.IsNotExist(err) {
@@ -218,30 +411,20 @@ func (server *Server) GetDirFileContent(ctx *gin.Context) {
 	}
 	var res getDirFileContentResponse
 	if info.IsDir() {
-		dirs, err := ioutil.ReadDir(filePath)
+		dirList, err := server.listDirContent(filePath, req.PathStr, false)
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, errorResponse(err))
 			return
 		}
-
-		var dirList []dirContent
-		const layoutTime = "2006-01-02 15:04:05"
-		for id, dir := range dirs {
-			if !strings.HasPrefix(dir.Name(), ".") {
-				dirList = append(dirList, dirContent{
-					Id:       id,
-					Filename: dir.Name(),
-					IsDir:    dir.IsDir(),
-					Size:     dir.Size(),
-					Path:     req.PathStr + "/" + dir.Name(),
-					ModTime:  dir.ModTime().Format(layoutTime),
-				})
-			}
-		}
 		res.IsDir = true
 		res.DirList = dirList
 		res.Filepath = filePath
 		res.Dirpath = filePath
+	} else if info.Size() > server.config.LargeFileSize {
+		res.fileContent = newLargeFileContent(filePath, info.Size())
+		res.Filepath, err = filepath.Abs(filePath)
+		res.Dirpath = filepath.Dir(filePath)
+		res.Language = server.fileLanguage(filePath).Name
 	} else {
 		fileString, err := os.ReadFile(filePath)
 		if err != nil {
@@ -249,29 +432,38 @@ func (server *Server) GetDirFileContent(ctx *gin.Context) {
 			return
 		}
 		res.IsDir = false
-		res.FileStr = strings.Trim(string(fileString), " ")
+		res.fileContent = newFileContent(filePath, fileString)
+		res.Version = fileVersion(fileString)
+		ctx.Header("ETag", `"`+res.Version+`"`)
 		res.Filepath, err = filepath.Abs(filePath)
 		res.Dirpath = filepath.Dir(filePath)
-		ext := filepath.Ext(filePath)
-		if ext == ".go" {
-			res.Language = "go"
-		} else if ext == ".rkt" {
-			res.Language = "racket"
-		} else if ext == ".rs" {
-			res.Language = "rust"
-		} else {
-			res.Language = "go"
-		}
+		res.Language = server.languages.Detect(filePath, fileString).Name
 	}
 	ctx.JSON(http.StatusOK, res)
 }
 
+const (
+	defaultCodebaseLimit = 100
+	maxCodebaseSkipped   = 100
+)
+
 type getCodebaseRequest struct {
 	PathStr  string `json:"path_str" binding:"required"`
 	Username string `json:"username" binding:"required"`
+	// relative path of the last file of the previous page
+	Cursor string `json:"cursor"`
+	Limit  int    `json:"limit" binding:"min=0,max=10000"`
+	// budget for the content of the page, capped by the configured maximum
+	MaxBytes int64 `json:"max_bytes" binding:"min=0"`
+	// larger files are skipped, by default those above the large file size
+	MaxFileSize int64 `json:"max_file_size" binding:"min=0"`
+	// names of the languages to include; all but plaintext by default
+	Languages []string `json:"languages"`
+	// list the files without their content
+	ManifestOnly bool `json:"manifest_only"`
 }
 
-type getCodebaseResponse struct {
+type codebaseFile struct {
 	Id       int    `json:"id"`
 	Filename string `json:"filename"`
 	FileStr  string `json:"file_str"`
@@ -283,81 +475,145 @@ type getCodebaseResponse struct {
 	ModTime  string `json:"mod_time"`
 }
 
+type codebaseSkippedFile struct {
+	Filepath string `json:"filepath"`
+	// "too_large" or "not_text"
+	Reason string `json:"reason"`
+}
+
+type getCodebaseResponse struct {
+	Files []codebaseFile `json:"files"`
+	// more files follow; pass NextCursor as cursor to get them
+	Truncated  bool   `json:"truncated"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	// "limit" or "max_bytes"
+	TruncatedBy string                `json:"truncated_by,omitempty"`
+	TotalBytes  int64                 `json:"total_bytes"`
+	Skipped     []codebaseSkippedFile `json:"skipped"`
+}
+
+// GetCodebase returns the source files below a directory, a page at a time.
+// Files are ordered by path and filtered by language and ignore rules; a page
+// ends at the file limit or when the next file would exceed the byte budget.
 func (server *Server) GetCodebase(ctx *gin.Context) {
 	var req getCodebaseRequest
 	if err := ctx.ShouldBindJSON(&req); err != nil {
 		ctx.JSON(http.StatusBadRequest, errorResponse(err))
 		return
 	}
+	if req.Limit == 0 {
+		req.Limit = defaultCodebaseLimit
+	}
+	if req.MaxBytes == 0 || req.MaxBytes > server.config.CodebaseMaxBytes {
+		req.MaxBytes = server.config.CodebaseMaxBytes
+	}
+	if req.MaxFileSize == 0 {
+		req.MaxFileSize = server.config.LargeFileSize
+	}
+	if req.MaxFileSize > req.MaxBytes {
+		// every file that is not skipped fits in an empty page
+		req.MaxFileSize = req.MaxBytes
+	}
+	languages := make(map[string]bool)
+	for _, name := range req.Languages {
+		if _, ok := server.languages.Lookup(name); !ok && name != lang.Plaintext.Name {
+			err := fmt.Errorf("Unknown language %s.", name)
+			ctx.JSON(http.StatusBadRequest, errorResponse(err))
+			return
+		}
+		languages[name] = true
+	}
 
-	// authPayload := ctx.MustGet(authorizationPayloadKey).(*token.Payload)
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	dirPath, err := ws.resolve(req.PathStr, accessReadOnly)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+
+	files := server.listFiles(ws, dirPath)
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].rel < files[j].rel
+	})
+	ignore := newIgnoreCache(ws.rootOf(dirPath), dirPath)
 
-	// directory path
-	dirPath := "/" + req.PathStr
-	var res []getCodebaseResponse
+	res := getCodebaseResponse{Files: []codebaseFile{}, Skipped: []codebaseSkippedFile{}}
 	const layoutTime = "2006-01-02 15:04:05"
-	const limitFiles = 10
+	skip := func(file workspaceFile, reason string) {
+		if len(res.Skipped) < maxCodebaseSkipped {
+			res.Skipped = append(res.Skipped, codebaseSkippedFile{Filepath: file.path, Reason: reason})
+		}
+	}
+
+	for _, file := range files {
+		if req.Cursor != "" && file.rel <= req.Cursor {
+			continue
+		}
+		if file.binary || ignore.match(file.path) {
+			continue
+		}
+		language := server.fileLanguage(file.path)
+		if len(languages) > 0 && !languages[language.Name] || len(languages) == 0 && language == lang.Plaintext {
+			continue
+		}
+
+		if len(res.Files) >= req.Limit {
+			res.Truncated = true
+			res.TruncatedBy = "limit"
+			break
+		}
+		if file.size > req.MaxFileSize {
+			skip(file, "too_large")
+			continue
+		}
+		if !req.ManifestOnly && res.TotalBytes+file.size > req.MaxBytes {
+			res.Truncated = true
+			res.TruncatedBy = "max_bytes"
+			break
+		}
 
-	err := filepath.Walk(dirPath,
-		func(path string, info os.FileInfo, err error) error {
+		entry := codebaseFile{
+			Id:       entryID(file.path),
+			Filename: filepath.Base(file.path),
+			IsDir:    false,
+			Size:     file.size,
+			Filepath: file.path,
+			ModTime:  file.modTime.Format(layoutTime),
+			Dirpath:  filepath.Dir(file.path),
+			Language: language.Name,
+		}
+		if !req.ManifestOnly {
+			fileString, err := os.ReadFile(file.path)
 			if err != nil {
-				ctx.JSON(http.StatusInternalServerError, errorResponse(err))
-			}
-			regex := regexp.MustCompile(`/\.`)
-			regex_not_binary := regexp.MustCompile(`.+\..+`)
-			if !info.IsDir() && !regex.MatchString(path) && regex_not_binary.MatchString(path) {
-
-				fileString, err := os.ReadFile(path)
-				if err != nil {
-					ctx.JSON(http.StatusInternalServerError, errorResponse(err))
-				}
-
-				ext := filepath.Ext(path)
-				langfile := "" 
-				if ext == ".go" {
-					langfile = "go"
-				} else if ext == ".rkt" {
-					langfile = "racket"
-				} else if ext == ".rs" {
-					langfile = "rust"
-				}
-
-				if langfile != "" {
-					// list of ignored path
-					ingnorePath := []string{"go/bin", "go/pkg", "vendor"}
-					if !slices.ContainsFunc(ingnorePath, func(n string) bool {
-						return strings.Contains(path, n)
-					}) {
-						res = append(res, getCodebaseResponse{
-							Filename: info.Name(),
-							IsDir:    info.IsDir(),
-							Size:     info.Size(),
-							Filepath: path,
-							ModTime:  info.ModTime().Format(layoutTime),
-							FileStr: strings.Trim(string(fileString), " "),
-							Dirpath: filepath.Dir(path),
-							Language: langfile,
-						})
-					}
-				}
-				
+				continue
 			}
-			if len(res) >= limitFiles {
-				return filepath.SkipAll
+			text, _, ok := util.DecodeText(fileString)
+			if !ok {
+				skip(file, "not_text")
+				continue
 			}
-
-			return nil
-		})
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+			entry.FileStr = text
+			res.TotalBytes += int64(len(fileString))
+		} else {
+			res.TotalBytes += file.size
+		}
+		res.Files = append(res.Files, entry)
+		res.NextCursor = file.rel
+	}
+	if !res.Truncated {
+		res.NextCursor = ""
 	}
 
 	ctx.JSON(http.StatusOK, res)
-
 }
 
 type getDirContentRequest struct {
-	PathStr string `json:"path_str" binding:"required"`
+	PathStr    string `json:"path_str" binding:"required"`
+	ShowHidden bool   `json:"show_hidden"`
 }
 
 func (server *Server) GetDirContent(ctx *gin.Context) {
@@ -367,28 +623,21 @@ func (server *Server) GetDirContent(ctx *gin.Context) {
 		return
 	}
 
-	// authPayload := ctx.MustGet(authorizationPayloadKey).(*token.Payload)
-
-	// directory path
-	dirPath := "/" + req.PathStr
-	dirs, err := ioutil.ReadDir(dirPath)
+	ws, err := server.userWorkspace(ctx)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	dirPath, err := ws.resolve(req.PathStr, accessReadOnly)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
 	}
 
-	var res []dirContent
-	const layoutTime = "2006-01-02 15:04:05"
-	for id, dir := range dirs {
-		if !strings.HasPrefix(dir.Name(), ".") {
-			res = append(res, dirContent{
-				Id:       id,
-				Filename: dir.Name(),
-				IsDir:    dir.IsDir(),
-				Size:     dir.Size(),
-				Path:     req.PathStr + "/" + dir.Name(),
-				ModTime:  dir.ModTime().Format(layoutTime),
-			})
-		}
+	res, err := server.listDirContent(dirPath, req.PathStr, req.ShowHidden)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
 	}
 	ctx.JSON(http.StatusOK, res)
 }
@@ -413,36 +662,37 @@ func (server *Server) GetAllFiles(ctx *gin.Context) {
 		return
 	}
 
-	// authPayload := ctx.MustGet(authorizationPayloadKey).(*token.Payload)
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	dirPath, err := ws.resolve(req.PathStr, accessReadOnly)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+
+	regexTerm, err := regexp.Compile(req.Term)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
 
-	// directory path
-	dirPath := "/" + req.PathStr
 	var res []getAllFilesResponse
 	const layoutTime = "2006-01-02 15:04:05"
 
-	err := filepath.Walk(dirPath,
-		func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				ctx.JSON(http.StatusInternalServerError, errorResponse(err))
-			}
-			regex := regexp.MustCompile(`/\.`)
-			regexTerm := regexp.MustCompile(req.Term)
-			regex_not_binary := regexp.MustCompile(`.+\..+`)
-			if !info.IsDir() && !regex.MatchString(path) && regexTerm.MatchString(info.Name()) && regex_not_binary.MatchString(path) {
-				res = append(res, getAllFilesResponse{
-					Filename: info.Name(),
-					IsDir:    info.IsDir(),
-					Size:     info.Size(),
-					Path:     path,
-					ModTime:  info.ModTime().Format(layoutTime),
-				})
-			}
-
-			return nil
-
-		})
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+	for _, file := range server.listFiles(ws, dirPath) {
+		name := filepath.Base(file.path)
+		if regexTerm.MatchString(name) && !file.binary {
+			res = append(res, getAllFilesResponse{
+				Filename: name,
+				IsDir:    false,
+				Size:     file.size,
+				Path:     file.path,
+				ModTime:  file.modTime.Format(layoutTime),
+			})
+		}
 	}
 
 	ctx.JSON(http.StatusOK, res)
@@ -459,191 +709,52 @@ func (server *Server) RunFunc(ctx *gin.Context) {
 	args := make(map[string]string)
 	json.Unmarshal([]byte(req.Args), &args)
 
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+
 	fileArr := strings.Split(req.PathStr, "/")
-	fileDir := "/" + strings.Join(fileArr[:(len(fileArr)-2)], "/") + "/"
-	filePath := "/" + strings.Join(fileArr[:(len(fileArr)-1)], "/")
-	fileName := fileArr[(len(fileArr) - 2)]
 	funcName := fileArr[(len(fileArr) - 1)]
-
+	filePath, err := ws.resolve(strings.Join(fileArr[:(len(fileArr)-1)], "/"), accessReadWrite)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
 	if _, err := os.Stat(filePath); errors.Is(err, os.ErrNotExist) {
 		ctx.JSON(http.StatusBadRequest, errorResponse(err))
 		return
 	}
 
-	msg := ""
-	functionCall := ""
-
-	isGoFile, _ := regexp.Match("\\.go$", []byte(filePath))
-	if isGoFile {
-		packageName, _, err := goExtractPackage(fileDir)
-		if err != nil {
-			ctx.JSON(http.StatusBadRequest, errorResponse(err))
-			return
-		}
-
-		paramFunc, err := goExtractFuncDef(filePath, funcName)
-		if err != nil {
-			ctx.JSON(http.StatusBadRequest, errorResponse(err))
-			return
-		}
-
-		if len(args) != len(paramFunc) {
-			err = errors.New("Not enough arguments in call to function.")
-			ctx.JSON(http.StatusBadRequest, errorResponse(err))
-			return
-		}
-
-		argsStr := ""
-		for _, v := range paramFunc {
-			value, ok := args[v]
-			if !ok {
-				err = errors.New("Not enough arguments in call to function")
-				ctx.JSON(http.StatusBadRequest, errorResponse(err))
-				return
-			}
-			if _, err := strconv.Atoi(value); err != nil {
-				argsStr += "\"" + value + "\","
-			} else {
-				argsStr += value + ","
-			}
-		}
-
-		if last := len(argsStr) - 1; last >= 0 && argsStr[last] == ',' {
-			argsStr = argsStr[:last]
-		}
-
-		functionCall = funcName + "(" + argsStr + ")"
-
-		testRandomName := randString(10)
-		testFileName := fileDir + testRandomName + "_test.go"
-		fileContent := fmt.Sprintf(templateString, packageName, testRandomName, functionCall)
-		err = goGenerateAndFmtFile(testFileName, fileDir, fileContent)
-		if err != nil {
-			ctx.JSON(http.StatusBadRequest, errorResponse(err))
-			return
-		}
-		defer os.Remove(testFileName)
-
-		msg, err = goRunFile(testRandomName, testFileName, fileDir)
-		if err != nil {
-			ctx.JSON(http.StatusBadRequest, errorResponse(err))
-			return
-		}
-	} else {
-		isRktFile, _ := regexp.Match("\\.rkt$", []byte(filePath))
-		if isRktFile {
-			paramFunc, err := rktGetArgs(filePath, funcName)
-			if err != nil {
-				ctx.JSON(http.StatusBadRequest, errorResponse(err))
-				return
-			}
-
-			if len(args) != len(paramFunc) {
-				err = errors.New("Not enough arguments in call to function.")
-				ctx.JSON(http.StatusBadRequest, errorResponse(err))
-				return
-			}
-
-			argsStr := ""
-			for _, v := range paramFunc {
-				value, ok := args[v]
-				if !ok {
-					err = errors.New("Not enough arguments in call to function")
-					ctx.JSON(http.StatusBadRequest, errorResponse(err))
-					return
-				}
-				if _, err := strconv.Atoi(value); err != nil {
-					argsStr += "\"" + value + "\" "
-				} else {
-					argsStr += value + " "
-				}
-			}
-
-			functionCall = funcName + " " + argsStr
-			testRandomName := randString(10)
-			testFileName := fileDir + testRandomName + ".rkt"
-			fileContent := fmt.Sprintf(rktTemplateString, fileName, functionCall)
-			err = rktGenerateFile(testFileName, fileContent)
-			if err != nil {
-				ctx.JSON(http.StatusBadRequest, errorResponse(err))
-				return
-			}
-			defer os.Remove(testFileName)
+	language := server.fileLanguage(filePath)
+	runFunc, ok := funcRunners[language.FuncRunner]
+	if !ok {
+		err := fmt.Errorf("Running functions of %s files is not supported.", language.Name)
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
 
-			msg, err = rktRunFile(testFileName, fileDir)
-			if err != nil {
-				ctx.JSON(http.StatusBadRequest, errorResponse(err))
-				return
-			}
-		} else {
-			isRsFile, _ := regexp.Match("\\.rs$", []byte(filePath))
-			if isRsFile {
-
-				paramFunc, err := rsGetArgs(filePath, funcName)
-				if err != nil {
-					ctx.JSON(http.StatusBadRequest, errorResponse(err))
-					return
-				}
-
-				if len(args) != len(paramFunc) {
-					err = errors.New("Not enough arguments in call to function.")
-					ctx.JSON(http.StatusBadRequest, errorResponse(err))
-					return
-				}
-
-				argsStr := ""
-				for _, v := range paramFunc {
-					value, ok := args[v]
-					if !ok {
-						err = errors.New("Not enough arguments in call to function")
-						ctx.JSON(http.StatusBadRequest, errorResponse(err))
-						return
-					}
-					if _, err := strconv.Atoi(value); err != nil {
-						argsStr += "\"" + value + "\" "
-					} else {
-						argsStr += value + ","
-					}
-				}
-
-				if last := len(argsStr) - 1; last >= 0 && argsStr[last] == ',' {
-					argsStr = argsStr[:last]
-				}
-
-				functionCall = funcName + "(" + argsStr + ")"
-				testRandomName := randString(10)
-				testFileName := fileDir + testRandomName + ".rs"
-				binName := fileName[:len(fileName)-3]
-				fileContent := fmt.Sprintf(rsTemplateString, binName, binName, functionCall)
-				err = rsGenerateFile(testFileName, fileContent)
-				if err != nil {
-					ctx.JSON(http.StatusBadRequest, errorResponse(err))
-					return
-				}
-				defer os.Remove(testFileName)
-				defer os.Remove(fileDir + testRandomName)
-
-				fmt.Println("testFileName : " + testFileName)
-				fmt.Println("testRandomName : " + testRandomName)
-
-				msg, err = rsRunFile(testFileName, testRandomName, fileDir)
-				if err != nil {
-					ctx.JSON(http.StatusBadRequest, errorResponse(err))
-					return
-				}
-
-			} else {
-				err := errors.New("File's not found.")
-				ctx.JSON(http.StatusBadRequest, errorResponse(err))
-				return
-			}
+	runCtx, run, finish, ok := server.startRun(ctx, req.RunID, req.PathStr)
+	if !ok {
+		return
+	}
+	defer finish()
 
-		}
+	functionCall, msg, err := runFunc(runCtx, filePath, funcName, args)
+	if res, status, stopped := run.failure(runCtx, err); stopped {
+		ctx.JSON(status, res)
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
 	}
 
 	res := commandResponse{
 		Path:    functionCall,
 		Message: msg,
+		RunID:   run.ID,
 	}
 
 	ctx.JSON(http.StatusOK, res)
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	db "github.com/diantanjung/wecom/db/sqlc"
+	"github.com/gin-gonic/gin"
+)
+
+type dirGrantResponse struct {
+	DirID     int64     `json:"dir_id"`
+	Path      string    `json:"path"`
+	UserID    int64     `json:"user_id"`
+	Access    string    `json:"access"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newDirGrantResponse(dir db.Directory) dirGrantResponse {
+	return dirGrantResponse{
+		DirID:     dir.DirID,
+		Path:      dir.Name,
+		UserID:    dir.UserID,
+		Access:    dir.Access,
+		CreatedAt: dir.CreatedAt,
+	}
+}
+
+// ListUserDirs returns the directories registered for or shared with the
+// authenticated user.
+func (server *Server) ListUserDirs(ctx *gin.Context) {
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+
+	dirs, err := server.querier.GetUserDirs(ctx, ws.userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+
+	res := []dirGrantResponse{}
+	for _, dir := range dirs {
+		res = append(res, newDirGrantResponse(dir))
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+type listDirGrantsRequest struct {
+	PathStr string `json:"path_str" binding:"required"`
+}
+
+// ListDirGrants returns every grant on a directory the caller owns.
+func (server *Server) ListDirGrants(ctx *gin.Context) {
+	var req listDirGrantsRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	dirPath, err := ws.resolve(req.PathStr, accessOwner)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+
+	dirs, err := server.querier.GetDirGrants(ctx, dirPath)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+
+	res := []dirGrantResponse{}
+	for _, dir := range dirs {
+		res = append(res, newDirGrantResponse(dir))
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+type shareDirRequest struct {
+	PathStr  string `json:"path_str" binding:"required"`
+	Username string `json:"username" binding:"required"`
+	Access   string `json:"access" binding:"required,oneof=owner read-write read-only"`
+}
+
+// ShareDir grants another user access to a directory the caller owns, or
+// changes the level of an existing grant.
+func (server *Server) ShareDir(ctx *gin.Context) {
+	var req shareDirRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	dirPath, err := ws.resolve(req.PathStr, accessOwner)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	if info, err := os.Stat(dirPath); err != nil || !info.IsDir() {
+		err = errors.New("Only directories can be shared.")
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	user, err := server.querier.GetUser(ctx, req.Username)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			ctx.JSON(http.StatusNotFound, errorResponse(err))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+
+	dir, err := server.querier.UpdateUserDirAccess(ctx, db.UpdateUserDirAccessParams{
+		UserID: user.UserID,
+		Name:   dirPath,
+		Access: req.Access,
+	})
+	if err == sql.ErrNoRows {
+		dir, err = server.querier.CreateUserDir(ctx, db.CreateUserDirParams{
+			Name:   dirPath,
+			UserID: user.UserID,
+			Access: req.Access,
+		})
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, newDirGrantResponse(dir))
+}
+
+type unshareDirRequest struct {
+	PathStr  string `json:"path_str" binding:"required"`
+	Username string `json:"username" binding:"required"`
+}
+
+// UnshareDir revokes a grant on a directory the caller owns.
+func (server *Server) UnshareDir(ctx *gin.Context) {
+	var req unshareDirRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	dirPath, err := ws.resolve(req.PathStr, accessOwner)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+
+	user, err := server.querier.GetUser(ctx, req.Username)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			ctx.JSON(http.StatusNotFound, errorResponse(err))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+
+	err = server.querier.DeleteUserDir(ctx, db.DeleteUserDirParams{
+		UserID: user.UserID,
+		Name:   dirPath,
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+
+	res := commandResponse{
+		Path:    dirPath,
+		Message: "Success revoke access",
+	}
+	ctx.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/diantanjung/wecom/util"
+)
+
+// maxInlineBinarySize bounds binary files returned base64 encoded. Larger
+// ones are described by their metadata only and fetched with /download.
+const maxInlineBinarySize = 10 << 20
+
+// fileContent is the content of an opened file. Text is decoded to UTF-8
+// with "\n" line endings, and the format it was stored in is reported so a
+// save can write it back the same way. Binary files come base64 encoded.
+type fileContent struct {
+	FileStr       string `json:"file_str"`
+	ContentBase64 string `json:"content_base64,omitempty"`
+	Binary        bool   `json:"binary"`
+	MimeType      string `json:"mime_type"`
+	Encoding      string `json:"encoding,omitempty"`
+	BOM           bool   `json:"bom"`
+	LineEnding    string `json:"line_ending,omitempty"`
+	Size          int64  `json:"size"`
+	// the content can be shown as an image
+	Preview bool `json:"preview"`
+	// the file is too large to be opened as a whole and comes without
+	// content; the editor pages through it read-only
+	Large bool `json:"large"`
+}
+
+func newFileContent(path string, data []byte) fileContent {
+	res := fileContent{
+		MimeType: detectMimeType(path, data),
+		Size:     int64(len(data)),
+	}
+	res.Preview = strings.HasPrefix(res.MimeType, "image/")
+
+	if text, format, ok := util.DecodeText(data); ok {
+		res.FileStr = text
+		res.Encoding = format.Encoding
+		res.BOM = format.BOM
+		res.LineEnding = format.LineEnding
+		return res
+	}
+
+	res.Binary = true
+	if len(data) <= maxInlineBinarySize {
+		res.ContentBase64 = base64.StdEncoding.EncodeToString(data)
+	} else {
+		res.Preview = false
+	}
+	return res
+}
+
+// detectMimeType returns the MIME type of a file from its extension or,
+// failing that, from its content. Parameters such as charset are dropped
+// since the content sniffing cannot tell legacy encodings apart.
+func detectMimeType(path string, data []byte) string {
+	mimeType := mime.TypeByExtension(filepath.Ext(path))
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+	if mediaType, _, err := mime.ParseMediaType(mimeType); err == nil {
+		return mediaType
+	}
+	return mimeType
+}
+
+// fileEncodingRequest are the fields of a save telling how to store the
+// content. Unset fields keep the format of the existing file, so opening and
+// saving a file leaves its bytes unchanged.
+type fileEncodingRequest struct {
+	Encoding   string `json:"encoding"`
+	BOM        *bool  `json:"bom"`
+	LineEnding string `json:"line_ending" binding:"omitempty,oneof=lf crlf"`
+	// raw content replacing the text, for binary files
+	ContentBase64 string `json:"content_base64"`
+}
+
+// encode returns the bytes to store for text given the current content of
+// the file.
+func (req fileEncodingRequest) encode(text string, current []byte, existed bool) ([]byte, error) {
+	if req.ContentBase64 != "" {
+		data, err := base64.StdEncoding.DecodeString(req.ContentBase64)
+		if err != nil {
+			return nil, errors.New("Content is not valid base64.")
+		}
+		return data, nil
+	}
+
+	format := util.TextFormat{Encoding: "utf-8", LineEnding: util.LineEndingLF}
+	if existed {
+		if _, currentFormat, ok := util.DecodeText(current); ok {
+			format = currentFormat
+		}
+	}
+	if req.Encoding != "" {
+		format.Encoding = req.Encoding
+	}
+	if req.BOM != nil {
+		format.BOM = *req.BOM
+	}
+	if req.LineEnding != "" {
+		format.LineEnding = req.LineEnding
+	}
+
+	return util.EncodeText(text, format)
+}
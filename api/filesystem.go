@@ -0,0 +1,457 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	db "github.com/diantanjung/wecom/db/sqlc"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	errDestinationExists = errors.New("Destination already exists.")
+	errWorkspaceRoot     = errors.New("Workspace roots cannot be modified.")
+)
+
+type dirListing struct {
+	Dirpath string       `json:"dirpath"`
+	DirList []dirContent `json:"dir_list"`
+}
+
+type fileMutationResponse struct {
+	Path    string       `json:"path"`
+	Message string       `json:"message"`
+	Dirs    []dirListing `json:"dirs"`
+	// the trash item a deleted path was moved to
+	TrashID string `json:"trash_id,omitempty"`
+}
+
+// newFileMutationResponse lists every directory touched by a mutation so the
+// file tree can refresh without another request.
+func (server *Server) newFileMutationResponse(path, message string, dirPaths ...string) (fileMutationResponse, error) {
+	res := fileMutationResponse{
+		Path:    strings.TrimPrefix(path, "/"),
+		Message: message,
+	}
+	seen := make(map[string]bool)
+	for _, dirPath := range dirPaths {
+		if seen[dirPath] {
+			continue
+		}
+		seen[dirPath] = true
+
+		dirList, err := server.listDirContent(dirPath, strings.TrimPrefix(dirPath, "/"), false)
+		if err != nil {
+			return res, err
+		}
+		res.Dirs = append(res.Dirs, dirListing{
+			Dirpath: dirPath,
+			DirList: dirList,
+		})
+	}
+	return res, nil
+}
+
+// isRoot reports whether path is the home or a shared root of the workspace.
+func (ws *workspace) isRoot(path string) bool {
+	for _, root := range ws.roots {
+		if root.path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// prepareDestination checks that dst can be written, moving an existing
+// entry to the trash when overwrite is set.
+func (server *Server) prepareDestination(ws *workspace, dst string, overwrite bool) error {
+	if _, err := os.Lstat(dst); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !overwrite {
+		return errDestinationExists
+	}
+	_, err := server.discardPath(ws, dst)
+	return err
+}
+
+func mutationErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errDestinationExists), errors.Is(err, syscall.ENOTEMPTY):
+		return http.StatusConflict
+	case errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+type createFileRequest struct {
+	PathStr   string `json:"path_str" binding:"required"`
+	FileStr   string `json:"file_str"`
+	Overwrite bool   `json:"overwrite"`
+	fileEncodingRequest
+}
+
+// CreateFile creates a new file, failing when it already exists unless
+// overwrite is set.
+func (server *Server) CreateFile(ctx *gin.Context) {
+	var req createFileRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	filePath, err := ws.resolve(req.PathStr, accessReadWrite)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+
+	data, err := req.encode(req.FileStr, nil, false)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	growth := int64(len(data))
+	if req.Overwrite {
+		growth = fileGrowth(filePath, growth)
+	}
+	release, ok := server.reserveQuota(ctx, filePath, growth)
+	if !ok {
+		return
+	}
+	if err := server.prepareDestination(ws, filePath, req.Overwrite); err != nil {
+		release()
+		ctx.JSON(mutationErrorStatus(err), errorResponse(err))
+		return
+	}
+	if err := os.WriteFile(filePath, data, 0644); err != nil {
+		release()
+		ctx.JSON(mutationErrorStatus(err), errorResponse(err))
+		return
+	}
+	server.index.Notify(filePath)
+	if err := chownLikeParent(filePath); err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+
+	res, err := server.newFileMutationResponse(filePath, "Success create file", filepath.Dir(filePath))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+type makeDirRequest struct {
+	PathStr string `json:"path_str" binding:"required"`
+	Parents bool   `json:"parents"`
+}
+
+// MakeDir creates a directory, and its missing parents when requested.
+func (server *Server) MakeDir(ctx *gin.Context) {
+	var req makeDirRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	dirPath, err := ws.resolve(req.PathStr, accessReadWrite)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+
+	if _, err := os.Lstat(dirPath); err == nil {
+		ctx.JSON(http.StatusConflict, errorResponse(errDestinationExists))
+		return
+	}
+
+	// remember the first missing ancestor so every created level gets chowned
+	created := []string{dirPath}
+	if req.Parents {
+		for parent := filepath.Dir(dirPath); ; parent = filepath.Dir(parent) {
+			if _, err := os.Stat(parent); err == nil {
+				break
+			}
+			created = append([]string{parent}, created...)
+		}
+		err = os.MkdirAll(dirPath, 0755)
+	} else {
+		err = os.Mkdir(dirPath, 0755)
+	}
+	if err != nil {
+		ctx.JSON(mutationErrorStatus(err), errorResponse(err))
+		return
+	}
+	server.index.Notify(created[0])
+	for _, path := range created {
+		if err := chownLikeParent(path); err != nil {
+			ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+			return
+		}
+	}
+
+	res, err := server.newFileMutationResponse(dirPath, "Success create directory", filepath.Dir(created[0]))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+type renamePathRequest struct {
+	PathStr   string `json:"path_str" binding:"required"`
+	NewName   string `json:"new_name" binding:"required"`
+	Overwrite bool   `json:"overwrite"`
+}
+
+// RenamePath renames a file or directory inside its parent directory.
+func (server *Server) RenamePath(ctx *gin.Context) {
+	var req renamePathRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	if req.NewName == "." || req.NewName == ".." || strings.ContainsAny(req.NewName, "/\x00") {
+		err := errors.New("Invalid file name.")
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	server.movePath(ctx, req.PathStr, filepath.Join(filepath.Dir("/"+req.PathStr), req.NewName), req.Overwrite)
+}
+
+type movePathRequest struct {
+	PathStr   string `json:"path_str" binding:"required"`
+	DestStr   string `json:"dest_str" binding:"required"`
+	Overwrite bool   `json:"overwrite"`
+}
+
+// MovePath moves a file or directory to a new path, possibly in another
+// directory of the workspace.
+func (server *Server) MovePath(ctx *gin.Context) {
+	var req movePathRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	server.movePath(ctx, req.PathStr, req.DestStr, req.Overwrite)
+}
+
+func (server *Server) movePath(ctx *gin.Context, pathStr, destStr string, overwrite bool) {
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	srcPath, err := ws.resolve(pathStr, accessReadWrite)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	dstPath, err := ws.resolve(destStr, accessReadWrite)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+
+	if ws.isRoot(srcPath) || ws.isRoot(dstPath) {
+		ctx.JSON(http.StatusBadRequest, errorResponse(errWorkspaceRoot))
+		return
+	}
+	if isWithin(srcPath, dstPath) || isWithin(dstPath, srcPath) {
+		err = errors.New("Cannot move a directory into itself.")
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	if _, err := os.Lstat(srcPath); err != nil {
+		ctx.JSON(mutationErrorStatus(err), errorResponse(err))
+		return
+	}
+	if err := server.prepareDestination(ws, dstPath, overwrite); err != nil {
+		ctx.JSON(mutationErrorStatus(err), errorResponse(err))
+		return
+	}
+	if err := os.Rename(srcPath, dstPath); err != nil {
+		ctx.JSON(mutationErrorStatus(err), errorResponse(err))
+		return
+	}
+	server.index.Notify(srcPath)
+	server.index.Notify(dstPath)
+
+	// keep project registrations and grants pointing at the moved folder
+	err = server.querier.RenameDirs(ctx, db.RenameDirsParams{
+		NewName: dstPath,
+		OldName: srcPath,
+	})
+	if err != nil {
+		log.Println("cannot rename directory grants:", err)
+	}
+
+	res, err := server.newFileMutationResponse(dstPath, "Success move", filepath.Dir(srcPath), filepath.Dir(dstPath))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+type copyPathRequest struct {
+	PathStr   string `json:"path_str" binding:"required"`
+	DestStr   string `json:"dest_str" binding:"required"`
+	Overwrite bool   `json:"overwrite"`
+}
+
+// CopyPath copies a file or a whole directory tree.
+func (server *Server) CopyPath(ctx *gin.Context) {
+	var req copyPathRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	srcPath, err := ws.resolve(req.PathStr, accessReadOnly)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	dstPath, err := ws.resolve(req.DestStr, accessReadWrite)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+
+	if ws.isRoot(dstPath) {
+		ctx.JSON(http.StatusBadRequest, errorResponse(errWorkspaceRoot))
+		return
+	}
+	if isWithin(srcPath, dstPath) || isWithin(dstPath, srcPath) {
+		err = errors.New("Cannot copy a directory into itself.")
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	if _, err := os.Lstat(srcPath); err != nil {
+		ctx.JSON(mutationErrorStatus(err), errorResponse(err))
+		return
+	}
+	release, ok := server.reserveQuota(ctx, dstPath, treeSize(srcPath))
+	if !ok {
+		return
+	}
+	if err := server.prepareDestination(ws, dstPath, req.Overwrite); err != nil {
+		release()
+		ctx.JSON(mutationErrorStatus(err), errorResponse(err))
+		return
+	}
+	if err := copyPath(srcPath, dstPath); err != nil {
+		os.RemoveAll(dstPath)
+		release()
+		ctx.JSON(mutationErrorStatus(err), errorResponse(err))
+		return
+	}
+	server.index.Notify(dstPath)
+
+	res, err := server.newFileMutationResponse(dstPath, "Success copy", filepath.Dir(dstPath))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+type deletePathRequest struct {
+	PathStr   string `json:"path_str" binding:"required"`
+	Recursive bool   `json:"recursive"`
+	// delete for good instead of moving to the trash
+	Permanent bool `json:"permanent"`
+}
+
+// DeletePath moves a file, an empty directory, or a whole directory tree
+// when recursive is set, to the trash of its workspace root. Entries already
+// in the trash, or any path with permanent set, are removed for good.
+func (server *Server) DeletePath(ctx *gin.Context) {
+	var req deletePathRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	path, err := ws.resolve(req.PathStr, accessReadWrite)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	if ws.isRoot(path) {
+		ctx.JSON(http.StatusBadRequest, errorResponse(errWorkspaceRoot))
+		return
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		ctx.JSON(mutationErrorStatus(err), errorResponse(err))
+		return
+	}
+	if info.IsDir() && !req.Recursive {
+		if !dirIsEmpty(path) {
+			err = &os.PathError{Op: "remove", Path: path, Err: syscall.ENOTEMPTY}
+			ctx.JSON(mutationErrorStatus(err), errorResponse(err))
+			return
+		}
+	}
+
+	var item trashItem
+	if req.Permanent {
+		err = os.RemoveAll(path)
+	} else {
+		item, err = server.discardPath(ws, path)
+	}
+	if err != nil {
+		ctx.JSON(mutationErrorStatus(err), errorResponse(err))
+		return
+	}
+	server.index.Notify(path)
+
+	if err := server.querier.DeleteDirs(ctx, path); err != nil {
+		log.Println("cannot delete directory grants:", err)
+	}
+
+	res, err := server.newFileMutationResponse(path, "Success delete", filepath.Dir(path))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+	res.TrashID = item.ID
+	ctx.JSON(http.StatusOK, res)
+}
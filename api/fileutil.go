@@ -0,0 +1,206 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// chownLikeParent gives path the owner and group of its parent directory, so
+// files created by the server belong to the workspace owner instead of root.
+func chownLikeParent(path string) error {
+	// only root can give files away
+	if os.Geteuid() != 0 {
+		return nil
+	}
+	info, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return os.Lchown(path, int(stat.Uid), int(stat.Gid))
+}
+
+// copyPath copies a file, symlink or directory tree from src to dst. Every
+// created entry is owned like its new parent directory.
+func copyPath(src, dst string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		if err := os.Symlink(target, dst); err != nil {
+			return err
+		}
+	case info.IsDir():
+		if err := os.Mkdir(dst, info.Mode().Perm()); err != nil {
+			return err
+		}
+		if err := chownLikeParent(dst); err != nil {
+			return err
+		}
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := copyPath(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		if err := copyFile(src, dst, info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	return chownLikeParent(dst)
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// dirIsEmpty reports whether the directory at path has no entries.
+func dirIsEmpty(path string) bool {
+	dir, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer dir.Close()
+	_, err = dir.Readdirnames(1)
+	return err == io.EOF
+}
+
+// fileVersion is the content hash handed out as the version of a file and
+// expected back in If-Match when saving it.
+func fileVersion(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// writeFileAtomic replaces path with data through a temporary file in the
+// same directory, so readers never see a partially written file. The mode and
+// owner of an existing file are kept.
+func writeFileAtomic(path string, data []byte) error {
+	tmpName, err := stageFile(path, data)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpName)
+	return os.Rename(tmpName, path)
+}
+
+// stageFile writes data to a temporary file next to path, with the mode and
+// owner path has or will get. Renaming it onto path completes the write.
+func stageFile(path string, data []byte) (string, error) {
+	perm := os.FileMode(0644)
+	uid, gid := -1, -1
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			uid, gid = int(stat.Uid), int(stat.Gid)
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	tmpName := tmp.Name()
+	fail := func(err error) (string, error) {
+		os.Remove(tmpName)
+		return "", err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fail(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fail(err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fail(err)
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		return fail(err)
+	}
+	if uid >= 0 && os.Geteuid() == 0 {
+		err = os.Chown(tmpName, uid, gid)
+	} else {
+		err = chownLikeParent(tmpName)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	return tmpName, nil
+}
+
+// pathLocks serializes read-check-write sequences on the same path. Paths
+// are hashed onto a fixed set of mutexes to keep memory bounded.
+type pathLocks struct {
+	mu [64]sync.Mutex
+}
+
+func (locks *pathLocks) index(path string) int {
+	h := fnv.New32a()
+	h.Write([]byte(path))
+	return int(h.Sum32() % uint32(len(locks.mu)))
+}
+
+func (locks *pathLocks) lock(path string) func() {
+	mu := &locks.mu[locks.index(path)]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// lockAll locks several paths at once. Mutexes are taken in index order so
+// concurrent callers cannot deadlock, and each one only once.
+func (locks *pathLocks) lockAll(paths []string) func() {
+	var held [len(pathLocks{}.mu)]bool
+	for _, path := range paths {
+		held[locks.index(path)] = true
+	}
+	for i := range held {
+		if held[i] {
+			locks.mu[i].Lock()
+		}
+	}
+	return func() {
+		for i := range held {
+			if held[i] {
+				locks.mu[i].Unlock()
+			}
+		}
+	}
+}
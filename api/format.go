@@ -0,0 +1,271 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/diantanjung/wecom/lang"
+	"github.com/diantanjung/wecom/util"
+	"github.com/gin-gonic/gin"
+)
+
+// formatTimeout bounds one formatter run.
+const formatTimeout = 30 * time.Second
+
+var errFormatFailed = errors.New("The formatter reported errors.")
+
+// formatDiagnostic is an error reported by a formatter, usually a syntax
+// error. Line and column are 1-based, 0 when the formatter gave none.
+type formatDiagnostic struct {
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+var (
+	// "<standard input>:3:5: expected ')'" from gofmt and goimports, also
+	// used by racket for read errors
+	posDiagnosticRe = regexp.MustCompile(`(?m)^(?:.*?):(\d+):(\d+):?\s*(.+)$`)
+	// "error: unclosed delimiter" followed by " --> <stdin>:2:3" from rustfmt
+	rustDiagnosticRe = regexp.MustCompile(`(?m)^(error|warning)(?:\[\w+\])?: (.+)\n\s*--> .*?:(\d+):(\d+)`)
+)
+
+// parseDiagnostics turns the error output of a formatter into diagnostics.
+// Output in no known shape becomes a single diagnostic without position.
+func parseDiagnostics(stderr string) []formatDiagnostic {
+	var diags []formatDiagnostic
+	for _, m := range rustDiagnosticRe.FindAllStringSubmatch(stderr, -1) {
+		line, _ := strconv.Atoi(m[3])
+		column, _ := strconv.Atoi(m[4])
+		diags = append(diags, formatDiagnostic{Line: line, Column: column, Severity: m[1], Message: m[2]})
+	}
+	if len(diags) > 0 {
+		return diags
+	}
+	for _, m := range posDiagnosticRe.FindAllStringSubmatch(stderr, -1) {
+		line, _ := strconv.Atoi(m[1])
+		column, _ := strconv.Atoi(m[2])
+		diags = append(diags, formatDiagnostic{Line: line, Column: column, Severity: "error", Message: m[3]})
+	}
+	if len(diags) > 0 {
+		return diags
+	}
+	if msg := strings.TrimSpace(stderr); msg != "" {
+		diags = append(diags, formatDiagnostic{Severity: "error", Message: msg})
+	}
+	return diags
+}
+
+// formatSource runs the formatter of language on text, as the run user of
+// ctx. When the formatter rejects the source, the error is errFormatFailed
+// and the diagnostics say why. path is the file the source belongs to.
+func formatSource(ctx context.Context, language *lang.Language, path, text string) (string, []formatDiagnostic, error) {
+	ctx, cancel := context.WithTimeout(ctx, formatTimeout)
+	defer cancel()
+
+	command := lang.Command(language.Formatter, path, 0)
+	cmd := newRunCmd(ctx, command[0], command[1:]...)
+	cmd.Dir = filepath.Dir(path)
+	cmd.Stdin = strings.NewReader(text)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := runCmd(cmd)
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() != nil:
+		return "", nil, errors.New("The formatter timed out.")
+	case errors.As(err, &exitErr):
+		return "", parseDiagnostics(stderr.String()), errFormatFailed
+	case err != nil:
+		return "", nil, fmt.Errorf("cannot run %s: %w", command[0], err)
+	}
+	return stdout.String(), nil, nil
+}
+
+// formatEdit replaces OldLines lines starting at the 1-based StartLine with
+// Text. An edit with no old lines inserts Text before StartLine.
+type formatEdit struct {
+	StartLine int    `json:"start_line"`
+	OldLines  int    `json:"old_lines"`
+	Text      string `json:"text"`
+}
+
+// formatEdits returns the minimal line edits turning before into after.
+func formatEdits(before, after string) []formatEdit {
+	edits := []formatEdit{}
+	line := 1
+	var current *formatEdit
+	for _, edit := range util.DiffLines(util.SplitLines(before), util.SplitLines(after)) {
+		if edit.Op == util.OpEqual {
+			if current != nil {
+				edits = append(edits, *current)
+				current = nil
+			}
+			line++
+			continue
+		}
+		if current == nil {
+			current = &formatEdit{StartLine: line}
+		}
+		if edit.Op == util.OpDelete {
+			current.OldLines++
+			line++
+		} else {
+			current.Text += edit.Text
+		}
+	}
+	if current != nil {
+		edits = append(edits, *current)
+	}
+	return edits
+}
+
+// formatOnSave formats text about to be saved to path. Source the formatter
+// rejects is returned unchanged with the diagnostics, as a save must not be
+// lost to a syntax error.
+func (server *Server) formatOnSave(ctx *gin.Context, path, text string) (string, []formatDiagnostic) {
+	language := server.fileLanguage(path)
+	if len(language.Formatter) == 0 {
+		return text, nil
+	}
+	// formatters read configuration from the user's directories, so they
+	// run as the user like any other code
+	u, err := server.lookupRunUser(server.requestUsername(ctx))
+	if err != nil {
+		return text, []formatDiagnostic{{Severity: "error", Message: err.Error()}}
+	}
+	formatted, diags, err := formatSource(withRunUser(ctx.Request.Context(), u), language, path, text)
+	if err != nil {
+		if !errors.Is(err, errFormatFailed) {
+			diags = []formatDiagnostic{{Severity: "error", Message: err.Error()}}
+		}
+		return text, diags
+	}
+	return formatted, nil
+}
+
+type formatRequest struct {
+	// file to format; it also picks the language and the directory the
+	// formatter runs in when the content comes from file_str
+	PathStr string `json:"path_str" binding:"required_without=Language"`
+	// unsaved buffer to format instead of the file
+	FileStr *string `json:"file_str" binding:"required_without=PathStr"`
+	// language of the buffer, detected from path_str by default
+	Language string `json:"language"`
+	// return the line edits turning the content into the formatted one
+	// rather than the whole formatted content
+	Edits bool `json:"edits"`
+}
+
+type formatResponse struct {
+	Path        string             `json:"path,omitempty"`
+	Language    string             `json:"language"`
+	Changed     bool               `json:"changed"`
+	FileStr     *string            `json:"file_str,omitempty"`
+	Edits       []formatEdit       `json:"edits,omitempty"`
+	Diagnostics []formatDiagnostic `json:"diagnostics"`
+}
+
+type formatErrorResponse struct {
+	Error       string             `json:"error"`
+	Diagnostics []formatDiagnostic `json:"diagnostics"`
+}
+
+// FormatFile formats a file or an unsaved buffer with the formatter of its
+// language. Nothing is written; the client applies the result.
+func (server *Server) FormatFile(ctx *gin.Context) {
+	var req formatRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	// buffers without a file are formatted as if they were in the home
+	path := filepath.Join(ws.root, "buffer")
+	if req.PathStr != "" {
+		path, err = ws.resolve(req.PathStr, accessReadOnly)
+		if err != nil {
+			ctx.JSON(http.StatusForbidden, errorResponse(err))
+			return
+		}
+	}
+
+	var text string
+	if req.FileStr != nil {
+		text = *req.FileStr
+	} else {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			ctx.JSON(mutationErrorStatus(err), errorResponse(err))
+			return
+		}
+		var ok bool
+		if text, _, ok = util.DecodeText(data); !ok {
+			err = errors.New("Binary files cannot be formatted.")
+			ctx.JSON(http.StatusBadRequest, errorResponse(err))
+			return
+		}
+	}
+
+	language := server.fileLanguage(path)
+	if req.Language != "" {
+		var ok bool
+		if language, ok = server.languages.Lookup(req.Language); !ok {
+			err = fmt.Errorf("Unknown language %q.", req.Language)
+			ctx.JSON(http.StatusBadRequest, errorResponse(err))
+			return
+		}
+	}
+	if len(language.Formatter) == 0 {
+		err = fmt.Errorf("No formatter is configured for %s.", language.Name)
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	u, ok := server.requestRunUser(ctx)
+	if !ok {
+		return
+	}
+	formatted, diags, err := formatSource(withRunUser(ctx.Request.Context(), u), language, path, text)
+	if errors.Is(err, errFormatFailed) {
+		ctx.JSON(http.StatusUnprocessableEntity, formatErrorResponse{Error: err.Error(), Diagnostics: diags})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+
+	res := formatResponse{
+		Language:    language.Name,
+		Changed:     formatted != text,
+		Diagnostics: []formatDiagnostic{},
+	}
+	if req.PathStr != "" {
+		res.Path = strings.TrimPrefix(path, "/")
+	}
+	if req.Edits {
+		res.Edits = formatEdits(text, formatted)
+	} else {
+		res.FileStr = &formatted
+	}
+	ctx.JSON(http.StatusOK, res)
+}
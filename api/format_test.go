@@ -0,0 +1,47 @@
+package api
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/diantanjung/wecom/lang"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDiagnostics(t *testing.T) {
+	diags := parseDiagnostics("<standard input>:2:12: expected ')', found '{'\n")
+	require.Equal(t, []formatDiagnostic{{Line: 2, Column: 12, Severity: "error", Message: "expected ')', found '{'"}}, diags)
+
+	diags = parseDiagnostics("error: this file contains an unclosed delimiter\n --> <stdin>:2:3\n  |\n")
+	require.Equal(t, []formatDiagnostic{{Line: 2, Column: 3, Severity: "error", Message: "this file contains an unclosed delimiter"}}, diags)
+
+	diags = parseDiagnostics("something went wrong\n")
+	require.Equal(t, []formatDiagnostic{{Severity: "error", Message: "something went wrong"}}, diags)
+}
+
+func TestFormatEdits(t *testing.T) {
+	before := "package main\nfunc main(){\nx:=1\n}\n"
+	after := "package main\n\nfunc main() {\n\tx := 1\n}\n"
+	require.Equal(t, []formatEdit{
+		{StartLine: 2, OldLines: 2, Text: "\nfunc main() {\n\tx := 1\n"},
+	}, formatEdits(before, after))
+	require.Equal(t, []formatEdit{
+		{StartLine: 1, OldLines: 0, Text: "// Package main.\n"},
+		{StartLine: 4, OldLines: 1, Text: "\tx := 2\n"},
+	}, formatEdits(after, "// Package main.\n"+strings.Replace(after, "x := 1", "x := 2", 1)))
+	require.Empty(t, formatEdits(after, after))
+}
+
+func TestFormatSourceRunsAsUser(t *testing.T) {
+	u := &runUser{username: "bob", uid: uint32(os.Geteuid()), env: []string{"HOME=/home/bob", "PATH=/usr/bin:/bin"}}
+	ctx := withRunUser(context.Background(), u)
+
+	// a formatter printing its environment instead of the source
+	language := &lang.Language{Name: "env", Formatter: []string{"env"}}
+	out, _, err := formatSource(ctx, language, filepath.Join(t.TempDir(), "main.env"), "")
+	require.NoError(t, err)
+	require.ElementsMatch(t, u.env, strings.Fields(out))
+}
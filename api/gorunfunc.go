@@ -2,12 +2,15 @@ package api
 
 import (
 	"bufio"
+	"context"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -143,15 +146,20 @@ func goExtractPackage(dirPath string) (string, string, error) {
 	return packageName, filePath, nil
 }
 
-func goGenerateAndFmtFile(testFileName, testFileDir, fileContent string) error {
+func goGenerateAndFmtFile(ctx context.Context, testFileName, testFileDir, fileContent string) error {
 	err := ioutil.WriteFile(testFileName, []byte(fileContent), 0644)
 	if err != nil {
 		return err
 	}
+	// goimports rewrites the file as the user
+	if err := chownLikeParent(testFileName); err != nil {
+		os.Remove(testFileName)
+		return err
+	}
 
-	cmd := exec.Command("goimports", "-w=true", testFileName)
+	cmd := newRunCmd(ctx, "goimports", "-w=true", testFileName)
 	cmd.Dir = testFileDir
-	output, err := cmd.CombinedOutput()
+	output, err := runCmdOutput(cmd)
 	if len(output) == 0 && err != nil {
 		err = checkRemError(err, testFileName)
 		return err
@@ -159,10 +167,10 @@ func goGenerateAndFmtFile(testFileName, testFileDir, fileContent string) error {
 	return nil
 }
 
-func goRunFile(testname, filename, testFileDir string) (string, error) {
-	cmd := exec.Command("/usr/local/go/bin/go", "test", "--run", testname)
+func goRunFile(ctx context.Context, testname, filename, testFileDir string) (string, error) {
+	cmd := newRunCmd(ctx, "/usr/local/go/bin/go", "test", "--run", testname)
 	cmd.Dir = testFileDir
-	output, err := cmd.CombinedOutput()
+	output, err := runCmdOutput(cmd)
 	stdout := string(output)
 	stdoutLines := strings.Split(stdout, "\n")
 	if len(output) == 0 && err != nil {
@@ -184,3 +192,52 @@ func goRunFile(testname, filename, testFileDir string) (string, error) {
 		return stdout, err
 	}
 }
+
+// runGoFunc calls funcName of a Go file from a generated test.
+func runGoFunc(ctx context.Context, filePath, funcName string, args map[string]string) (string, string, error) {
+	fileDir := filepath.Dir(filePath) + "/"
+	packageName, _, err := goExtractPackage(fileDir)
+	if err != nil {
+		return "", "", err
+	}
+
+	paramFunc, err := goExtractFuncDef(filePath, funcName)
+	if err != nil {
+		return "", "", err
+	}
+
+	if len(args) != len(paramFunc) {
+		return "", "", errors.New("Not enough arguments in call to function.")
+	}
+
+	argsStr := ""
+	for _, v := range paramFunc {
+		value, ok := args[v]
+		if !ok {
+			return "", "", errors.New("Not enough arguments in call to function")
+		}
+		if _, err := strconv.Atoi(value); err != nil {
+			argsStr += "\"" + value + "\","
+		} else {
+			argsStr += value + ","
+		}
+	}
+
+	if last := len(argsStr) - 1; last >= 0 && argsStr[last] == ',' {
+		argsStr = argsStr[:last]
+	}
+
+	functionCall := funcName + "(" + argsStr + ")"
+
+	testRandomName := randString(10)
+	testFileName := fileDir + testRandomName + "_test.go"
+	fileContent := fmt.Sprintf(templateString, packageName, testRandomName, functionCall)
+	err = goGenerateAndFmtFile(ctx, testFileName, fileDir, fileContent)
+	if err != nil {
+		return "", "", err
+	}
+	defer os.Remove(testFileName)
+
+	msg, err := goRunFile(ctx, testRandomName, testFileName, fileDir)
+	return functionCall, msg, err
+}
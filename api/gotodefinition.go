@@ -2,19 +2,24 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strconv"
-	"strings"
+	"time"
 
+	"github.com/diantanjung/wecom/lang"
 	"github.com/gin-gonic/gin"
 )
 
+// definitionTimeout bounds one run of a definition provider.
+const definitionTimeout = 30 * time.Second
+
 type runGodefRequest struct {
 	PathStr  string `json:"path_str" binding:"required"`
 	Offset   int    `json:"offset" binding:"required"`
@@ -22,7 +27,7 @@ type runGodefRequest struct {
 }
 
 type runGodefResponse struct {
-	FileStr    string `json:"file_str"`
+	fileContent
 	Filepath   string `json:"filepath"`
 	Dirpath    string `json:"dirpath"`
 	Language   string `json:"language"`
@@ -38,17 +43,16 @@ func (server *Server) RunGodef(ctx *gin.Context) {
 		return
 	}
 
-	// authPayload := ctx.MustGet(authorizationPayloadKey).(*token.Payload)
-
-	// if authPayload.Email != req.Username {
-	// 	err := errors.New("User not authorized to access file/directory.")
-	// 	ctx.JSON(http.StatusBadRequest, errorResponse(err))
-	// 	return
-	// }
-
-	// file
-	// filePath := server.config.BinPath + "/" + authPayload.Username + "/" + file
-	pathFile := req.PathStr
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	pathFile, err := ws.resolve(req.PathStr, accessReadOnly)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
 
 	info, err := os.Stat(pathFile)
 	if os.IsNotExist(err) {
@@ -69,15 +73,27 @@ func (server *Server) RunGodef(ctx *gin.Context) {
 		return
 	}
 
-	offset := strconv.Itoa(req.Offset)
+	language := server.fileLanguage(pathFile)
+	if len(language.Definition) == 0 {
+		err = fmt.Errorf("No definition provider for %s files.", language.Name)
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
 
-	exeCmd := exec.Command("godef", "-f", pathFile, "-o", offset)
+	u, ok := server.requestRunUser(ctx)
+	if !ok {
+		return
+	}
+	defCtx, cancel := context.WithTimeout(withRunUser(ctx.Request.Context(), u), definitionTimeout)
+	defer cancel()
+	command := lang.Command(language.Definition, pathFile, req.Offset)
+	exeCmd := newRunCmd(defCtx, command[0], command[1:]...)
 	exeCmd.Dir = filepath.Dir(pathFile)
 	var out bytes.Buffer
 	var stderr bytes.Buffer
 	exeCmd.Stdout = &out
 	exeCmd.Stderr = &stderr
-	err = exeCmd.Run()
+	err = runCmd(exeCmd)
 
 	if err != nil {
 		if len(stderr.String()) > 0 {
@@ -91,12 +107,17 @@ func (server *Server) RunGodef(ctx *gin.Context) {
 	regex := regexp.MustCompile(`(.+):([0-9]+):([0-9]+)`)
 	match := regex.FindStringSubmatch(out.String())
 	if len(match) < 4 {
-		err = errors.New("godef : not found definition")
+		err = fmt.Errorf("%s : not found definition", filepath.Base(command[0]))
 		ctx.JSON(http.StatusBadRequest, errorResponse(err))
 		return
 	}
 
-	resPath := match[1]
+	// the definition may live outside of the workspace, e.g. in GOROOT
+	resPath, err := ws.resolve(match[1], accessReadOnly)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
 
 	fileString, err := ioutil.ReadFile(resPath)
 	if err != nil {
@@ -105,19 +126,10 @@ func (server *Server) RunGodef(ctx *gin.Context) {
 	}
 
 	var res runGodefResponse
-	res.FileStr = strings.Trim(string(fileString), " ")
+	res.fileContent = newFileContent(resPath, fileString)
 	res.Filepath, err = filepath.Abs(resPath)
 	res.Dirpath = filepath.Dir(resPath)
-	ext := filepath.Ext(resPath)
-	if ext == ".go" {
-		res.Language = "go"
-	} else if ext == ".rkt" {
-		res.Language = "racket"
-	} else if ext == ".rs" {
-		res.Language = "rust"
-	} else {
-		res.Language = "go"
-	}
+	res.Language = server.languages.Detect(resPath, fileString).Name
 
 	if res.LineNumber, err = strconv.Atoi(match[2]); err != nil {
 		ctx.JSON(http.StatusBadRequest, errorResponse(err))
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"io/fs"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/diantanjung/wecom/index"
+	"github.com/gin-gonic/gin"
+)
+
+const defaultFindFileLimit = 50
+
+// workspaceFile is a visible regular file below a directory.
+type workspaceFile struct {
+	path    string
+	rel     string // slash separated, relative to the listed directory
+	size    int64
+	modTime time.Time
+	binary  bool
+}
+
+// dirIndex returns the index covering dirPath together with the path of
+// dirPath inside of it. It reports false when the directory cannot be served
+// from an index, e.g. because it is hidden or the index is truncated.
+func (server *Server) dirIndex(ws *workspace, dirPath string) (*index.Index, string, bool) {
+	root := ws.rootOf(dirPath)
+	if root == "" {
+		return nil, "", false
+	}
+	rel, err := filepath.Rel(root, dirPath)
+	if err != nil {
+		return nil, "", false
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == "." {
+		rel = ""
+	}
+	for _, elem := range strings.Split(rel, "/") {
+		if isHiddenName(elem) {
+			return nil, "", false
+		}
+	}
+
+	idx, err := server.index.Get(root)
+	if err != nil {
+		log.Println("cannot load index:", err)
+		return nil, "", false
+	}
+	if idx.Truncated() {
+		return nil, "", false
+	}
+	return idx, rel, true
+}
+
+func newWorkspaceFile(idx *index.Index, dir string, file index.File) workspaceFile {
+	rel := file.Path
+	if dir != "" {
+		rel = strings.TrimPrefix(rel, dir+"/")
+	}
+	return workspaceFile{
+		path:    filepath.Join(idx.Root(), filepath.FromSlash(file.Path)),
+		rel:     rel,
+		size:    file.Size,
+		modTime: file.ModTime,
+		binary:  file.Binary,
+	}
+}
+
+// listFiles returns the visible files below dirPath, from the index when
+// possible and by walking the tree otherwise.
+func (server *Server) listFiles(ws *workspace, dirPath string) []workspaceFile {
+	var files []workspaceFile
+	if idx, dir, ok := server.dirIndex(ws, dirPath); ok {
+		for _, file := range idx.Files(dir) {
+			files = append(files, newWorkspaceFile(idx, dir, file))
+		}
+		return files
+	}
+
+	walkVisible(dirPath, func(path, rel string, info fs.FileInfo) error {
+		files = append(files, workspaceFile{
+			path:    path,
+			rel:     rel,
+			size:    info.Size(),
+			modTime: info.ModTime(),
+			binary:  isBinaryFile(path),
+		})
+		return nil
+	})
+	return files
+}
+
+// searchCandidates returns the files below dirPath that may contain matches
+// of opts. It reports false when no index can narrow them down.
+func (server *Server) searchCandidates(ws *workspace, dirPath string, opts searchOptions) ([]workspaceFile, bool) {
+	idx, dir, ok := server.dirIndex(ws, dirPath)
+	if !ok {
+		return nil, false
+	}
+
+	var files []workspaceFile
+	for _, file := range idx.Candidates(dir, opts.literals) {
+		files = append(files, newWorkspaceFile(idx, dir, file))
+	}
+	return files, true
+}
+
+type findFileRequest struct {
+	PathStr string `json:"path_str" binding:"required"`
+	Query   string `json:"query"`
+	Limit   int    `json:"limit" binding:"min=0,max=1000"`
+}
+
+type findFileResponse struct {
+	Filename string `json:"filename"`
+	Path     string `json:"path"`
+	Score    int    `json:"score"`
+}
+
+// FindFile ranks the files below path_str by how well their path matches the
+// query, for the "go to file" box.
+func (server *Server) FindFile(ctx *gin.Context) {
+	var req findFileRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	if req.Limit == 0 {
+		req.Limit = defaultFindFileLimit
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	dirPath, err := ws.resolve(req.PathStr, accessReadOnly)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+
+	root := ws.rootOf(dirPath)
+	idx, err := server.index.Get(root)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+	dir, err := filepath.Rel(root, dirPath)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+	dir = filepath.ToSlash(dir)
+	if dir == "." {
+		dir = ""
+	}
+
+	res := []findFileResponse{}
+	for _, match := range idx.Find(dir, req.Query, req.Limit) {
+		path := filepath.Join(root, filepath.FromSlash(match.Path))
+		res = append(res, findFileResponse{
+			Filename: filepath.Base(path),
+			Path:     strings.TrimPrefix(path, "/"),
+			Score:    match.Score,
+		})
+	}
+	ctx.JSON(http.StatusOK, res)
+}
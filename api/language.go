@@ -0,0 +1,64 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/diantanjung/wecom/lang"
+	"github.com/gin-gonic/gin"
+)
+
+// languageHeadSize is how much of a file is read to find its "#!" line.
+const languageHeadSize = 256
+
+// fileLanguage returns the language of a file, reading its first line only
+// when the name is not enough.
+func (server *Server) fileLanguage(path string) *lang.Language {
+	language := server.languages.Detect(path, nil)
+	if language != lang.Plaintext {
+		return language
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return language
+	}
+	defer f.Close()
+	head := make([]byte, languageHeadSize)
+	n, _ := io.ReadFull(f, head)
+	return server.languages.Detect(path, head[:n])
+}
+
+type languageResponse struct {
+	Name          string        `json:"name"`
+	Extensions    []string      `json:"extensions"`
+	Filenames     []string      `json:"filenames"`
+	Mode          string        `json:"mode"`
+	Comments      lang.Comments `json:"comments"`
+	CanFormat     bool          `json:"can_format"`
+	CanRun        bool          `json:"can_run"`
+	CanRunFunc    bool          `json:"can_run_func"`
+	HasDefinition bool          `json:"has_definition"`
+}
+
+// ListLanguages describes the known languages so the editor can pick modes
+// and comment syntax, and offer only the actions a language supports.
+func (server *Server) ListLanguages(ctx *gin.Context) {
+	res := []languageResponse{}
+	for _, language := range server.languages.Languages() {
+		_, canRunFunc := funcRunners[language.FuncRunner]
+		res = append(res, languageResponse{
+			Name:          language.Name,
+			Extensions:    language.Extensions,
+			Filenames:     language.Filenames,
+			Mode:          language.Mode,
+			Comments:      language.Comments,
+			CanFormat:     len(language.Formatter) > 0,
+			CanRun:        len(language.Runner) > 0,
+			CanRunFunc:    canRunFunc,
+			HasDefinition: len(language.Definition) > 0,
+		})
+	}
+	ctx.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,540 @@
+package api
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// lines longer than this are cut when read by line
+	maxLineLength = 16 << 10
+	// a checkpoint is kept every lineCheckpointEvery lines of a paged file
+	lineCheckpointEvery = 1000
+	// files whose checkpoints are kept
+	lineIndexFiles       = 64
+	defaultTailLines     = 100
+	tailPollInterval     = 500 * time.Millisecond
+	maxTailChunk         = 1 << 20
+	tailReadBlock        = 64 << 10
+	tailHeartbeatTimeout = 15 * time.Second
+)
+
+// newLargeFileContent describes a file too large to be opened as a whole.
+// Only its head is read to tell its type; the content is fetched by range.
+func newLargeFileContent(path string, size int64) fileContent {
+	res := fileContent{Size: size, Large: true}
+	head := make([]byte, binarySniffSize)
+	if f, err := os.Open(path); err == nil {
+		n, _ := io.ReadFull(f, head)
+		head = head[:n]
+		f.Close()
+	}
+	res.MimeType = detectMimeType(path, head)
+	res.Binary = isBinary(head)
+	return res
+}
+
+// openRegularFile opens a file of the workspace for reading, answering the
+// request itself when that fails.
+func openRegularFile(ctx *gin.Context, ws *workspace, pathStr string) (*os.File, os.FileInfo, bool) {
+	path, err := ws.resolve(pathStr, accessReadOnly)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return nil, nil, false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			ctx.JSON(http.StatusNotFound, errorResponse(err))
+			return nil, nil, false
+		}
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return nil, nil, false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return nil, nil, false
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		ctx.JSON(http.StatusBadRequest, errorResponse(errors.New("Path is not a regular file.")))
+		return nil, nil, false
+	}
+	return f, info, true
+}
+
+// reopenTailFile opens the file replacing a followed one. It is resolved
+// again and symlinks are refused, so that swapping the file for a link does
+// not let the stream follow a file outside the workspace.
+func reopenTailFile(ws *workspace, pathStr string) (*os.File, os.FileInfo, error) {
+	path, err := ws.resolve(pathStr, accessReadOnly)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		return nil, nil, errors.New("Path is not a regular file.")
+	}
+	return f, info, nil
+}
+
+// trimPartialRune drops an incomplete UTF-8 sequence from the end of b, so
+// that chunks of a file split at rune boundaries.
+func trimPartialRune(b []byte) []byte {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return b[:i]
+			}
+			break
+		}
+	}
+	return b
+}
+
+type readFileRangeRequest struct {
+	PathStr string `json:"path_str" binding:"required"`
+	Offset  int64  `json:"offset" binding:"min=0"`
+	Length  int64  `json:"length" binding:"required,min=1,max=4194304"`
+}
+
+// fileRangeResponse is a chunk of a file. It is text when the chunk is valid
+// UTF-8 and base64 encoded otherwise. The next chunk starts at Offset+Length,
+// which may be short of the requested end to avoid splitting a character.
+type fileRangeResponse struct {
+	Offset        int64  `json:"offset"`
+	Length        int64  `json:"length"`
+	Size          int64  `json:"size"`
+	EOF           bool   `json:"eof"`
+	FileStr       string `json:"file_str,omitempty"`
+	ContentBase64 string `json:"content_base64,omitempty"`
+}
+
+// ReadFileRange returns a byte range of a file.
+func (server *Server) ReadFileRange(ctx *gin.Context) {
+	var req readFileRangeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	f, info, ok := openRegularFile(ctx, ws, req.PathStr)
+	if !ok {
+		return
+	}
+	defer f.Close()
+
+	res := fileRangeResponse{Offset: req.Offset, Size: info.Size()}
+	buf := make([]byte, req.Length)
+	n, err := f.ReadAt(buf, req.Offset)
+	if err != nil && err != io.EOF {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+	chunk := buf[:n]
+	res.EOF = req.Offset+int64(n) >= res.Size
+
+	if text := trimPartialRune(chunk); len(text) > 0 && utf8.Valid(text) && !isBinary(text) {
+		res.FileStr = string(text)
+		res.Length = int64(len(text))
+	} else {
+		res.ContentBase64 = base64.StdEncoding.EncodeToString(chunk)
+		res.Length = int64(len(chunk))
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+// lineIndex remembers where every lineCheckpointEvery-th line of recently
+// paged files starts, so that a jump to a line scans at most that many lines.
+// The zero value is ready to use.
+type lineIndex struct {
+	mu    sync.Mutex
+	files map[string]*lineCheckpoints
+	order []string
+}
+
+type lineCheckpoints struct {
+	size    int64
+	modTime time.Time
+	// offsets[i] is where line i*lineCheckpointEvery+1 starts
+	offsets []int64
+}
+
+// checkpoints returns the checkpoints of a file. They stay valid while the
+// file only grows, as logs do; a file that shrank starts over.
+func (idx *lineIndex) checkpoints(path string, info os.FileInfo) *lineCheckpoints {
+	if idx.files == nil {
+		idx.files = make(map[string]*lineCheckpoints)
+	}
+	cp, ok := idx.files[path]
+	if ok && (info.Size() < cp.size || info.Size() == cp.size && !info.ModTime().Equal(cp.modTime)) {
+		ok = false
+	}
+	if !ok {
+		if _, known := idx.files[path]; !known {
+			idx.order = append(idx.order, path)
+			if len(idx.order) > lineIndexFiles {
+				delete(idx.files, idx.order[0])
+				idx.order = idx.order[1:]
+			}
+		}
+		cp = &lineCheckpoints{offsets: []int64{0}}
+		idx.files[path] = cp
+	}
+	cp.size = info.Size()
+	cp.modTime = info.ModTime()
+	return cp
+}
+
+// nearest returns the closest known line at or before line and its offset.
+func (idx *lineIndex) nearest(path string, info os.FileInfo, line int) (int, int64) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	cp := idx.checkpoints(path, info)
+	i := (line - 1) / lineCheckpointEvery
+	if i >= len(cp.offsets) {
+		i = len(cp.offsets) - 1
+	}
+	return i*lineCheckpointEvery + 1, cp.offsets[i]
+}
+
+// record notes where a line starts if it is the next checkpoint.
+func (idx *lineIndex) record(path string, info os.FileInfo, line int, offset int64) {
+	if (line-1)%lineCheckpointEvery != 0 {
+		return
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	cp := idx.checkpoints(path, info)
+	if (line-1)/lineCheckpointEvery == len(cp.offsets) {
+		cp.offsets = append(cp.offsets, offset)
+	}
+}
+
+// readLine reads a line without its terminator, keeping at most
+// maxLineLength bytes of it. n is the number of bytes consumed, so n is 0
+// only at the end of the file.
+func readLine(r *bufio.Reader) (line []byte, n int64, truncated bool, err error) {
+	for {
+		chunk, err := r.ReadSlice('\n')
+		n += int64(len(chunk))
+		if room := maxLineLength + 2 - len(line); room > 0 {
+			if len(chunk) > room {
+				chunk = chunk[:room]
+			}
+			line = append(line, chunk...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+
+		if int64(len(line)) == n {
+			if len(line) > 0 && line[len(line)-1] == '\n' {
+				line = line[:len(line)-1]
+				if len(line) > 0 && line[len(line)-1] == '\r' {
+					line = line[:len(line)-1]
+				}
+			}
+		}
+		if len(line) > maxLineLength || int64(len(line)) < n-2 {
+			line = trimPartialRune(line[:maxLineLength])
+			truncated = true
+		}
+		return line, n, truncated, err
+	}
+}
+
+type readFileLinesRequest struct {
+	PathStr   string `json:"path_str" binding:"required"`
+	StartLine int    `json:"start_line" binding:"required,min=1"`
+	LineCount int    `json:"line_count" binding:"required,min=1,max=10000"`
+}
+
+type fileLinesResponse struct {
+	StartLine int      `json:"start_line,omitempty"`
+	Lines     []string `json:"lines"`
+	NextLine  int      `json:"next_line,omitempty"`
+	// byte offsets of the first line and of the line after the last
+	Offset     int64 `json:"offset"`
+	NextOffset int64 `json:"next_offset"`
+	Size       int64 `json:"size"`
+	EOF        bool  `json:"eof"`
+	// some lines were cut at maxLineLength
+	Truncated bool `json:"truncated"`
+}
+
+// ReadFileLines returns a page of lines of a text file, for paging through
+// files too large to open in the editor.
+func (server *Server) ReadFileLines(ctx *gin.Context) {
+	var req readFileLinesRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	f, info, ok := openRegularFile(ctx, ws, req.PathStr)
+	if !ok {
+		return
+	}
+	defer f.Close()
+	path := f.Name()
+
+	line, offset := server.lines.nearest(path, info, req.StartLine)
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+	r := bufio.NewReaderSize(f, tailReadBlock)
+
+	res := fileLinesResponse{StartLine: req.StartLine, Lines: []string{}, Size: info.Size()}
+	for len(res.Lines) < req.LineCount {
+		if line == req.StartLine {
+			res.Offset = offset
+		}
+		text, n, truncated, err := readLine(r)
+		if n == 0 {
+			res.EOF = true
+			break
+		}
+		server.lines.record(path, info, line, offset)
+		if line == 1 && isBinary(text) {
+			ctx.JSON(http.StatusBadRequest, errorResponse(errors.New("File is not a text file.")))
+			return
+		}
+		if line >= req.StartLine {
+			res.Lines = append(res.Lines, string(text))
+			res.Truncated = res.Truncated || truncated
+		}
+		line++
+		offset += n
+		if err != nil {
+			if err != io.EOF {
+				ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+				return
+			}
+			res.EOF = true
+			break
+		}
+	}
+	if line <= req.StartLine {
+		// the file has fewer lines
+		res.Offset = offset
+	}
+	res.NextLine = line
+	res.NextOffset = offset
+	res.EOF = res.EOF || offset >= info.Size()
+	ctx.JSON(http.StatusOK, res)
+}
+
+// lastLinesOffset returns where the last n lines of a file of the given size
+// start. The scan goes back at most n*maxLineLength bytes; when that is not
+// enough the partial line found there is skipped.
+func lastLinesOffset(f *os.File, size int64, n int) (int64, error) {
+	limit := size - int64(n)*maxLineLength
+	if limit < 0 {
+		limit = 0
+	}
+	buf := make([]byte, tailReadBlock)
+	end := size
+	newlines := 0
+	for end > limit {
+		start := end - int64(len(buf))
+		if start < limit {
+			start = limit
+		}
+		chunk := buf[:end-start]
+		if _, err := f.ReadAt(chunk, start); err != nil && err != io.EOF {
+			return 0, err
+		}
+		for i := len(chunk) - 1; i >= 0; i-- {
+			// a newline ending the file does not start another line
+			if chunk[i] != '\n' || start+int64(i) == size-1 {
+				continue
+			}
+			newlines++
+			if newlines == n {
+				return start + int64(i) + 1, nil
+			}
+		}
+		end = start
+	}
+	if limit == 0 {
+		return 0, nil
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(f, limit, size-limit))
+	_, skipped, _, _ := readLine(r)
+	return limit + skipped, nil
+}
+
+type tailFileRequest struct {
+	PathStr string `form:"path_str" binding:"required"`
+	Lines   int    `form:"lines" binding:"omitempty,min=1,max=10000"`
+	Follow  bool   `form:"follow"`
+}
+
+type tailAppendEvent struct {
+	FileStr    string `json:"file_str"`
+	Offset     int64  `json:"offset"`
+	NextOffset int64  `json:"next_offset"`
+}
+
+// TailFile returns the last lines of a file. With follow it streams them as
+// server-sent events instead: a "lines" event with the last lines, then
+// "append" events with data written to the file afterwards and "truncate"
+// events when the file shrinks or is replaced, as on log rotation.
+func (server *Server) TailFile(ctx *gin.Context) {
+	var req tailFileRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	if req.Lines == 0 {
+		req.Lines = defaultTailLines
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	f, info, ok := openRegularFile(ctx, ws, req.PathStr)
+	if !ok {
+		return
+	}
+	defer func() { f.Close() }()
+
+	offset, err := lastLinesOffset(f, info.Size(), req.Lines)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+	res := fileLinesResponse{Lines: []string{}, Offset: offset, Size: info.Size()}
+	r := bufio.NewReader(io.NewSectionReader(f, offset, info.Size()-offset))
+	for {
+		text, n, truncated, err := readLine(r)
+		if n == 0 {
+			break
+		}
+		res.Lines = append(res.Lines, string(text))
+		res.Truncated = res.Truncated || truncated
+		offset += n
+		if err != nil {
+			break
+		}
+	}
+	res.NextOffset = offset
+	res.EOF = true
+
+	if !req.Follow {
+		ctx.JSON(http.StatusOK, res)
+		return
+	}
+
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("X-Accel-Buffering", "no")
+	ctx.SSEvent("lines", res)
+	ctx.Writer.Flush()
+
+	path := f.Name()
+	ticker := time.NewTicker(tailPollInterval)
+	defer ticker.Stop()
+	idle := time.Duration(0)
+	buf := make([]byte, maxTailChunk)
+	for {
+		select {
+		case <-ctx.Request.Context().Done():
+			return
+		case <-ticker.C:
+		}
+
+		info, err := f.Stat()
+		if err != nil {
+			return
+		}
+		truncated := info.Size() < offset
+		// a rotated log is replaced by a new file under the same name; the
+		// stream ends when the replacement may not be read
+		if current, err := os.Stat(path); err == nil && !os.SameFile(info, current) {
+			next, nextInfo, err := reopenTailFile(ws, req.PathStr)
+			if err != nil {
+				return
+			}
+			f.Close()
+			f = next
+			info = nextInfo
+			truncated = true
+		}
+		if truncated {
+			offset = 0
+			ctx.SSEvent("truncate", gin.H{"size": info.Size()})
+			ctx.Writer.Flush()
+		}
+
+		sent := false
+		for offset < info.Size() {
+			n, err := f.ReadAt(buf, offset)
+			if err != nil && err != io.EOF || n == 0 {
+				break
+			}
+			// a character cut short is sent once it is complete
+			chunk := trimPartialRune(buf[:n])
+			if len(chunk) == 0 {
+				break
+			}
+			ctx.SSEvent("append", tailAppendEvent{
+				FileStr:    string(chunk),
+				Offset:     offset,
+				NextOffset: offset + int64(len(chunk)),
+			})
+			offset += int64(len(chunk))
+			sent = true
+		}
+		if sent {
+			ctx.Writer.Flush()
+			idle = 0
+			continue
+		}
+
+		// comments keep proxies from closing an idle stream
+		idle += tailPollInterval
+		if idle >= tailHeartbeatTimeout {
+			idle = 0
+			if _, err := io.WriteString(ctx.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+			ctx.Writer.Flush()
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadLine(t *testing.T) {
+	long := strings.Repeat("é", maxLineLength)
+	r := bufio.NewReaderSize(strings.NewReader("a\r\nb\n"+long+"\nlast"), 4096)
+
+	var lines []string
+	var consumed int64
+	for {
+		line, n, truncated, err := readLine(r)
+		if n == 0 {
+			break
+		}
+		consumed += n
+		require.Equal(t, len(line) == maxLineLength, truncated)
+		lines = append(lines, string(line))
+		if err != nil {
+			break
+		}
+	}
+	require.Equal(t, []string{"a", "b", long[:maxLineLength], "last"}, lines)
+	require.Equal(t, int64(len(long)+10), consumed)
+}
+
+func TestLastLinesOffset(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	content := "one\ntwo\nthree\nfour\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	size := int64(len(content))
+	for n, want := range map[int]string{1: "four\n", 2: "three\nfour\n", 10: content} {
+		offset, err := lastLinesOffset(f, size, n)
+		require.NoError(t, err)
+		require.Equal(t, want, content[offset:])
+	}
+}
+
+func TestReopenTailFile(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "dian")
+	require.NoError(t, os.MkdirAll(root, 0755))
+	secret := filepath.Join(base, "secret")
+	require.NoError(t, os.WriteFile(secret, []byte("secret\n"), 0600))
+	ws := &workspace{
+		username: "dian",
+		root:     root,
+		realRoot: root,
+		roots:    []workspaceRoot{{path: root, realPath: root, access: accessOwner}},
+	}
+
+	log := filepath.Join(root, "app.log")
+	require.NoError(t, os.WriteFile(log, []byte("rotated\n"), 0644))
+	f, info, err := reopenTailFile(ws, log[1:])
+	require.NoError(t, err)
+	require.Equal(t, int64(8), info.Size())
+	f.Close()
+
+	// a log swapped for a link to a file outside the workspace
+	require.NoError(t, os.Remove(log))
+	require.NoError(t, os.Symlink(secret, log))
+	_, _, err = reopenTailFile(ws, log[1:])
+	require.ErrorIs(t, err, errPathForbidden)
+
+	// links are refused even when they stay inside the workspace
+	inside := filepath.Join(root, "other.log")
+	require.NoError(t, os.WriteFile(inside, nil, 0644))
+	require.NoError(t, os.Remove(log))
+	require.NoError(t, os.Symlink(inside, log))
+	_, _, err = reopenTailFile(ws, log[1:])
+	require.Error(t, err)
+
+	require.NoError(t, os.Remove(log))
+	require.NoError(t, os.Mkdir(log, 0755))
+	_, _, err = reopenTailFile(ws, log[1:])
+	require.Error(t, err)
+}
@@ -0,0 +1,320 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	db "github.com/diantanjung/wecom/db/sqlc"
+	"github.com/gin-gonic/gin"
+)
+
+const defaultOperationLimit = 50
+
+// fileChange is the write of one file within a multi-file operation.
+// Before is the content the file had, if it existed. A removed file has no
+// after content.
+type fileChange struct {
+	path    string
+	before  []byte
+	existed bool
+	after   []byte
+	removed bool
+}
+
+// applyFileChanges writes every change or none of them. All new contents are
+// staged first, then renamed into place; if one step fails the files already
+// replaced are restored. Callers hold the locks of all paths.
+func applyFileChanges(changes []fileChange) error {
+	staged := make([]string, len(changes))
+	defer func() {
+		for _, tmpName := range staged {
+			if tmpName != "" {
+				os.Remove(tmpName)
+			}
+		}
+	}()
+	for i, change := range changes {
+		if change.removed {
+			continue
+		}
+		tmpName, err := stageFile(change.path, change.after)
+		if err != nil {
+			return err
+		}
+		staged[i] = tmpName
+	}
+
+	for i, change := range changes {
+		var err error
+		if change.removed {
+			err = os.Remove(change.path)
+		} else {
+			err = os.Rename(staged[i], change.path)
+			staged[i] = ""
+		}
+		if err != nil {
+			rollbackFileChanges(changes[:i])
+			return err
+		}
+	}
+	return nil
+}
+
+func rollbackFileChanges(changes []fileChange) {
+	for _, change := range changes {
+		var err error
+		if change.existed {
+			err = writeFileAtomic(change.path, change.before)
+		} else {
+			err = os.Remove(change.path)
+		}
+		if err != nil {
+			log.Println("cannot roll back", change.path+":", err)
+		}
+	}
+}
+
+// recordOperation stores the changes as one undoable operation. It runs
+// before the changes are applied, so a write that cannot be undone never
+// happens; the operation is deleted with deleteOperation when applying
+// fails. Nothing is left behind when recording fails.
+func (server *Server) recordOperation(ctx *gin.Context, ws *workspace, kind string, changes []fileChange) (db.FileOperation, error) {
+	op, err := server.querier.CreateFileOperation(ctx, db.CreateFileOperationParams{
+		UserID: ws.userID,
+		Kind:   kind,
+	})
+	if err != nil {
+		return op, err
+	}
+	if err := server.recordOperationChanges(ctx, op, changes); err != nil {
+		server.deleteOperation(ctx, op)
+		return op, err
+	}
+	return op, nil
+}
+
+func (server *Server) recordOperationChanges(ctx *gin.Context, op db.FileOperation, changes []fileChange) error {
+	for _, change := range changes {
+		arg := db.CreateFileOperationChangeParams{
+			OperationID: op.OperationID,
+			Path:        change.path,
+		}
+		var err error
+		if change.existed {
+			if arg.BeforeHash, err = server.blobs.put(change.before); err != nil {
+				return err
+			}
+		}
+		if !change.removed {
+			if arg.AfterHash, err = server.blobs.put(change.after); err != nil {
+				return err
+			}
+		}
+		if _, err := server.querier.CreateFileOperationChange(ctx, arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteOperation drops an operation whose changes were not applied.
+func (server *Server) deleteOperation(ctx *gin.Context, op db.FileOperation) {
+	if err := server.querier.DeleteFileOperation(ctx, op.OperationID); err != nil {
+		log.Println("cannot delete operation", op.OperationID, "that was not applied:", err)
+	}
+}
+
+// recordSaves adds applied changes to the file histories and tells the
+// index about them.
+func (server *Server) recordSaves(ctx *gin.Context, ws *workspace, changes []fileChange) {
+	for _, change := range changes {
+		if !change.removed {
+			server.recordSave(ctx, ws, change.path, change.before, change.existed, change.after)
+		}
+		server.index.Notify(change.path)
+	}
+}
+
+type operationResponse struct {
+	ID        int64     `json:"id"`
+	Kind      string    `json:"kind"`
+	Undone    bool      `json:"undone"`
+	CreatedAt time.Time `json:"created_at"`
+	Paths     []string  `json:"paths"`
+}
+
+func (server *Server) newOperationResponse(ctx *gin.Context, op db.FileOperation) (operationResponse, error) {
+	res := operationResponse{
+		ID:        op.OperationID,
+		Kind:      op.Kind,
+		Undone:    op.Undone,
+		CreatedAt: op.CreatedAt,
+		Paths:     []string{},
+	}
+	changes, err := server.querier.ListFileOperationChanges(ctx, op.OperationID)
+	if err != nil {
+		return res, err
+	}
+	for _, change := range changes {
+		res.Paths = append(res.Paths, strings.TrimPrefix(change.Path, "/"))
+	}
+	return res, nil
+}
+
+type listOperationsRequest struct {
+	Limit int32 `form:"limit" binding:"min=0"`
+}
+
+// ListOperations returns the latest multi-file operations of the user.
+func (server *Server) ListOperations(ctx *gin.Context) {
+	var req listOperationsRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	if req.Limit == 0 {
+		req.Limit = defaultOperationLimit
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	ops, err := server.querier.ListFileOperations(ctx, db.ListFileOperationsParams{
+		UserID: ws.userID,
+		Limit:  req.Limit,
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+
+	res := []operationResponse{}
+	for _, op := range ops {
+		opRes, err := server.newOperationResponse(ctx, op)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+			return
+		}
+		res = append(res, opRes)
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+type operationURI struct {
+	ID int64 `uri:"id" binding:"required,min=1"`
+}
+
+// UndoOperation puts back the content every file of an operation had before
+// it. Files changed since then make the whole undo fail with 409.
+func (server *Server) UndoOperation(ctx *gin.Context) {
+	var uri operationURI
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	op, err := server.querier.GetFileOperation(ctx, uri.ID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			ctx.JSON(http.StatusNotFound, errorResponse(err))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+	if op.UserID != ws.userID {
+		ctx.JSON(http.StatusForbidden, errorResponse(errPathForbidden))
+		return
+	}
+	if op.Undone {
+		err = errors.New("Operation has already been undone.")
+		ctx.JSON(http.StatusConflict, errorResponse(err))
+		return
+	}
+
+	recorded, err := server.querier.ListFileOperationChanges(ctx, op.OperationID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+	var paths []string
+	for _, change := range recorded {
+		if _, err := ws.resolve(change.Path, accessReadWrite); err != nil {
+			ctx.JSON(http.StatusForbidden, errorResponse(err))
+			return
+		}
+		paths = append(paths, change.Path)
+	}
+
+	unlock := server.fileLocks.lockAll(paths)
+	defer unlock()
+
+	var changes []fileChange
+	for _, change := range recorded {
+		current, err := os.ReadFile(change.Path)
+		existed := err == nil
+		if err != nil && !os.IsNotExist(err) {
+			ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+			return
+		}
+		if existed != (change.AfterHash != "") || existed && fileVersion(current) != change.AfterHash {
+			err = fmt.Errorf("%s has been modified since the operation.", strings.TrimPrefix(change.Path, "/"))
+			ctx.JSON(http.StatusConflict, errorResponse(err))
+			return
+		}
+
+		undo := fileChange{
+			path:    change.Path,
+			before:  current,
+			existed: existed,
+			removed: change.BeforeHash == "",
+		}
+		if !undo.removed {
+			if undo.after, err = server.blobs.get(change.BeforeHash); err != nil {
+				ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+				return
+			}
+		}
+		changes = append(changes, undo)
+	}
+
+	release, ok := server.reserveQuotaChanges(ctx, changes)
+	if !ok {
+		return
+	}
+	if err := applyFileChanges(changes); err != nil {
+		release()
+		ctx.JSON(mutationErrorStatus(err), errorResponse(err))
+		return
+	}
+	server.recordSaves(ctx, ws, changes)
+	err = server.querier.SetFileOperationUndone(ctx, db.SetFileOperationUndoneParams{
+		OperationID: op.OperationID,
+		Undone:      true,
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+
+	op.Undone = true
+	res, err := server.newOperationResponse(ctx, op)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
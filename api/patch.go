@@ -0,0 +1,293 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/diantanjung/wecom/util"
+	"github.com/gin-gonic/gin"
+)
+
+type applyPatchRequest struct {
+	// directory the file names of the patch are relative to
+	PathStr string `json:"path_str" binding:"required"`
+	Patch   string `json:"patch" binding:"required"`
+	// check that the patch applies without writing anything
+	DryRun bool `json:"dry_run"`
+}
+
+// patchConflict is a file or hunk of a patch that does not apply. Hunk is
+// 1-based, 0 when the whole file is in conflict.
+type patchConflict struct {
+	Path   string `json:"path"`
+	Hunk   int    `json:"hunk"`
+	Line   int    `json:"line"`
+	Reason string `json:"reason"`
+}
+
+type patchedFile struct {
+	Path string `json:"path"`
+	// "created", "modified" or "deleted"
+	Status  string `json:"status"`
+	Version string `json:"version,omitempty"`
+}
+
+type applyPatchResponse struct {
+	Files       []patchedFile   `json:"files"`
+	Conflicts   []patchConflict `json:"conflicts"`
+	DryRun      bool            `json:"dry_run"`
+	OperationID int64           `json:"operation_id,omitempty"`
+}
+
+type patchConflictResponse struct {
+	Error string `json:"error"`
+	applyPatchResponse
+}
+
+// patchTarget is a file touched by a patch with its current content.
+type patchTarget struct {
+	path    string
+	pathStr string
+	current []byte
+	existed bool
+}
+
+// ApplyPatch applies a unified diff to the files below a directory. Every
+// hunk must apply or nothing is written; the conflicts are reported per
+// hunk. The applied patch is recorded as one undoable operation.
+func (server *Server) ApplyPatch(ctx *gin.Context) {
+	var req applyPatchRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	patches, err := util.ParsePatch(req.Patch)
+	if err != nil {
+		err = fmt.Errorf("Invalid patch: %v.", err)
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	baseDir, err := ws.resolve(req.PathStr, accessReadWrite)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+
+	resolve := func(name string) (string, error) {
+		if filepath.IsAbs(name) {
+			return "", errPathForbidden
+		}
+		return ws.resolve(filepath.Join(baseDir, name), accessReadWrite)
+	}
+	var paths []string
+	for _, patch := range patches {
+		for _, name := range []string{patch.OldName, patch.NewName} {
+			if name == "" {
+				continue
+			}
+			path, err := resolve(name)
+			if err != nil {
+				ctx.JSON(http.StatusForbidden, errorResponse(err))
+				return
+			}
+			paths = append(paths, path)
+		}
+	}
+
+	unlock := server.fileLocks.lockAll(paths)
+	defer unlock()
+
+	targets := make(map[string]*patchTarget)
+	target := func(name string) (*patchTarget, error) {
+		path, _ := resolve(name)
+		if t, ok := targets[path]; ok {
+			return t, nil
+		}
+		current, err := os.ReadFile(path)
+		existed := err == nil
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		t := &patchTarget{path: path, pathStr: strings.TrimPrefix(path, "/"), current: current, existed: existed}
+		targets[path] = t
+		return t, nil
+	}
+
+	res := applyPatchResponse{Files: []patchedFile{}, Conflicts: []patchConflict{}, DryRun: req.DryRun}
+	var changes []fileChange
+	for _, patch := range patches {
+		var src, dst *patchTarget
+		if !patch.IsCreate() {
+			if src, err = target(patch.OldName); err != nil {
+				ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+				return
+			}
+		}
+		if !patch.IsDelete() {
+			if dst, err = target(patch.NewName); err != nil {
+				ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+				return
+			}
+		}
+
+		switch {
+		case src != nil && !src.existed:
+			res.Conflicts = append(res.Conflicts, patchConflict{Path: src.pathStr, Reason: "the file does not exist"})
+			continue
+		case dst != nil && dst != src && dst.existed:
+			res.Conflicts = append(res.Conflicts, patchConflict{Path: dst.pathStr, Reason: "the file already exists"})
+			continue
+		}
+
+		var before string
+		if src != nil {
+			before = string(src.current)
+		}
+		after, conflicts := util.ApplyHunks(before, patch.Hunks)
+		conflictPath := dst
+		if conflictPath == nil {
+			conflictPath = src
+		}
+		for _, conflict := range conflicts {
+			res.Conflicts = append(res.Conflicts, patchConflict{
+				Path:   conflictPath.pathStr,
+				Hunk:   conflict.Hunk,
+				Line:   conflict.Line,
+				Reason: conflict.Reason,
+			})
+		}
+		if len(conflicts) > 0 {
+			continue
+		}
+		if dst == nil && after != "" {
+			res.Conflicts = append(res.Conflicts, patchConflict{Path: src.pathStr, Reason: "the deleted file has lines the patch does not remove"})
+			continue
+		}
+
+		// renames delete the old file and create the new one
+		if src != nil && src != dst {
+			changes = append(changes, fileChange{path: src.path, before: src.current, existed: true, removed: true})
+			res.Files = append(res.Files, patchedFile{Path: src.pathStr, Status: "deleted"})
+		}
+		if dst != nil {
+			changes = append(changes, fileChange{path: dst.path, before: dst.current, existed: dst.existed, after: []byte(after)})
+			status := "modified"
+			if !dst.existed {
+				status = "created"
+			}
+			res.Files = append(res.Files, patchedFile{Path: dst.pathStr, Status: status, Version: fileVersion([]byte(after))})
+			// later patches of the same file apply on top of this one
+			dst.current, dst.existed = []byte(after), true
+		}
+		if src != nil && src != dst {
+			src.current, src.existed = nil, false
+		}
+	}
+
+	if len(res.Conflicts) > 0 {
+		ctx.JSON(http.StatusConflict, patchConflictResponse{
+			Error:              "The patch does not apply cleanly.",
+			applyPatchResponse: res,
+		})
+		return
+	}
+	if req.DryRun {
+		ctx.JSON(http.StatusOK, res)
+		return
+	}
+
+	changes = mergeFileChanges(changes)
+	release, ok := server.reserveQuotaChanges(ctx, changes)
+	if !ok {
+		return
+	}
+	op, err := server.recordOperation(ctx, ws, "patch", changes)
+	if err != nil {
+		release()
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+	// created files may live in directories the patch introduces
+	for _, change := range changes {
+		if change.removed || change.existed {
+			continue
+		}
+		if err := mkdirParents(filepath.Dir(change.path)); err != nil {
+			release()
+			server.deleteOperation(ctx, op)
+			ctx.JSON(mutationErrorStatus(err), errorResponse(err))
+			return
+		}
+	}
+	if err := applyFileChanges(changes); err != nil {
+		release()
+		server.deleteOperation(ctx, op)
+		ctx.JSON(mutationErrorStatus(err), errorResponse(err))
+		return
+	}
+	server.recordSaves(ctx, ws, changes)
+	res.OperationID = op.OperationID
+	ctx.JSON(http.StatusOK, res)
+}
+
+// mergeFileChanges folds several changes of one file, e.g. a file patched
+// twice or deleted and then created again, into one change from its first
+// before to its last after.
+func mergeFileChanges(changes []fileChange) []fileChange {
+	var merged []fileChange
+	index := make(map[string]int)
+	for _, change := range changes {
+		i, ok := index[change.path]
+		if !ok {
+			index[change.path] = len(merged)
+			merged = append(merged, change)
+			continue
+		}
+		merged[i].after, merged[i].removed = change.after, change.removed
+	}
+	// a file created and deleted again is left alone
+	var res []fileChange
+	for _, change := range merged {
+		if !change.existed && change.removed {
+			continue
+		}
+		res = append(res, change)
+	}
+	return res
+}
+
+// mkdirParents creates dir and its missing parents, owned like the
+// directories they are created in.
+func mkdirParents(dir string) error {
+	var created []string
+	for parent := dir; ; parent = filepath.Dir(parent) {
+		if _, err := os.Stat(parent); err == nil {
+			break
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		created = append([]string{parent}, created...)
+	}
+	if len(created) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, path := range created {
+		if err := chownLikeParent(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	errUnsafeMode  = errors.New("Only the read, write and execute bits can be changed, and the owner must keep read and write access.")
+	errSymlinkMode = errors.New("Permissions of symlinks cannot be changed.")
+)
+
+// ownerNames caches the user names of uids and groupNames those of gids.
+var ownerNames, groupNames sync.Map
+
+func formatMode(mode fs.FileMode) string {
+	return fmt.Sprintf("%04o", mode.Perm())
+}
+
+// isExecutable reports whether a regular file can be run directly.
+func isExecutable(info fs.FileInfo) bool {
+	return info.Mode().IsRegular() && info.Mode().Perm()&0111 != 0
+}
+
+func fileOwner(info fs.FileInfo) (uint32, uint32, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return stat.Uid, stat.Gid, true
+}
+
+// ownerName returns the user name owning a file, or its uid when the user
+// is unknown.
+func ownerName(info fs.FileInfo) string {
+	uid, _, ok := fileOwner(info)
+	if !ok {
+		return ""
+	}
+	id := strconv.FormatUint(uint64(uid), 10)
+	if name, ok := ownerNames.Load(id); ok {
+		return name.(string)
+	}
+	name := id
+	if u, err := user.LookupId(id); err == nil {
+		name = u.Username
+	}
+	ownerNames.Store(id, name)
+	return name
+}
+
+// groupName returns the group name of a file, or its gid when the group is
+// unknown.
+func groupName(info fs.FileInfo) string {
+	_, gid, ok := fileOwner(info)
+	if !ok {
+		return ""
+	}
+	id := strconv.FormatUint(uint64(gid), 10)
+	if name, ok := groupNames.Load(id); ok {
+		return name.(string)
+	}
+	name := id
+	if g, err := user.LookupGroupId(id); err == nil {
+		name = g.Name
+	}
+	groupNames.Store(id, name)
+	return name
+}
+
+// parseSafeMode parses an octal mode such as "755" or "0644". Special bits
+// are refused, as are modes locking the owner out of the entry.
+func parseSafeMode(str string, isDir bool) (fs.FileMode, error) {
+	value, err := strconv.ParseUint(strings.TrimPrefix(str, "0o"), 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid mode %q.", str)
+	}
+	mode := fs.FileMode(value)
+	ownerNeeds := fs.FileMode(0600)
+	if isDir {
+		ownerNeeds = 0700
+	}
+	if mode&^fs.ModePerm != 0 || mode&ownerNeeds != ownerNeeds {
+		return 0, errUnsafeMode
+	}
+	return mode, nil
+}
+
+type permissionsResponse struct {
+	Path       string `json:"path"`
+	Mode       string `json:"mode"`
+	Owner      string `json:"owner"`
+	Group      string `json:"group"`
+	IsDir      bool   `json:"is_dir"`
+	Executable bool   `json:"executable"`
+	// symbolic form, e.g. "-rwxr-xr-x"
+	Symbolic string `json:"symbolic"`
+}
+
+func newPermissionsResponse(path string, info fs.FileInfo) permissionsResponse {
+	return permissionsResponse{
+		Path:       strings.TrimPrefix(path, "/"),
+		Mode:       formatMode(info.Mode()),
+		Owner:      ownerName(info),
+		Group:      groupName(info),
+		IsDir:      info.IsDir(),
+		Executable: isExecutable(info),
+		Symbolic:   info.Mode().String(),
+	}
+}
+
+type getPermissionsRequest struct {
+	PathStr string `form:"path_str" binding:"required"`
+}
+
+// GetPermissions returns the mode and owner of a file or directory.
+func (server *Server) GetPermissions(ctx *gin.Context) {
+	var req getPermissionsRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	path, err := ws.resolve(req.PathStr, accessReadOnly)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		ctx.JSON(mutationErrorStatus(err), errorResponse(err))
+		return
+	}
+	ctx.JSON(http.StatusOK, newPermissionsResponse(path, info))
+}
+
+type changeModeRequest struct {
+	PathStr string `json:"path_str" binding:"required"`
+	// octal permission bits, e.g. "0755"
+	Mode string `json:"mode" binding:"required_without=Executable"`
+	// add or remove the execute bits wherever the read bits are set, like
+	// "chmod +x" and "chmod -x"; for files only
+	Executable *bool `json:"executable"`
+}
+
+// ChangeMode changes the permission bits of a file or directory inside the
+// workspace. Only the rwx bits of user, group and others can be set.
+func (server *Server) ChangeMode(ctx *gin.Context) {
+	var req changeModeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	path, err := ws.resolve(req.PathStr, accessReadWrite)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	if ws.isRoot(path) {
+		ctx.JSON(http.StatusBadRequest, errorResponse(errWorkspaceRoot))
+		return
+	}
+
+	unlock := server.fileLocks.lock(path)
+	defer unlock()
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		ctx.JSON(mutationErrorStatus(err), errorResponse(err))
+		return
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		ctx.JSON(http.StatusBadRequest, errorResponse(errSymlinkMode))
+		return
+	}
+
+	mode := info.Mode().Perm()
+	if req.Mode != "" {
+		mode, err = parseSafeMode(req.Mode, info.IsDir())
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, errorResponse(err))
+			return
+		}
+	}
+	if req.Executable != nil {
+		if !info.Mode().IsRegular() {
+			err = errors.New("Only files can be made executable.")
+			ctx.JSON(http.StatusBadRequest, errorResponse(err))
+			return
+		}
+		if *req.Executable {
+			mode |= (mode & 0444) >> 2
+		} else {
+			mode &^= 0111
+		}
+	}
+
+	if err := os.Chmod(path, mode); err != nil {
+		ctx.JSON(mutationErrorStatus(err), errorResponse(err))
+		return
+	}
+	server.index.Notify(path)
+
+	res, err := server.newFileMutationResponse(path, "Success change mode", filepath.Dir(path))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSafeMode(t *testing.T) {
+	mode, err := parseSafeMode("755", false)
+	require.NoError(t, err)
+	require.Equal(t, fs.FileMode(0755), mode)
+
+	mode, err = parseSafeMode("0o600", false)
+	require.NoError(t, err)
+	require.Equal(t, fs.FileMode(0600), mode)
+
+	_, err = parseSafeMode("4755", false)
+	require.ErrorIs(t, err, errUnsafeMode)
+
+	_, err = parseSafeMode("0400", false)
+	require.ErrorIs(t, err, errUnsafeMode)
+
+	_, err = parseSafeMode("0644", true)
+	require.ErrorIs(t, err, errUnsafeMode)
+
+	_, err = parseSafeMode("rwx", false)
+	require.Error(t, err)
+}
@@ -0,0 +1,65 @@
+//go:build linux
+
+package api
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// processGone reports whether pid has exited, counting zombies as gone.
+func processGone(pid int) bool {
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return true
+	}
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] == "Z"
+}
+
+func TestRunCmdKillsProcessGroup(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	cmd := newRunCmd(ctx, "sh", "-c", "sleep 30 & echo $! > "+pidFile+"; wait")
+	err := runCmd(cmd)
+	require.Error(t, err)
+	require.Less(t, time.Since(start), 5*time.Second)
+
+	data, err := os.ReadFile(pidFile)
+	require.NoError(t, err)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return processGone(pid) }, 2*time.Second, 20*time.Millisecond)
+}
+
+func TestStreamCmdEndsWithMainProcess(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	cmd := newRunCmd(context.Background(), "sh", "-c",
+		"sleep 30 & echo $! > "+pidFile+"; setsid sleep 5 & sleep 0.2; echo started")
+
+	var output strings.Builder
+	start := time.Now()
+	err := streamCmd(cmd, func() {}, func(chunk runChunk) { output.Write(chunk.data) }, func() {})
+	require.NoError(t, err)
+	require.Equal(t, "started\n", output.String())
+	// the new session is not killed with the group and holds the output
+	// open until it is cut off
+	require.Less(t, time.Since(start), runWaitDelay+2*time.Second)
+
+	// the background job of the group is killed with the main process
+	data, err := os.ReadFile(pidFile)
+	require.NoError(t, err)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return processGone(pid) }, 2*time.Second, 20*time.Millisecond)
+}
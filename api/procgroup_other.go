@@ -0,0 +1,13 @@
+//go:build !linux
+
+package api
+
+import "os/exec"
+
+// setProcessGroup leaves cmd in the server's process group; only cmd itself
+// is killed when it is cancelled.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func killProcessGroup(cmd *exec.Cmd) error {
+	return nil
+}
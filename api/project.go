@@ -0,0 +1,325 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	db "github.com/diantanjung/wecom/db/sqlc"
+	"github.com/gin-gonic/gin"
+)
+
+type projectResponse struct {
+	ID          int64              `json:"id"`
+	Name        string             `json:"name"`
+	Path        string             `json:"path"`
+	Access      string             `json:"access"`
+	Language    string             `json:"language"`
+	Description string             `json:"description"`
+	CreatedAt   time.Time          `json:"created_at"`
+	Grants      []dirGrantResponse `json:"grants,omitempty"`
+}
+
+func newProjectResponse(dir db.Directory) projectResponse {
+	return projectResponse{
+		ID:          dir.DirID,
+		Name:        filepath.Base(dir.Name),
+		Path:        dir.Name,
+		Access:      dir.Access,
+		Language:    dir.Language,
+		Description: dir.Description,
+		CreatedAt:   dir.CreatedAt,
+	}
+}
+
+// validProjectName rejects names that would escape the parent directory or
+// create hidden folders.
+func validProjectName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, "/\x00")
+}
+
+type createProjectRequest struct {
+	Name        string `json:"name" binding:"required"`
+	PathStr     string `json:"path_str"`
+	Language    string `json:"language"`
+	Description string `json:"description"`
+}
+
+// CreateProject makes a new project folder inside the caller's workspace and
+// registers it for the caller with the access they have on its parent, so a
+// project in a shared folder does not make its creator the owner.
+func (server *Server) CreateProject(ctx *gin.Context) {
+	var req createProjectRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	if !validProjectName(req.Name) {
+		err := errors.New("Invalid project name.")
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	if ws.userID == 0 {
+		err = errors.New("User is not registered.")
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+
+	parentPath := ws.root
+	if req.PathStr != "" {
+		parentPath, err = ws.resolve(req.PathStr, accessReadWrite)
+		if err != nil {
+			ctx.JSON(http.StatusForbidden, errorResponse(err))
+			return
+		}
+	}
+
+	projectPath := filepath.Join(parentPath, req.Name)
+	if err := os.Mkdir(projectPath, 0755); err != nil {
+		if os.IsExist(err) {
+			ctx.JSON(http.StatusConflict, errorResponse(err))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+	if err := chownLikeParent(projectPath); err != nil {
+		os.Remove(projectPath)
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+
+	dir, err := server.querier.CreateUserDir(ctx, db.CreateUserDirParams{
+		Name:        projectPath,
+		UserID:      ws.userID,
+		Access:      ws.accessOf(parentPath),
+		Language:    req.Language,
+		Description: req.Description,
+	})
+	if err != nil {
+		os.Remove(projectPath)
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, newProjectResponse(dir))
+}
+
+// ListProjects returns every project the caller owns or has been granted.
+func (server *Server) ListProjects(ctx *gin.Context) {
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+
+	dirs, err := server.querier.GetUserDirs(ctx, ws.userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+
+	res := []projectResponse{}
+	for _, dir := range dirs {
+		res = append(res, newProjectResponse(dir))
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+type projectURI struct {
+	ID int64 `uri:"id" binding:"required,min=1"`
+}
+
+// userProject loads the caller's project row and checks it is still
+// accessible with the needed level. It writes the error response itself.
+func (server *Server) userProject(ctx *gin.Context, ws *workspace, need string) (db.Directory, bool) {
+	var uri projectURI
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return db.Directory{}, false
+	}
+
+	dir, err := server.querier.GetUserDir(ctx, db.GetUserDirParams{
+		DirID:  uri.ID,
+		UserID: ws.userID,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			ctx.JSON(http.StatusNotFound, errorResponse(err))
+			return dir, false
+		}
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return dir, false
+	}
+
+	if _, err := ws.resolve(dir.Name, need); err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return dir, false
+	}
+	return dir, true
+}
+
+// GetProject describes a project. Owners also get the list of grants.
+func (server *Server) GetProject(ctx *gin.Context) {
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	dir, ok := server.userProject(ctx, ws, accessReadOnly)
+	if !ok {
+		return
+	}
+
+	res := newProjectResponse(dir)
+	if dir.Access == accessOwner {
+		grants, err := server.querier.GetDirGrants(ctx, dir.Name)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+			return
+		}
+		for _, grant := range grants {
+			res.Grants = append(res.Grants, newDirGrantResponse(grant))
+		}
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+type updateProjectRequest struct {
+	Name        string  `json:"name"`
+	Language    *string `json:"language"`
+	Description *string `json:"description"`
+}
+
+// UpdateProject renames a project folder and/or changes its metadata. The
+// grants of every user follow the folder when it is renamed.
+func (server *Server) UpdateProject(ctx *gin.Context) {
+	var req updateProjectRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	dir, ok := server.userProject(ctx, ws, accessOwner)
+	if !ok {
+		return
+	}
+
+	if req.Name != "" && req.Name != filepath.Base(dir.Name) {
+		if !validProjectName(req.Name) {
+			err = errors.New("Invalid project name.")
+			ctx.JSON(http.StatusBadRequest, errorResponse(err))
+			return
+		}
+
+		newPath := filepath.Join(filepath.Dir(dir.Name), req.Name)
+		if _, err := os.Lstat(newPath); err == nil {
+			err = errors.New("Destination already exists.")
+			ctx.JSON(http.StatusConflict, errorResponse(err))
+			return
+		}
+		if err := os.Rename(dir.Name, newPath); err != nil {
+			ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+			return
+		}
+
+		err = server.querier.RenameDirs(ctx, db.RenameDirsParams{
+			NewName: newPath,
+			OldName: dir.Name,
+		})
+		if err != nil {
+			os.Rename(newPath, dir.Name)
+			ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+			return
+		}
+		dir.Name = newPath
+	}
+
+	if req.Language != nil {
+		dir.Language = *req.Language
+	}
+	if req.Description != nil {
+		dir.Description = *req.Description
+	}
+	err = server.querier.UpdateDirMetadata(ctx, db.UpdateDirMetadataParams{
+		Name:        dir.Name,
+		Language:    dir.Language,
+		Description: dir.Description,
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, newProjectResponse(dir))
+}
+
+type deleteProjectRequest struct {
+	RemoveFiles bool `form:"remove_files"`
+}
+
+type deleteProjectResponse struct {
+	commandResponse
+	// trash item of the removed folder
+	TrashID string `json:"trash_id,omitempty"`
+}
+
+// DeleteProject unregisters a project and revokes every grant on it. The
+// folder itself is only moved to the trash when remove_files is set.
+func (server *Server) DeleteProject(ctx *gin.Context) {
+	var req deleteProjectRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	dir, ok := server.userProject(ctx, ws, accessOwner)
+	if !ok {
+		return
+	}
+
+	// the grants go only once the folder is gone, or its owner would be
+	// locked out of a folder that could not be removed
+	var item trashItem
+	if req.RemoveFiles {
+		item, err = server.discardPath(ws, dir.Name)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+			return
+		}
+		server.index.Notify(dir.Name)
+	}
+	if err := server.querier.DeleteDirs(ctx, dir.Name); err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+
+	res := deleteProjectResponse{
+		commandResponse: commandResponse{
+			Path:    dir.Name,
+			Message: "Success delete project",
+		},
+		TrashID: item.ID,
+	}
+	ctx.JSON(http.StatusOK, res)
+}
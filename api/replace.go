@@ -0,0 +1,250 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type replacePreviewRequest struct {
+	searchRequest
+	Replacement string `json:"replacement"`
+}
+
+type replaceMatch struct {
+	searchMatch
+	// position of the match in its file, used to pick matches to apply
+	Index       int    `json:"index"`
+	Replacement string `json:"replacement"`
+}
+
+type replaceFile struct {
+	Path    string         `json:"path"`
+	Version string         `json:"version"`
+	Matches []replaceMatch `json:"matches"`
+}
+
+type replacePreviewResponse struct {
+	Files     []replaceFile `json:"files"`
+	Matches   int           `json:"matches"`
+	Truncated bool          `json:"truncated"`
+}
+
+// replacement expands the replacement template for a match. With regex
+// queries $1 or ${name} refer to submatches, otherwise it is literal.
+func replacement(opts searchOptions, req replacePreviewRequest, match textMatch) string {
+	if !req.Regex {
+		return req.Replacement
+	}
+	return string(opts.pattern.ExpandString(nil, req.Replacement, match.Text, match.Submatches))
+}
+
+// PreviewReplace lists every match of the query with the text that would
+// replace it. Nothing is written.
+func (server *Server) PreviewReplace(ctx *gin.Context) {
+	var req replacePreviewRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	opts, err := compileSearch(req.searchRequest)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	dirPath, err := ws.resolve(req.PathStr, accessReadWrite)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+
+	res := replacePreviewResponse{Files: []replaceFile{}}
+	server.walkSearchFiles(ctx, ws, dirPath, opts, func(path string, data []byte) error {
+		matches := findMatches(opts.pattern, string(data))
+		if len(matches) == 0 {
+			return nil
+		}
+		file := replaceFile{
+			Path:    strings.TrimPrefix(path, "/"),
+			Version: fileVersion(data),
+		}
+		for i, match := range matches {
+			if res.Matches == opts.maxResults {
+				res.Truncated = true
+				break
+			}
+			file.Matches = append(file.Matches, replaceMatch{
+				searchMatch: newSearchMatch(path, match),
+				Index:       i,
+				Replacement: replacement(opts, req, match),
+			})
+			res.Matches++
+		}
+		res.Files = append(res.Files, file)
+		if res.Truncated {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+
+	ctx.JSON(http.StatusOK, res)
+}
+
+type replaceSelection struct {
+	PathStr string `json:"path_str" binding:"required"`
+	Version string `json:"version" binding:"required"`
+	Matches []int  `json:"matches" binding:"required,min=1"`
+}
+
+type replaceApplyRequest struct {
+	replacePreviewRequest
+	Files []replaceSelection `json:"files" binding:"required,min=1,dive"`
+}
+
+type replacedFile struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+}
+
+type replaceApplyResponse struct {
+	OperationID int64          `json:"operation_id"`
+	Files       []replacedFile `json:"files"`
+	Replaced    int            `json:"replaced"`
+}
+
+// applyReplace replaces the selected matches in content and returns the new
+// content with the number of replacements.
+func applyReplace(opts searchOptions, req replacePreviewRequest, content string, selected []int) (string, int, error) {
+	matches := findMatches(opts.pattern, content)
+	indexes := append([]int(nil), selected...)
+	sort.Ints(indexes)
+
+	var sb strings.Builder
+	last, replaced := 0, 0
+	for i, index := range indexes {
+		if index < 0 || index >= len(matches) {
+			return "", 0, fmt.Errorf("Match %d does not exist.", index)
+		}
+		if i > 0 && index == indexes[i-1] {
+			continue
+		}
+		match := matches[index]
+		sb.WriteString(content[last : match.Offset+match.Start])
+		sb.WriteString(replacement(opts, req, match))
+		last = match.Offset + match.End
+		replaced++
+	}
+	sb.WriteString(content[last:])
+	return sb.String(), replaced, nil
+}
+
+// ApplyReplace replaces the chosen matches of a preview. The files must still
+// have the version they had in the preview. All files are written or none,
+// and the whole replace is recorded as one operation that can be undone.
+func (server *Server) ApplyReplace(ctx *gin.Context) {
+	var req replaceApplyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	opts, err := compileSearch(req.searchRequest)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	paths := make([]string, len(req.Files))
+	seen := make(map[string]bool)
+	for i, file := range req.Files {
+		if paths[i], err = ws.resolve(file.PathStr, accessReadWrite); err != nil {
+			ctx.JSON(http.StatusForbidden, errorResponse(err))
+			return
+		}
+		if seen[paths[i]] {
+			err = fmt.Errorf("%s is selected more than once.", file.PathStr)
+			ctx.JSON(http.StatusBadRequest, errorResponse(err))
+			return
+		}
+		seen[paths[i]] = true
+	}
+
+	unlock := server.fileLocks.lockAll(paths)
+	defer unlock()
+
+	res := replaceApplyResponse{Files: []replacedFile{}}
+	var changes []fileChange
+	for i, file := range req.Files {
+		current, err := os.ReadFile(paths[i])
+		if err != nil {
+			ctx.JSON(mutationErrorStatus(err), errorResponse(err))
+			return
+		}
+		if fileVersion(current) != file.Version {
+			err = fmt.Errorf("%s has been modified since the preview.", file.PathStr)
+			ctx.JSON(http.StatusConflict, errorResponse(err))
+			return
+		}
+
+		after, replaced, err := applyReplace(opts, req.replacePreviewRequest, string(current), file.Matches)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, errorResponse(err))
+			return
+		}
+		if after == string(current) {
+			continue
+		}
+		changes = append(changes, fileChange{
+			path:    paths[i],
+			before:  current,
+			existed: true,
+			after:   []byte(after),
+		})
+		res.Files = append(res.Files, replacedFile{
+			Path:    strings.TrimPrefix(paths[i], "/"),
+			Version: fileVersion([]byte(after)),
+		})
+		res.Replaced += replaced
+	}
+	if len(changes) == 0 {
+		err = errors.New("Nothing to replace.")
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	release, ok := server.reserveQuotaChanges(ctx, changes)
+	if !ok {
+		return
+	}
+	op, err := server.recordOperation(ctx, ws, "replace", changes)
+	if err != nil {
+		release()
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+	if err := applyFileChanges(changes); err != nil {
+		release()
+		server.deleteOperation(ctx, op)
+		ctx.JSON(mutationErrorStatus(err), errorResponse(err))
+		return
+	}
+	server.recordSaves(ctx, ws, changes)
+	res.OperationID = op.OperationID
+	ctx.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,306 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	db "github.com/diantanjung/wecom/db/sqlc"
+	"github.com/diantanjung/wecom/util"
+	"github.com/gin-gonic/gin"
+)
+
+const defaultRevisionLimit = 100
+
+type revisionResponse struct {
+	ID        int64     `json:"id"`
+	Path      string    `json:"path"`
+	UserID    int64     `json:"user_id"`
+	Version   string    `json:"version"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+	*fileContent
+}
+
+func newRevisionResponse(rev db.FileRevision) revisionResponse {
+	return revisionResponse{
+		ID:        rev.RevisionID,
+		Path:      rev.Path,
+		UserID:    rev.UserID,
+		Version:   rev.Hash,
+		Size:      rev.Size,
+		CreatedAt: rev.CreatedAt,
+	}
+}
+
+// recordRevision stores data as a new revision of path.
+func (server *Server) recordRevision(ctx *gin.Context, ws *workspace, path string, data []byte) (db.FileRevision, error) {
+	hash, err := server.blobs.put(data)
+	if err != nil {
+		return db.FileRevision{}, err
+	}
+	return server.querier.CreateFileRevision(ctx, db.CreateFileRevisionParams{
+		Path:   path,
+		UserID: ws.userID,
+		Hash:   hash,
+		Size:   int64(len(data)),
+	})
+}
+
+// recordSave keeps the history of a save through the API. Content changed
+// outside of the editor, e.g. from the terminal, is recorded first so the
+// save can be undone too. Failures are logged: history must not block saves.
+func (server *Server) recordSave(ctx *gin.Context, ws *workspace, path string, before []byte, existed bool, after []byte) {
+	if existed {
+		latest, err := server.querier.GetLatestFileRevision(ctx, path)
+		if err != nil && err != sql.ErrNoRows {
+			log.Println("cannot load latest revision:", err)
+			return
+		}
+		if err == sql.ErrNoRows || latest.Hash != fileVersion(before) {
+			if _, err := server.recordRevision(ctx, ws, path, before); err != nil {
+				log.Println("cannot record revision:", err)
+				return
+			}
+		}
+	}
+	if _, err := server.recordRevision(ctx, ws, path, after); err != nil {
+		log.Println("cannot record revision:", err)
+	}
+}
+
+// accessibleRevision loads a revision whose file the caller can access with
+// the needed level. It writes the error response itself.
+func (server *Server) accessibleRevision(ctx *gin.Context, ws *workspace, id int64, need string) (db.FileRevision, bool) {
+	rev, err := server.querier.GetFileRevision(ctx, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			ctx.JSON(http.StatusNotFound, errorResponse(err))
+			return rev, false
+		}
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return rev, false
+	}
+	if _, err := ws.resolve(rev.Path, need); err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return rev, false
+	}
+	return rev, true
+}
+
+type listRevisionsRequest struct {
+	PathStr string `json:"path_str" binding:"required"`
+	Limit   int32  `json:"limit" binding:"min=0"`
+}
+
+// ListRevisions returns the revisions of a file, newest first.
+func (server *Server) ListRevisions(ctx *gin.Context) {
+	var req listRevisionsRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	if req.Limit == 0 {
+		req.Limit = defaultRevisionLimit
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	filePath, err := ws.resolve(req.PathStr, accessReadOnly)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+
+	revs, err := server.querier.ListFileRevisions(ctx, db.ListFileRevisionsParams{
+		Path:  filePath,
+		Limit: req.Limit,
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+
+	res := []revisionResponse{}
+	for _, rev := range revs {
+		res = append(res, newRevisionResponse(rev))
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+type revisionURI struct {
+	ID int64 `uri:"id" binding:"required,min=1"`
+}
+
+// GetRevision returns a revision together with its content.
+func (server *Server) GetRevision(ctx *gin.Context) {
+	var uri revisionURI
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	rev, ok := server.accessibleRevision(ctx, ws, uri.ID, accessReadOnly)
+	if !ok {
+		return
+	}
+
+	data, err := server.blobs.get(rev.Hash)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+
+	res := newRevisionResponse(rev)
+	content := newFileContent(rev.Path, data)
+	res.fileContent = &content
+	ctx.JSON(http.StatusOK, res)
+}
+
+type diffRevisionsRequest struct {
+	FromID int64 `json:"from_id" binding:"required,min=1"`
+	ToID   int64 `json:"to_id" binding:"min=0"`
+}
+
+type diffRevisionsResponse struct {
+	FromID int64  `json:"from_id"`
+	ToID   int64  `json:"to_id"`
+	Diff   string `json:"diff"`
+}
+
+// DiffRevisions returns the unified diff between two revisions. Without
+// to_id the revision is compared with the current file on disk.
+func (server *Server) DiffRevisions(ctx *gin.Context) {
+	var req diffRevisionsRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	from, ok := server.accessibleRevision(ctx, ws, req.FromID, accessReadOnly)
+	if !ok {
+		return
+	}
+	fromData, err := server.blobs.get(from.Hash)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+
+	toName := from.Path
+	var toData []byte
+	if req.ToID == 0 {
+		toData, err = os.ReadFile(from.Path)
+		if err != nil && !os.IsNotExist(err) {
+			ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+			return
+		}
+	} else {
+		to, ok := server.accessibleRevision(ctx, ws, req.ToID, accessReadOnly)
+		if !ok {
+			return
+		}
+		toName = to.Path
+		toData, err = server.blobs.get(to.Hash)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+			return
+		}
+	}
+
+	res := diffRevisionsResponse{
+		FromID: req.FromID,
+		ToID:   req.ToID,
+		Diff:   util.UnifiedDiff("a"+from.Path, "b"+toName, string(fromData), string(toData)),
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+type restoreRevisionRequest struct {
+	Version string `json:"version"`
+}
+
+// RestoreRevision writes a revision back to its file. The restore itself is
+// recorded as a new revision so it can be undone.
+func (server *Server) RestoreRevision(ctx *gin.Context) {
+	var uri revisionURI
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	var req restoreRevisionRequest
+	if ctx.Request.ContentLength > 0 {
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.JSON(http.StatusBadRequest, errorResponse(err))
+			return
+		}
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	rev, ok := server.accessibleRevision(ctx, ws, uri.ID, accessReadWrite)
+	if !ok {
+		return
+	}
+	data, err := server.blobs.get(rev.Hash)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+
+	unlock := server.fileLocks.lock(rev.Path)
+	defer unlock()
+
+	current, err := os.ReadFile(rev.Path)
+	existed := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+	if !checkExpectedVersion(ctx, req.Version, rev.Path, current, existed) {
+		return
+	}
+
+	release, ok := server.reserveQuota(ctx, rev.Path, int64(len(data)-len(current)))
+	if !ok {
+		return
+	}
+	if err := writeFileAtomic(rev.Path, data); err != nil {
+		release()
+		if errors.Is(err, os.ErrNotExist) {
+			err = errors.New("Directory of the revision no longer exists.")
+		}
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+	server.recordSave(ctx, ws, rev.Path, current, existed, data)
+	server.index.Notify(rev.Path)
+
+	res := updateFileContentResponse{
+		Path:    strings.TrimPrefix(rev.Path, "/"),
+		Message: "Success restore revision",
+		Version: rev.Hash,
+	}
+	ctx.Header("ETag", `"`+res.Version+`"`)
+	ctx.JSON(http.StatusOK, res)
+}
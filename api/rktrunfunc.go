@@ -2,10 +2,14 @@ package api
 
 import (
 	"bufio"
+	"context"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -59,13 +63,58 @@ func rktGenerateFile(testFileName, fileContent string) error {
 	if err != nil {
 		return err
 	}
+	// the code runs as the user, who must own what is written for it
+	if err := chownLikeParent(testFileName); err != nil {
+		os.Remove(testFileName)
+		return err
+	}
 	return nil
 }
 
-func rktRunFile(filename, testFileDir string) (string, error) {
-	cmd := exec.Command("/usr/racket/bin/racket", filename)
+func rktRunFile(ctx context.Context, filename, testFileDir string) (string, error) {
+	cmd := newRunCmd(ctx, "/usr/racket/bin/racket", filename)
 	cmd.Dir = testFileDir
-	output, err := cmd.CombinedOutput()
+	output, err := runCmdOutput(cmd)
 	stdout := string(output)
 	return stdout, err
-}
\ No newline at end of file
+}
+
+// runRacketFunc calls funcName of a Racket file from a generated module
+// requiring it.
+func runRacketFunc(ctx context.Context, filePath, funcName string, args map[string]string) (string, string, error) {
+	fileDir := filepath.Dir(filePath) + "/"
+	paramFunc, err := rktGetArgs(filePath, funcName)
+	if err != nil {
+		return "", "", err
+	}
+
+	if len(args) != len(paramFunc) {
+		return "", "", errors.New("Not enough arguments in call to function.")
+	}
+
+	argsStr := ""
+	for _, v := range paramFunc {
+		value, ok := args[v]
+		if !ok {
+			return "", "", errors.New("Not enough arguments in call to function")
+		}
+		if _, err := strconv.Atoi(value); err != nil {
+			argsStr += "\"" + value + "\" "
+		} else {
+			argsStr += value + " "
+		}
+	}
+
+	functionCall := funcName + " " + argsStr
+	testRandomName := randString(10)
+	testFileName := fileDir + testRandomName + ".rkt"
+	fileContent := fmt.Sprintf(rktTemplateString, filepath.Base(filePath), functionCall)
+	err = rktGenerateFile(testFileName, fileContent)
+	if err != nil {
+		return "", "", err
+	}
+	defer os.Remove(testFileName)
+
+	msg, err := rktRunFile(ctx, testFileName, fileDir)
+	return functionCall, msg, err
+}
@@ -2,10 +2,14 @@ package api
 
 import (
 	"bufio"
+	"context"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -60,17 +64,67 @@ func rsGenerateFile(testFileName, fileContent string) error {
 	if err != nil {
 		return err
 	}
+	// the code runs as the user, who must own what is written for it
+	if err := chownLikeParent(testFileName); err != nil {
+		os.Remove(testFileName)
+		return err
+	}
 	return nil
 }
 
-func rsRunFile(filename, modName, testFileDir string) (string, error) {
-	cmd1 := exec.Command("rustc", filename)
+func rsRunFile(ctx context.Context, filename, modName, testFileDir string) (string, error) {
+	cmd1 := newRunCmd(ctx, "rustc", filename)
 	cmd1.Dir = testFileDir
-	_, err := cmd1.CombinedOutput()
+	_, err := runCmdOutput(cmd1)
 
-	cmd := exec.Command("./" + modName)
+	cmd := newRunCmd(ctx, "./"+modName)
 	cmd.Dir = testFileDir
-	output, err := cmd.CombinedOutput()
+	output, err := runCmdOutput(cmd)
 	stdout := string(output)
 	return stdout, err
 }
+
+// runRustFunc calls funcName of a Rust file from a generated main module.
+func runRustFunc(ctx context.Context, filePath, funcName string, args map[string]string) (string, string, error) {
+	fileDir := filepath.Dir(filePath) + "/"
+	paramFunc, err := rsGetArgs(filePath, funcName)
+	if err != nil {
+		return "", "", err
+	}
+
+	if len(args) != len(paramFunc) {
+		return "", "", errors.New("Not enough arguments in call to function.")
+	}
+
+	argsStr := ""
+	for _, v := range paramFunc {
+		value, ok := args[v]
+		if !ok {
+			return "", "", errors.New("Not enough arguments in call to function")
+		}
+		if _, err := strconv.Atoi(value); err != nil {
+			argsStr += "\"" + value + "\" "
+		} else {
+			argsStr += value + ","
+		}
+	}
+
+	if last := len(argsStr) - 1; last >= 0 && argsStr[last] == ',' {
+		argsStr = argsStr[:last]
+	}
+
+	functionCall := funcName + "(" + argsStr + ")"
+	testRandomName := randString(10)
+	testFileName := fileDir + testRandomName + ".rs"
+	binName := strings.TrimSuffix(filepath.Base(filePath), ".rs")
+	fileContent := fmt.Sprintf(rsTemplateString, binName, binName, functionCall)
+	err = rsGenerateFile(testFileName, fileContent)
+	if err != nil {
+		return "", "", err
+	}
+	defer os.Remove(testFileName)
+	defer os.Remove(fileDir + testRandomName)
+
+	msg, err := rsRunFile(ctx, testFileName, testRandomName, fileDir)
+	return functionCall, msg, err
+}
@@ -0,0 +1,264 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/diantanjung/wecom/cgroup"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// runWaitDelay is how long a finished run may leave its output open, e.g.
+// to a background job it started, before the output is cut off.
+const runWaitDelay = 2 * time.Second
+
+var (
+	errRunCancelled = errors.New("The run was cancelled.")
+	errRunTimeout   = errors.New("The run exceeded the maximum runtime.")
+	errRunExists    = errors.New("A run with this ID is already active.")
+	errRunNotFound  = errors.New("No active run with this ID.")
+
+	errRunOutOfMemory = errors.New("The run was killed for exceeding its memory limit.")
+	errRunOutOfPids   = errors.New("The run exceeded its limit of processes.")
+)
+
+// activeRun is a running execution of user code.
+type activeRun struct {
+	ID        string    `json:"run_id"`
+	Command   string    `json:"command"`
+	StartedAt time.Time `json:"started_at"`
+	// resources the run may use, unset when runs are not limited
+	Limits   *cgroup.Limits `json:"limits,omitempty"`
+	username string
+	cancel   context.CancelCauseFunc
+	group    *cgroup.Group
+}
+
+// runRegistry tracks the active runs so they can be cancelled by ID.
+type runRegistry struct {
+	mu   sync.Mutex
+	runs map[string]*activeRun
+}
+
+func (r *runRegistry) add(run *activeRun) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.runs[run.ID]; ok {
+		return errRunExists
+	}
+	if r.runs == nil {
+		r.runs = make(map[string]*activeRun)
+	}
+	r.runs[run.ID] = run
+	return nil
+}
+
+func (r *runRegistry) remove(run *activeRun) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.runs[run.ID] == run {
+		delete(r.runs, run.ID)
+	}
+}
+
+// cancel stops the run with the given ID if it belongs to username.
+func (r *runRegistry) cancel(id, username string) bool {
+	r.mu.Lock()
+	run, ok := r.runs[id]
+	r.mu.Unlock()
+	if !ok || run.username != username {
+		return false
+	}
+	run.cancel(errRunCancelled)
+	return true
+}
+
+// list returns the active runs of username, oldest first.
+func (r *runRegistry) list(username string) []activeRun {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := []activeRun{}
+	for _, run := range r.runs {
+		if run.username == username {
+			res = append(res, *run)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].StartedAt.Before(res[j].StartedAt)
+	})
+	return res
+}
+
+// runContextKey is the context key of the run a context belongs to.
+type runContextKey struct{}
+
+// startRun registers a run of command under id, or a new ID when id is
+// empty, and creates the cgroup limiting it. The run's commands run as the
+// user's Unix account. The returned context ends when
+// the request goes away, the run is cancelled or the maximum runtime is
+// over; the run must be finished with the returned func. startRun writes
+// the error response and returns false when the run cannot start.
+func (server *Server) startRun(ctx *gin.Context, id, command string) (context.Context, *activeRun, func(), bool) {
+	u, ok := server.requestRunUser(ctx)
+	if !ok {
+		return nil, nil, nil, false
+	}
+	if id == "" {
+		id = uuid.NewString()
+	}
+	runCtx, cancel := context.WithCancelCause(withRunUser(ctx.Request.Context(), u))
+	run := &activeRun{
+		ID:        id,
+		Command:   command,
+		StartedAt: time.Now(),
+		username:  server.requestUsername(ctx),
+		cancel:    cancel,
+	}
+	if err := server.runs.add(run); err != nil {
+		cancel(nil)
+		ctx.JSON(http.StatusConflict, errorResponse(err))
+		return nil, nil, nil, false
+	}
+	if server.cgroups != nil {
+		limits := server.runLimits.For(run.username)
+		group, err := server.cgroups.Create(run.ID, limits)
+		if err != nil {
+			cancel(nil)
+			server.runs.remove(run)
+			err = fmt.Errorf("cannot limit the resources of the run: %w", err)
+			ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+			return nil, nil, nil, false
+		}
+		run.Limits, run.group = &limits, group
+	}
+
+	stopTimeout := func() {}
+	if server.config.MaxRunDuration > 0 {
+		runCtx, stopTimeout = context.WithTimeoutCause(runCtx, server.config.MaxRunDuration, errRunTimeout)
+	}
+	finish := func() {
+		stopTimeout()
+		cancel(nil)
+		if run.group != nil {
+			if err := run.group.Close(); err != nil {
+				log.Println("cannot remove the cgroup of run", run.ID+":", err)
+			}
+		}
+		server.runs.remove(run)
+	}
+	return context.WithValue(runCtx, runContextKey{}, run), run, finish, true
+}
+
+// runErrorResponse tells why a run did not finish on its own.
+type runErrorResponse struct {
+	Error string `json:"error"`
+	// "cancelled", "timeout", "oom_killed" or "pids_exhausted"
+	Reason string `json:"reason"`
+	// the limit the run ran into, in bytes or processes
+	Limit int64 `json:"limit,omitempty"`
+}
+
+// failure tells why a run ending with err was stopped. It returns false
+// when the run finished on its own, successfully or not.
+func (run *activeRun) failure(ctx context.Context, err error) (runErrorResponse, int, bool) {
+	if ctx.Err() != nil {
+		cause := context.Cause(ctx)
+		if errors.Is(cause, errRunTimeout) {
+			return runErrorResponse{Error: cause.Error(), Reason: "timeout"}, http.StatusRequestTimeout, true
+		}
+		return runErrorResponse{Error: cause.Error(), Reason: "cancelled"}, http.StatusConflict, true
+	}
+	if err == nil || run.group == nil {
+		return runErrorResponse{}, 0, false
+	}
+
+	// a limit only counts when the run failed, programs may well cope with
+	// a refused fork
+	events, eventsErr := run.group.Events()
+	if eventsErr != nil {
+		log.Println("cannot read the cgroup events of run", run.ID+":", eventsErr)
+	}
+	switch {
+	case events.OOMKills > 0:
+		return runErrorResponse{
+			Error:  errRunOutOfMemory.Error(),
+			Reason: "oom_killed",
+			Limit:  run.Limits.MemoryBytes,
+		}, http.StatusUnprocessableEntity, true
+	case events.PidsMax > 0:
+		return runErrorResponse{
+			Error:  errRunOutOfPids.Error(),
+			Reason: "pids_exhausted",
+			Limit:  run.Limits.Pids,
+		}, http.StatusUnprocessableEntity, true
+	}
+	return runErrorResponse{}, 0, false
+}
+
+// newRunCmd returns a command that is killed together with everything it
+// started once ctx is done. Commands of a run started with startRun go in
+// the run's cgroup, and those of a context with a run user run as that user.
+func newRunCmd(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	setProcessGroup(cmd)
+	if u, ok := ctx.Value(runUserContextKey{}).(*runUser); ok {
+		setRunUser(cmd, u)
+	}
+	if run, ok := ctx.Value(runContextKey{}).(*activeRun); ok && run.group != nil {
+		run.group.Attach(cmd)
+	}
+	cmd.WaitDelay = runWaitDelay
+	return cmd
+}
+
+// runCmd runs cmd to completion and then kills whatever it left running.
+// Leftovers holding on to the output do not fail the run.
+func runCmd(cmd *exec.Cmd) error {
+	defer killProcessGroup(cmd)
+	err := cmd.Run()
+	if errors.Is(err, exec.ErrWaitDelay) {
+		return nil
+	}
+	return err
+}
+
+// runCmdOutput is runCmd returning stdout and stderr combined.
+func runCmdOutput(cmd *exec.Cmd) ([]byte, error) {
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := runCmd(cmd)
+	return output.Bytes(), err
+}
+
+// ListRuns returns the active runs of the user.
+func (server *Server) ListRuns(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, server.runs.list(server.requestUsername(ctx)))
+}
+
+type runIDURI struct {
+	ID string `uri:"id" binding:"required,uuid"`
+}
+
+// CancelRun stops an active run of the user and everything it started.
+func (server *Server) CancelRun(ctx *gin.Context) {
+	var req runIDURI
+	if err := ctx.ShouldBindUri(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	if !server.runs.cancel(req.ID, server.requestUsername(ctx)) {
+		ctx.JSON(http.StatusNotFound, errorResponse(errRunNotFound))
+		return
+	}
+	ctx.JSON(http.StatusOK, commandResponse{RunID: req.ID, Message: "Success cancel run"})
+}
@@ -0,0 +1,42 @@
+//go:build linux
+
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/diantanjung/wecom/cgroup"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunFailureLimits(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "wecom")
+	cgroups, err := cgroup.New(root, "")
+	require.NoError(t, err)
+	limits := cgroup.Limits{MemoryBytes: 1 << 20, Pids: 16}
+	group, err := cgroups.Create("run", limits)
+	require.NoError(t, err)
+	run := &activeRun{ID: "run", Limits: &limits, group: group}
+	killed := errors.New("signal: killed")
+
+	_, _, stopped := run.failure(context.Background(), killed)
+	require.False(t, stopped)
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, "run", "pids.events"), []byte("max 2\n"), 0644))
+	// a run coping with a refused fork has not failed
+	_, _, stopped = run.failure(context.Background(), nil)
+	require.False(t, stopped)
+	res, status, stopped := run.failure(context.Background(), killed)
+	require.True(t, stopped)
+	require.Equal(t, http.StatusUnprocessableEntity, status)
+	require.Equal(t, runErrorResponse{Error: errRunOutOfPids.Error(), Reason: "pids_exhausted", Limit: 16}, res)
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, "run", "memory.events"), []byte("oom 1\noom_kill 1\n"), 0644))
+	res, _, _ = run.failure(context.Background(), killed)
+	require.Equal(t, runErrorResponse{Error: errRunOutOfMemory.Error(), Reason: "oom_killed", Limit: 1 << 20}, res)
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunRegistryCancel(t *testing.T) {
+	var runs runRegistry
+	ctx, cancel := context.WithCancelCause(context.Background())
+	run := &activeRun{ID: "run", StartedAt: time.Now(), username: "bob", cancel: cancel}
+	require.NoError(t, runs.add(run))
+	require.ErrorIs(t, runs.add(&activeRun{ID: "run"}), errRunExists)
+	require.Empty(t, runs.list("alice"))
+	require.Len(t, runs.list("bob"), 1)
+
+	// runs of other users cannot be cancelled
+	require.False(t, runs.cancel("run", "alice"))
+	_, _, stopped := run.failure(ctx, nil)
+	require.False(t, stopped)
+
+	require.True(t, runs.cancel("run", "bob"))
+	res, status, stopped := run.failure(ctx, context.Canceled)
+	require.True(t, stopped)
+	require.Equal(t, http.StatusConflict, status)
+	require.Equal(t, runErrorResponse{Error: errRunCancelled.Error(), Reason: "cancelled"}, res)
+
+	runs.remove(run)
+	require.False(t, runs.cancel("run", "bob"))
+}
+
+func TestRunFailureTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeoutCause(context.Background(), time.Millisecond, errRunTimeout)
+	defer cancel()
+	<-ctx.Done()
+
+	run := &activeRun{ID: "run"}
+	res, status, stopped := run.failure(ctx, context.DeadlineExceeded)
+	require.True(t, stopped)
+	require.Equal(t, http.StatusRequestTimeout, status)
+	require.Equal(t, "timeout", res.Reason)
+}
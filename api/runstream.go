@@ -0,0 +1,212 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// runChunkSize bounds the output sent in one event
+	runChunkSize = 32 << 10
+	// runPingInterval is how often an idle stream gets a keep-alive comment
+	runPingInterval = 15 * time.Second
+)
+
+// runOutputEvent is output of a running command, sent as a "stdout" or
+// "stderr" event.
+type runOutputEvent struct {
+	Data string `json:"data"`
+}
+
+// runExitEvent ends the stream. The exit code is -1 when the command was
+// killed by a signal or could not be started. Reason and Limit tell why a
+// run was stopped, as in runErrorResponse.
+type runExitEvent struct {
+	ExitCode   int    `json:"exit_code"`
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	Limit      int64  `json:"limit,omitempty"`
+}
+
+// runStartEvent opens the stream with the ID to cancel the run with.
+type runStartEvent struct {
+	RunID string `json:"run_id"`
+}
+
+type runChunk struct {
+	stream string
+	data   []byte
+}
+
+// pumpOutput sends what r produces to chunks as it arrives. A character
+// split between two reads is sent whole with the second one.
+func pumpOutput(stream string, r io.Reader, chunks chan<- runChunk) {
+	buf := make([]byte, runChunkSize)
+	pending := 0
+	for {
+		n, err := r.Read(buf[pending:])
+		pending += n
+		if chunk := trimPartialRune(buf[:pending]); len(chunk) > 0 && err == nil {
+			chunks <- runChunk{stream, append([]byte{}, chunk...)}
+			pending = copy(buf, buf[len(chunk):pending])
+		}
+		if err != nil {
+			if pending > 0 {
+				chunks <- runChunk{stream, append([]byte{}, buf[:pending]...)}
+			}
+			return
+		}
+	}
+}
+
+// exitCode returns the exit code of a finished command.
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// RunCommandStream runs a file like RunCommand but streams its output as
+// server-sent events while it runs: "stdout" and "stderr" events with the
+// output in the order it was read, then an "exit" event with the exit code
+// and duration. The command and everything it started are killed when the
+// client goes away, the run is cancelled or it exceeds the maximum runtime.
+func (server *Server) RunCommandStream(ctx *gin.Context) {
+	var req runCommandRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	command, runnerDir, ok := server.runTarget(ctx, req.PathStr)
+	if !ok {
+		return
+	}
+
+	runCtx, run, finish, ok := server.startRun(ctx, req.RunID, req.PathStr)
+	if !ok {
+		return
+	}
+	defer finish()
+
+	exeCmd := newRunCmd(runCtx, command[0], command[1:]...)
+	exeCmd.Dir = runnerDir
+
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("X-Accel-Buffering", "no")
+	start := time.Now()
+	started := func() {
+		ctx.SSEvent("start", runStartEvent{RunID: run.ID})
+		ctx.Writer.Flush()
+	}
+	send := func(chunk runChunk) {
+		ctx.SSEvent(chunk.stream, runOutputEvent{Data: string(chunk.data)})
+		ctx.Writer.Flush()
+	}
+	ping := func() {
+		// comments keep proxies from closing a quiet stream
+		io.WriteString(ctx.Writer, ": ping\n\n")
+		ctx.Writer.Flush()
+	}
+	err := streamCmd(exeCmd, started, send, ping)
+	res := runExitEvent{
+		ExitCode:   exitCode(err),
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if failure, _, stopped := run.failure(runCtx, err); stopped {
+		res.Error, res.Reason, res.Limit = failure.Error, failure.Reason, failure.Limit
+	} else if err != nil && res.ExitCode == -1 {
+		res.Error = err.Error()
+	}
+	ctx.SSEvent("exit", res)
+	ctx.Writer.Flush()
+}
+
+// streamCmd starts cmd, calls started once it runs and passes its output to
+// send as it arrives, pinging every runPingInterval while it is quiet. Once
+// cmd exits whatever it left running is killed, and output still held open,
+// e.g. by a process that left the group, is cut off after runWaitDelay. It
+// returns the error of starting or waiting for cmd.
+func streamCmd(cmd *exec.Cmd, started func(), send func(runChunk), ping func()) error {
+	stdout, stdoutW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer stdout.Close()
+	stderr, stderrW, err := os.Pipe()
+	if err != nil {
+		stdoutW.Close()
+		return err
+	}
+	defer stderr.Close()
+	cmd.Stdout, cmd.Stderr = stdoutW, stderrW
+	err = cmd.Start()
+	stdoutW.Close()
+	stderrW.Close()
+	if err != nil {
+		return err
+	}
+	defer killProcessGroup(cmd)
+	started()
+
+	chunks := make(chan runChunk)
+	var pumps sync.WaitGroup
+	pumps.Add(2)
+	go func() {
+		defer pumps.Done()
+		pumpOutput("stdout", stdout, chunks)
+	}()
+	go func() {
+		defer pumps.Done()
+		pumpOutput("stderr", stderr, chunks)
+	}()
+	go func() {
+		pumps.Wait()
+		close(chunks)
+	}()
+	waited := make(chan error, 1)
+	go func() {
+		waited <- cmd.Wait()
+	}()
+
+	ticker := time.NewTicker(runPingInterval)
+	defer ticker.Stop()
+	var waitErr error
+	var cutOff <-chan time.Time
+	for done := false; !done; {
+		select {
+		case chunk, ok := <-chunks:
+			if !ok {
+				done = true
+				break
+			}
+			send(chunk)
+		case waitErr = <-waited:
+			waited = nil
+			killProcessGroup(cmd)
+			cutOff = time.After(runWaitDelay)
+		case <-cutOff:
+			cutOff = nil
+			stdout.Close()
+			stderr.Close()
+		case <-ticker.C:
+			ping()
+		}
+	}
+	if waited != nil {
+		waitErr = <-waited
+	}
+	return waitErr
+}
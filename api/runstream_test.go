@@ -0,0 +1,24 @@
+package api
+
+import (
+	"strings"
+	"testing"
+	"testing/iotest"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPumpOutputKeepsRunesWhole(t *testing.T) {
+	chunks := make(chan runChunk, 16)
+	pumpOutput("stdout", iotest.OneByteReader(strings.NewReader("héllo")), chunks)
+	close(chunks)
+
+	var out strings.Builder
+	for chunk := range chunks {
+		require.Equal(t, "stdout", chunk.stream)
+		require.True(t, utf8.Valid(chunk.data), "%q", chunk.data)
+		out.Write(chunk.data)
+	}
+	require.Equal(t, "héllo", out.String())
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errRunAsRoot = errors.New("User code is not run as root.")
+
+// runUser is the Unix account user code runs as.
+type runUser struct {
+	username string
+	uid      uint32
+	gid      uint32
+	groups   []uint32
+	home     string
+	env      []string
+}
+
+// runUserContextKey is the context key of the account commands run as.
+type runUserContextKey struct{}
+
+func withRunUser(ctx context.Context, u *runUser) context.Context {
+	return context.WithValue(ctx, runUserContextKey{}, u)
+}
+
+// lookupRunUser returns the account user code of username runs as: the one
+// loginGoogle and loginGithub create with useradd. A server that is not
+// root cannot switch users, so there code runs as the server's own account
+// when the user has none.
+func (server *Server) lookupRunUser(username string) (*runUser, error) {
+	account, err := user.Lookup(username)
+	if err != nil {
+		if os.Geteuid() != 0 {
+			u := &runUser{
+				username: username,
+				uid:      uint32(os.Geteuid()),
+				gid:      uint32(os.Getegid()),
+				home:     filepath.Join(server.config.WorkspaceRoot, username),
+			}
+			u.env = server.runEnv(u)
+			return u, nil
+		}
+		return nil, fmt.Errorf("No Unix account for %s.", username)
+	}
+
+	u := &runUser{username: username, home: account.HomeDir}
+	uid, err := strconv.ParseUint(account.Uid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.ParseUint(account.Gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	if uid == 0 {
+		return nil, errRunAsRoot
+	}
+	u.uid, u.gid = uint32(uid), uint32(gid)
+
+	groupIDs, err := account.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range groupIDs {
+		if gid, err := strconv.ParseUint(id, 10, 32); err == nil {
+			u.groups = append(u.groups, uint32(gid))
+		}
+	}
+	u.env = server.runEnv(u)
+	return u, nil
+}
+
+// requestRunUser returns the account code run for the request runs as. It
+// writes the error response and returns false when there is none.
+func (server *Server) requestRunUser(ctx *gin.Context) (*runUser, bool) {
+	u, err := server.lookupRunUser(server.requestUsername(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return nil, false
+	}
+	return u, true
+}
+
+// runEnv returns the environment of code run as u. Nothing is inherited
+// from the server, whose environment holds its secrets.
+func (server *Server) runEnv(u *runUser) []string {
+	path := filepath.Join(u.home, "go", "bin") + ":" + filepath.Join(u.home, ".cargo", "bin") + ":" + server.config.RunPath
+	env := []string{
+		"HOME=" + u.home,
+		"USER=" + u.username,
+		"LOGNAME=" + u.username,
+		"PATH=" + path,
+		"LANG=C.UTF-8",
+	}
+	return append(env, server.config.RunEnv...)
+}
+
+// setRunUser makes cmd run as u in its clean environment. The credentials
+// are only switched when the server is root, no one else can.
+func setRunUser(cmd *exec.Cmd, u *runUser) {
+	cmd.Env = u.env
+	if os.Geteuid() != 0 || u.uid == uint32(os.Geteuid()) {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: u.uid, Gid: u.gid, Groups: u.groups}
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/diantanjung/wecom/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunUser(t *testing.T) {
+	server := &Server{config: util.Config{RunPath: "/usr/bin:/bin", RunEnv: []string{"RUSTUP_HOME=/opt/rustup"}}}
+
+	// code never runs as root, even for a user called root
+	_, err := server.lookupRunUser("root")
+	require.ErrorIs(t, err, errRunAsRoot)
+
+	u := &runUser{username: "bob", uid: uint32(os.Geteuid()), home: "/home/bob"}
+	u.env = server.runEnv(u)
+	cmd := exec.Command("env")
+	setRunUser(cmd, u)
+	require.Nil(t, cmd.SysProcAttr)
+	require.Equal(t, []string{
+		"HOME=/home/bob",
+		"USER=bob",
+		"LOGNAME=bob",
+		"PATH=/home/bob/go/bin:/home/bob/.cargo/bin:/usr/bin:/bin",
+		"LANG=C.UTF-8",
+		"RUSTUP_HOME=/opt/rustup",
+	}, cmd.Env)
+}
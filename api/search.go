@@ -0,0 +1,328 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/diantanjung/wecom/index"
+	"github.com/diantanjung/wecom/util"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSearchResults = 1000
+	// files larger than this are not searched, as they are not indexed
+	maxSearchFileSize = index.MaxFileSize
+	// bytes sniffed for a NUL byte to tell binaries apart
+	binarySniffSize = 8000
+	// characters kept on each side of a match in previews
+	searchPreviewContext = 80
+)
+
+// isHiddenName reports whether a file or directory name is hidden. Hidden
+// entries are skipped by listings, file search and content search alike.
+func isHiddenName(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// isBinary reports whether data looks like the start of a binary file.
+func isBinary(data []byte) bool {
+	if len(data) > binarySniffSize {
+		data = data[:binarySniffSize]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// isBinaryFile sniffs the head of the file at path.
+func isBinaryFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return true
+	}
+	defer f.Close()
+
+	buf := make([]byte, binarySniffSize)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return true
+	}
+	return isBinary(buf[:n])
+}
+
+// walkVisible walks root calling fn for every visible regular file with its
+// slash separated path relative to root. Hidden entries and symlinks are
+// skipped, and so are unreadable entries instead of failing the walk.
+func walkVisible(root string, fn func(path, rel string, info fs.FileInfo) error) error {
+	return filepath.Walk(root, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if path != root && isHiddenName(info.Name()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+		return fn(path, filepath.ToSlash(rel), info)
+	})
+}
+
+type searchRequest struct {
+	PathStr       string   `json:"path_str" binding:"required"`
+	Query         string   `json:"query" binding:"required"`
+	Regex         bool     `json:"regex"`
+	CaseSensitive bool     `json:"case_sensitive"`
+	WholeWord     bool     `json:"whole_word"`
+	Include       []string `json:"include"`
+	Exclude       []string `json:"exclude"`
+	MaxResults    int      `json:"max_results" binding:"min=0,max=10000"`
+}
+
+// searchOptions is a compiled searchRequest.
+type searchOptions struct {
+	pattern    *regexp.Regexp
+	include    util.GlobSet
+	exclude    util.GlobSet
+	maxResults int
+	// literals every match contains, used to pick candidates from the index
+	literals []string
+}
+
+func compileSearch(req searchRequest) (searchOptions, error) {
+	expr := req.Query
+	if !req.Regex {
+		expr = regexp.QuoteMeta(expr)
+	}
+	if req.WholeWord {
+		expr = `\b(?:` + expr + `)\b`
+	}
+	if !req.CaseSensitive {
+		expr = `(?i)` + expr
+	}
+
+	var opts searchOptions
+	var err error
+	if opts.pattern, err = regexp.Compile(expr); err != nil {
+		return opts, err
+	}
+	if opts.include, err = util.CompileGlobs(req.Include); err != nil {
+		return opts, err
+	}
+	if opts.exclude, err = util.CompileGlobs(req.Exclude); err != nil {
+		return opts, err
+	}
+	if !req.Regex {
+		opts.literals = []string{req.Query}
+	} else if re, err := syntax.Parse(req.Query, syntax.Perl); err == nil {
+		opts.literals = requiredLiterals(re)
+	}
+	opts.maxResults = req.MaxResults
+	if opts.maxResults == 0 {
+		opts.maxResults = defaultSearchResults
+	}
+	return opts, nil
+}
+
+// requiredLiterals returns literal strings that every match of re contains.
+// Only literals concatenated at the top level are considered, which covers
+// the common queries without analyzing alternations.
+func requiredLiterals(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return []string{string(re.Rune)}
+	case syntax.OpCapture:
+		return requiredLiterals(re.Sub[0])
+	case syntax.OpConcat:
+		var literals []string
+		for _, sub := range re.Sub {
+			literals = append(literals, requiredLiterals(sub)...)
+		}
+		return literals
+	}
+	return nil
+}
+
+// walkSearchFiles calls fn with the content of every text file below root
+// selected by the include and exclude globs. Files are taken from the index
+// when it covers root. The walk stops when the client goes away.
+func (server *Server) walkSearchFiles(ctx *gin.Context, ws *workspace, root string, opts searchOptions, fn func(path string, data []byte) error) {
+	visit := func(path, rel string, size int64) error {
+		if ctx.Request.Context().Err() != nil {
+			return filepath.SkipAll
+		}
+		if len(opts.include) > 0 && !opts.include.Match(rel) || opts.exclude.Match(rel) {
+			return nil
+		}
+		if size > maxSearchFileSize {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil || isBinary(data) {
+			return nil
+		}
+		return fn(path, data)
+	}
+
+	if files, ok := server.searchCandidates(ws, root, opts); ok {
+		for _, file := range files {
+			if err := visit(file.path, file.rel, file.size); err == filepath.SkipAll {
+				return
+			}
+		}
+		return
+	}
+	walkVisible(root, func(path, rel string, info fs.FileInfo) error {
+		return visit(path, rel, info.Size())
+	})
+}
+
+// textMatch is one match of a search pattern. Patterns are matched line by
+// line, so ^ and $ anchor at line boundaries.
+type textMatch struct {
+	Line   int
+	Text   string
+	Offset int // byte offset of the line in the content
+	Start  int // byte offsets of the match in the line
+	End    int
+	// submatch offsets in the line, as returned by FindStringSubmatchIndex
+	Submatches []int
+}
+
+func findMatches(pattern *regexp.Regexp, content string) []textMatch {
+	var matches []textMatch
+	offset := 0
+	for i, line := range strings.SplitAfter(content, "\n") {
+		text := strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		for _, loc := range pattern.FindAllStringSubmatchIndex(text, -1) {
+			if loc[0] == loc[1] {
+				continue
+			}
+			matches = append(matches, textMatch{
+				Line:   i + 1,
+				Text:   text,
+				Offset: offset,
+				Start:  loc[0],
+				End:    loc[1],
+
+				Submatches: loc,
+			})
+		}
+		offset += len(line)
+	}
+	return matches
+}
+
+type searchMatch struct {
+	Path    string `json:"path"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Length  int    `json:"length"`
+	Preview string `json:"preview"`
+	// character offset of the match within the preview
+	PreviewColumn int `json:"preview_column"`
+}
+
+func newSearchMatch(path string, match textMatch) searchMatch {
+	preview, previewColumn := searchPreview(match.Text, match.Start, match.End)
+	return searchMatch{
+		Path:          strings.TrimPrefix(path, "/"),
+		Line:          match.Line,
+		Column:        utf8.RuneCountInString(match.Text[:match.Start]) + 1,
+		Length:        utf8.RuneCountInString(match.Text[match.Start:match.End]),
+		Preview:       preview,
+		PreviewColumn: previewColumn,
+	}
+}
+
+type searchSummary struct {
+	Done      bool `json:"done"`
+	Matches   int  `json:"matches"`
+	Files     int  `json:"files"`
+	Truncated bool `json:"truncated"`
+}
+
+// searchPreview cuts the line around a match and returns it with the match
+// offset in it. Offsets are counted in characters, the way the editor counts
+// them.
+func searchPreview(line string, start, end int) (string, int) {
+	from := start
+	for n := 0; from > 0 && n < searchPreviewContext; n++ {
+		_, size := utf8.DecodeLastRuneInString(line[:from])
+		from -= size
+	}
+	to := end
+	for n := 0; to < len(line) && n < searchPreviewContext; n++ {
+		_, size := utf8.DecodeRuneInString(line[to:])
+		to += size
+	}
+	return line[from:to], utf8.RuneCountInString(line[from:start])
+}
+
+// Search finds the query in the content of the files below path_str. Matches
+// are streamed as newline delimited JSON, one match per line, followed by a
+// summary line with "done" set.
+func (server *Server) Search(ctx *gin.Context) {
+	var req searchRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	opts, err := compileSearch(req)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	dirPath, err := ws.resolve(req.PathStr, accessReadOnly)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+
+	ctx.Header("Content-Type", "application/x-ndjson")
+	ctx.Status(http.StatusOK)
+	enc := json.NewEncoder(ctx.Writer)
+	summary := searchSummary{Done: true}
+
+	server.walkSearchFiles(ctx, ws, dirPath, opts, func(path string, data []byte) error {
+		matches := findMatches(opts.pattern, string(data))
+		if len(matches) == 0 {
+			return nil
+		}
+		for _, match := range matches {
+			if summary.Matches == opts.maxResults {
+				summary.Truncated = true
+				return filepath.SkipAll
+			}
+			enc.Encode(newSearchMatch(path, match))
+			summary.Matches++
+		}
+		summary.Files++
+		ctx.Writer.Flush()
+		return nil
+	})
+
+	enc.Encode(summary)
+	ctx.Writer.Flush()
+}
@@ -3,7 +3,10 @@ package api
 import (
 	"fmt"
 
+	"github.com/diantanjung/wecom/cgroup"
 	db2 "github.com/diantanjung/wecom/db/sqlc"
+	"github.com/diantanjung/wecom/index"
+	"github.com/diantanjung/wecom/lang"
 	"github.com/diantanjung/wecom/token"
 	"github.com/diantanjung/wecom/util"
 	"github.com/gin-gonic/gin"
@@ -14,6 +17,16 @@ type Server struct {
 	querier    db2.Querier
 	tokenMaker token.Maker
 	router     *gin.Engine
+	fileLocks  pathLocks
+	blobs      blobStore
+	index      *index.Service
+	watches    *watchHub
+	languages  *lang.Registry
+	lines      lineIndex
+	usage      usageCache
+	runs       runRegistry
+	cgroups    *cgroup.Manager
+	runLimits  *cgroup.Plans
 }
 
 // NewServer creates a new HTTP server and set up routing.
@@ -23,12 +36,57 @@ func NewServer(config util.Config, querier db2.Querier) (*Server, error) {
 		return nil, fmt.Errorf("cannot create token maker: %w", err)
 	}
 
+	languages := lang.NewRegistry(lang.Defaults())
+	if config.LanguagesFile != "" {
+		languages, err = lang.Load(config.LanguagesFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load languages: %w", err)
+		}
+	}
+
+	runLimits := &cgroup.Plans{Default: cgroup.Limits{
+		CPUs:        config.RunCPUs,
+		MemoryBytes: config.RunMemoryMax,
+		Pids:        config.RunPidsMax,
+		IOReadBps:   config.RunIOReadBps,
+		IOWriteBps:  config.RunIOWriteBps,
+	}}
+	if config.RunLimitsFile != "" {
+		runLimits, err = cgroup.LoadPlans(config.RunLimitsFile, runLimits.Default)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load run limits: %w", err)
+		}
+	}
+
 	server := &Server{
 		config:     config,
 		querier:    querier,
 		tokenMaker: tokenMaker,
+		blobs:      blobStore{dir: config.RevisionDir},
+		index:      index.NewService(config.IndexDir, int(config.IndexMaxFiles), int(config.IndexMaxLoaded), config.IndexRefresh, config.IndexIdle),
+		languages:  languages,
+		runLimits:  runLimits,
 	}
 
+	if config.CgroupRoot != "" {
+		ioDevice := config.RunIODevice
+		if ioDevice == "" {
+			ioDevice, err = cgroup.DeviceOf(config.WorkspaceRoot)
+			if err != nil && (config.RunIOReadBps > 0 || config.RunIOWriteBps > 0) {
+				return nil, fmt.Errorf("cannot find the disk to limit io on, set RUN_IO_DEVICE: %w", err)
+			}
+		}
+		server.cgroups, err = cgroup.New(config.CgroupRoot, ioDevice)
+		if err != nil {
+			return nil, fmt.Errorf("cannot set up cgroups, set an empty CGROUP_ROOT to run user code without resource limits: %w", err)
+		}
+	}
+
+	server.watches = newWatchHub(int(config.WatchMaxDirs), server.index.Notify)
+	server.index.SetWatcher(server.watches)
+	go server.index.ExpireIdle()
+	go server.expireTrash()
+
 	server.setupRouter()
 	return server, nil
 }
@@ -37,7 +95,8 @@ func (server *Server) CORSMiddleware() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		ctx.Header("Access-Control-Allow-Origin", server.config.FeUrl)
 		ctx.Header("Access-Control-Allow-Credentials", "true")
-		ctx.Header("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		ctx.Header("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, If-Match")
+		ctx.Header("Access-Control-Expose-Headers", "ETag")
 		ctx.Header("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, PATCH, DELETE")
 
 		if ctx.Request.Method == "OPTIONS" {
@@ -57,30 +116,88 @@ func (server *Server) setupRouter() {
 	router.POST("/users/login-github", server.loginGithub)
 	router.GET("/ws2/:username", server.WebSocket2)
 	router.GET("/wsdebug", server.WsDebug)
-
-	router.POST("/run", server.RunCommand)
-	router.GET("/runfunc", server.RunFunc)
+	router.GET("/watch", server.Watch)
+	router.GET("/languages", server.ListLanguages)
 
 	// for guest
 	router.POST("/gopendirfile", server.GetDirFileContent)
 	router.POST("/gopendir", server.GetDirContent)
+	router.POST("/gtree", server.GetTree)
+	router.POST("/gopen/range", server.ReadFileRange)
+	router.POST("/gopen/lines", server.ReadFileLines)
+	router.GET("/gopen/tail", server.TailFile)
 	router.POST("/ggetallfiles", server.GetAllFiles)
 	router.POST("/grungodef", server.RunGodef)
 	router.POST("/ggetcodebase", server.GetCodebase)
-
-	router.PATCH("/open", server.UpdateFileContent)
+	router.POST("/gsearch", server.Search)
+	router.POST("/gfindfile", server.FindFile)
 
 	authRoutes := router.Group("/").Use(authMiddleware(server.tokenMaker))
 	authRoutes.GET("/user", server.getUser)
 
 	authRoutes.POST("/open", server.GetFileContent)
+	authRoutes.PATCH("/open", server.UpdateFileContent)
+	authRoutes.POST("/format", server.FormatFile)
+	authRoutes.POST("/open/range", server.ReadFileRange)
+	authRoutes.POST("/open/lines", server.ReadFileLines)
+	authRoutes.GET("/open/tail", server.TailFile)
+	authRoutes.POST("/files", server.CreateFile)
+	authRoutes.DELETE("/files", server.DeletePath)
+	authRoutes.GET("/trash", server.ListTrash)
+	authRoutes.DELETE("/trash", server.EmptyTrash)
+	authRoutes.POST("/trash/:id/restore", server.RestoreTrashItem)
+	authRoutes.DELETE("/trash/:id", server.PurgeTrashItem)
+	authRoutes.POST("/mkdir", server.MakeDir)
+	authRoutes.POST("/rename", server.RenamePath)
+	authRoutes.POST("/move", server.MovePath)
+	authRoutes.POST("/copy", server.CopyPath)
+	authRoutes.GET("/permissions", server.GetPermissions)
+	authRoutes.POST("/chmod", server.ChangeMode)
+	authRoutes.POST("/upload", server.UploadFiles)
+	authRoutes.GET("/download", server.Download)
+	authRoutes.POST("/search", server.Search)
+	authRoutes.POST("/findfile", server.FindFile)
+	authRoutes.POST("/replace/preview", server.PreviewReplace)
+	authRoutes.POST("/replace/apply", server.ApplyReplace)
+	authRoutes.POST("/patch", server.ApplyPatch)
+	authRoutes.GET("/operations", server.ListOperations)
+	authRoutes.POST("/operations/:id/undo", server.UndoOperation)
+
+	authRoutes.POST("/revisions", server.ListRevisions)
+	authRoutes.GET("/revisions/:id", server.GetRevision)
+	authRoutes.POST("/revisions/diff", server.DiffRevisions)
+	authRoutes.POST("/revisions/:id/restore", server.RestoreRevision)
+
+	authRoutes.POST("/run", server.RunCommand)
+	authRoutes.POST("/run/stream", server.RunCommandStream)
+	authRoutes.GET("/runfunc", server.RunFunc)
+	authRoutes.GET("/runs", server.ListRuns)
+	authRoutes.POST("/runs/:id/cancel", server.CancelRun)
 
 	authRoutes.POST("/opendirfile", server.GetDirFileContent)
 	authRoutes.POST("/opendir", server.GetDirContent)
+	authRoutes.POST("/tree", server.GetTree)
 	authRoutes.POST("/getallfiles", server.GetAllFiles)
 	authRoutes.POST("/rungodef", server.RunGodef)
 	authRoutes.POST("/getcodebase", server.GetCodebase)
 
+	authRoutes.GET("/usage", server.GetUsage)
+
+	authRoutes.GET("/dirs", server.ListUserDirs)
+	authRoutes.POST("/dirs/grants", server.ListDirGrants)
+	authRoutes.POST("/dirs/share", server.ShareDir)
+	authRoutes.DELETE("/dirs/share", server.UnshareDir)
+
+	authRoutes.POST("/projects", server.CreateProject)
+	authRoutes.GET("/projects", server.ListProjects)
+	authRoutes.GET("/projects/:id", server.GetProject)
+	authRoutes.PATCH("/projects/:id", server.UpdateProject)
+	authRoutes.DELETE("/projects/:id", server.DeleteProject)
+
+	adminRoutes := router.Group("/admin").Use(authMiddleware(server.tokenMaker), adminMiddleware(server.config.AdminUsers))
+	adminRoutes.GET("/usage", server.ListUsage)
+	adminRoutes.PATCH("/users/:username/quota", server.SetUserQuota)
+
 	server.router = router
 }
 
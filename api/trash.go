@@ -0,0 +1,507 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// trashDirName is the folder, relative to a workspace root, holding the
+// entries deleted inside that root. Each item is stored as <id> next to its
+// metadata in <id>.json. Being hidden, it stays out of listings and search.
+const trashDirName = ".wecom/trash"
+
+// trashJanitorInterval is how often expired items are purged from the homes
+// below the workspace root.
+const trashJanitorInterval = time.Hour
+
+var errTrashItemNotFound = errors.New("Trash item not found.")
+
+// trashItem is the metadata of a deleted entry.
+type trashItem struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"`
+	Name      string    `json:"name"`
+	IsDir     bool      `json:"is_dir"`
+	Size      int64     `json:"size"`
+	DeletedAt time.Time `json:"deleted_at"`
+	DeletedBy string    `json:"deleted_by"`
+	// unset when items are kept until purged
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// trashDirOf returns the trash of the workspace root containing path. A
+// root itself, such as a project, goes to the trash of the root around it.
+func (ws *workspace) trashDirOf(path string) string {
+	best := ""
+	for _, root := range ws.roots {
+		if root.path != path && isWithin(root.path, path) && len(root.path) > len(best) {
+			best = root.path
+		}
+	}
+	if best == "" {
+		best = ws.rootOf(path)
+	}
+	return filepath.Join(best, trashDirName)
+}
+
+// inTrashArea reports whether path is in the .wecom folder of its root.
+// Such paths cannot be moved to the trash and are deleted for good.
+func (ws *workspace) inTrashArea(path string) bool {
+	return isWithin(filepath.Dir(ws.trashDirOf(path)), path)
+}
+
+// trashDirs returns the trashes of the roots the user can write to.
+func (ws *workspace) trashDirs() []string {
+	var dirs []string
+	seen := make(map[string]bool)
+	for _, root := range ws.roots {
+		if accessRank[root.access] < accessRank[accessReadWrite] {
+			continue
+		}
+		dir := filepath.Join(root.path, trashDirName)
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// canAccessTrashItem reports whether the user may restore or purge item:
+// they need write access to the place it was deleted from.
+func (ws *workspace) canAccessTrashItem(item trashItem) bool {
+	_, err := ws.resolve(item.Path, accessReadWrite)
+	return err == nil
+}
+
+// ensureTrashDir creates the trash folders of a root, owned like the root.
+func ensureTrashDir(trashDir string) error {
+	for _, dir := range []string{filepath.Dir(trashDir), trashDir} {
+		err := os.Mkdir(dir, 0700)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := chownLikeParent(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// treeSize returns the total size of the regular files below path.
+func treeSize(path string) int64 {
+	var size int64
+	filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if entry.Type().IsRegular() {
+			if info, err := entry.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// moveEntry renames src to dst, copying it when they are on different file
+// systems.
+func moveEntry(src, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	if err := copyPath(src, dst); err != nil {
+		os.RemoveAll(dst)
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+// moveToTrash moves path into the trash of its workspace root.
+func (server *Server) moveToTrash(ws *workspace, path string, info os.FileInfo) (trashItem, error) {
+	trashDir := ws.trashDirOf(path)
+	if err := ensureTrashDir(trashDir); err != nil {
+		return trashItem{}, err
+	}
+	server.purgeExpiredTrash(trashDir)
+
+	item := trashItem{
+		ID:        uuid.NewString(),
+		Path:      strings.TrimPrefix(path, "/"),
+		Name:      filepath.Base(path),
+		IsDir:     info.IsDir(),
+		Size:      info.Size(),
+		DeletedAt: time.Now().UTC(),
+		DeletedBy: ws.username,
+	}
+	if item.IsDir {
+		item.Size = treeSize(path)
+	}
+
+	data, err := json.Marshal(item)
+	if err != nil {
+		return item, err
+	}
+	metaPath := filepath.Join(trashDir, item.ID+".json")
+	if err := writeFileAtomic(metaPath, data); err != nil {
+		return item, err
+	}
+	if err := moveEntry(path, filepath.Join(trashDir, item.ID)); err != nil {
+		os.Remove(metaPath)
+		return item, err
+	}
+	return item, nil
+}
+
+// discardPath moves path to the trash. Paths with no trash to go to, the
+// trash area itself and roots no other root contains, are deleted for good
+// and come back with an empty item.
+func (server *Server) discardPath(ws *workspace, path string) (trashItem, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return trashItem{}, err
+	}
+	if ws.inTrashArea(path) || isWithin(path, ws.trashDirOf(path)) {
+		return trashItem{}, os.RemoveAll(path)
+	}
+	return server.moveToTrash(ws, path, info)
+}
+
+// readTrashItem loads the metadata of the item id of a trash.
+func readTrashItem(trashDir, id string) (trashItem, error) {
+	var item trashItem
+	data, err := os.ReadFile(filepath.Join(trashDir, id+".json"))
+	if err != nil {
+		return item, err
+	}
+	if err := json.Unmarshal(data, &item); err != nil {
+		return item, err
+	}
+	if item.ID != id {
+		return item, errTrashItemNotFound
+	}
+	return item, nil
+}
+
+// listTrash returns the items of a trash, skipping metadata whose entry is
+// gone.
+func listTrash(trashDir string) []trashItem {
+	entries, err := os.ReadDir(trashDir)
+	if err != nil {
+		return nil
+	}
+	var items []trashItem
+	for _, entry := range entries {
+		id := strings.TrimSuffix(entry.Name(), ".json")
+		if _, err := uuid.Parse(id); err != nil || id == entry.Name() {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(trashDir, id)); err != nil {
+			continue
+		}
+		item, err := readTrashItem(trashDir, id)
+		if err == nil {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// purgeTrashItem deletes an item and its metadata for good.
+func purgeTrashItem(trashDir, id string) error {
+	if err := os.RemoveAll(filepath.Join(trashDir, id)); err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(trashDir, id+".json"))
+}
+
+// trashExpiry returns when an item deleted at deletedAt expires, or nil when
+// items are kept until purged.
+func (server *Server) trashExpiry(deletedAt time.Time) *time.Time {
+	if server.config.TrashRetentionDays <= 0 {
+		return nil
+	}
+	expiresAt := deletedAt.Add(time.Duration(server.config.TrashRetentionDays) * 24 * time.Hour)
+	return &expiresAt
+}
+
+// purgeExpiredTrash purges the expired items of a trash and returns the
+// remaining ones.
+func (server *Server) purgeExpiredTrash(trashDir string) []trashItem {
+	now := time.Now()
+	var kept []trashItem
+	for _, item := range listTrash(trashDir) {
+		item.ExpiresAt = server.trashExpiry(item.DeletedAt)
+		if item.ExpiresAt != nil && now.After(*item.ExpiresAt) {
+			if err := purgeTrashItem(trashDir, item.ID); err != nil {
+				log.Println("cannot purge expired trash item:", err)
+			}
+			continue
+		}
+		kept = append(kept, item)
+	}
+	return kept
+}
+
+// expireTrash purges the expired items of every home every
+// trashJanitorInterval. Trashes of shared roots outside the homes are
+// expired when they are listed or deleted into.
+func (server *Server) expireTrash() {
+	if server.config.TrashRetentionDays <= 0 {
+		return
+	}
+	for {
+		homes, _ := os.ReadDir(server.config.WorkspaceRoot)
+		for _, home := range homes {
+			if home.IsDir() {
+				server.purgeExpiredTrash(filepath.Join(server.config.WorkspaceRoot, home.Name(), trashDirName))
+			}
+		}
+		time.Sleep(trashJanitorInterval)
+	}
+}
+
+// findTrashItem looks id up in the trashes the user can access.
+func (ws *workspace) findTrashItem(id string) (string, trashItem, error) {
+	for _, trashDir := range ws.trashDirs() {
+		if _, err := os.Lstat(filepath.Join(trashDir, id)); err != nil {
+			continue
+		}
+		item, err := readTrashItem(trashDir, id)
+		if err != nil || !ws.canAccessTrashItem(item) {
+			continue
+		}
+		return trashDir, item, nil
+	}
+	return "", trashItem{}, errTrashItemNotFound
+}
+
+// ListTrash lists the items the user can restore, newest first. Expired
+// items are purged on the way.
+func (server *Server) ListTrash(ctx *gin.Context) {
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+
+	items := []trashItem{}
+	for _, trashDir := range ws.trashDirs() {
+		for _, item := range server.purgeExpiredTrash(trashDir) {
+			if ws.canAccessTrashItem(item) {
+				items = append(items, item)
+			}
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].DeletedAt.After(items[j].DeletedAt)
+	})
+	ctx.JSON(http.StatusOK, items)
+}
+
+type purgeTrashResponse struct {
+	Message string `json:"message"`
+	Purged  int    `json:"purged"`
+}
+
+type trashItemURI struct {
+	ID string `uri:"id" binding:"required,uuid"`
+}
+
+type restoreTrashRequest struct {
+	// where to restore the item, its original path by default
+	DestStr string `json:"dest_str"`
+	// what to do when the destination exists: fail with 409, restore under
+	// a free name such as "main (restored).go", or replace it
+	Conflict string `json:"conflict" binding:"omitempty,oneof=fail rename overwrite"`
+}
+
+// restoredName returns a free path next to dst for a restored entry.
+func restoredName(dst string, isDir bool) string {
+	dir, base := filepath.Split(dst)
+	ext := ""
+	if !isDir && !strings.HasPrefix(base, ".") {
+		ext = filepath.Ext(base)
+	}
+	stem := strings.TrimSuffix(base, ext)
+	for n := 1; ; n++ {
+		suffix := " (restored)"
+		if n > 1 {
+			suffix = " (restored " + strconv.Itoa(n) + ")"
+		}
+		candidate := filepath.Join(dir, stem+suffix+ext)
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
+
+// RestoreTrashItem moves an item back to its original path or to dest_str,
+// recreating missing parent directories.
+func (server *Server) RestoreTrashItem(ctx *gin.Context) {
+	var uri trashItemURI
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	var req restoreTrashRequest
+	if ctx.Request.ContentLength != 0 {
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.JSON(http.StatusBadRequest, errorResponse(err))
+			return
+		}
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	trashDir, item, err := ws.findTrashItem(uri.ID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, errorResponse(err))
+		return
+	}
+	unlock := server.fileLocks.lock(filepath.Join(trashDir, item.ID))
+	defer unlock()
+
+	destStr := req.DestStr
+	if destStr == "" {
+		destStr = item.Path
+	}
+	dstPath, err := ws.resolve(destStr, accessReadWrite)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	if ws.isRoot(dstPath) || ws.inTrashArea(dstPath) {
+		ctx.JSON(http.StatusBadRequest, errorResponse(errWorkspaceRoot))
+		return
+	}
+
+	// recreate the folders deleted since, owned like their parents
+	var created []string
+	for parent := filepath.Dir(dstPath); ; parent = filepath.Dir(parent) {
+		if _, err := os.Stat(parent); err == nil {
+			break
+		}
+		created = append([]string{parent}, created...)
+	}
+	if len(created) > 0 {
+		if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+			ctx.JSON(mutationErrorStatus(err), errorResponse(err))
+			return
+		}
+		for _, dir := range created {
+			if err := chownLikeParent(dir); err != nil {
+				ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+				return
+			}
+		}
+	}
+
+	if req.Conflict == "rename" {
+		if _, err := os.Lstat(dstPath); err == nil {
+			dstPath = restoredName(dstPath, item.IsDir)
+		}
+	}
+	if err := server.prepareDestination(ws, dstPath, req.Conflict == "overwrite"); err != nil {
+		ctx.JSON(mutationErrorStatus(err), errorResponse(err))
+		return
+	}
+	if err := moveEntry(filepath.Join(trashDir, item.ID), dstPath); err != nil {
+		ctx.JSON(mutationErrorStatus(err), errorResponse(err))
+		return
+	}
+	if err := chownLikeParent(dstPath); err != nil {
+		log.Println("cannot chown restored entry:", err)
+	}
+	if err := os.Remove(filepath.Join(trashDir, item.ID+".json")); err != nil {
+		log.Println("cannot remove trash metadata:", err)
+	}
+	server.index.Notify(dstPath)
+
+	topDir := filepath.Dir(dstPath)
+	if len(created) > 0 {
+		topDir = filepath.Dir(created[0])
+	}
+	res, err := server.newFileMutationResponse(dstPath, "Success restore", topDir)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+	ctx.JSON(http.StatusOK, res)
+}
+
+// PurgeTrashItem deletes one item of the trash for good.
+func (server *Server) PurgeTrashItem(ctx *gin.Context) {
+	var uri trashItemURI
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	trashDir, item, err := ws.findTrashItem(uri.ID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, errorResponse(err))
+		return
+	}
+	unlock := server.fileLocks.lock(filepath.Join(trashDir, item.ID))
+	defer unlock()
+
+	if err := purgeTrashItem(trashDir, item.ID); err != nil {
+		ctx.JSON(mutationErrorStatus(err), errorResponse(err))
+		return
+	}
+	ctx.JSON(http.StatusOK, purgeTrashResponse{Message: "Success purge", Purged: 1})
+}
+
+// EmptyTrash deletes every item the user can access for good.
+func (server *Server) EmptyTrash(ctx *gin.Context) {
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+
+	purged := 0
+	for _, trashDir := range ws.trashDirs() {
+		for _, item := range listTrash(trashDir) {
+			if !ws.canAccessTrashItem(item) {
+				continue
+			}
+			unlock := server.fileLocks.lock(filepath.Join(trashDir, item.ID))
+			err := purgeTrashItem(trashDir, item.ID)
+			unlock()
+			if err != nil {
+				ctx.JSON(mutationErrorStatus(err), errorResponse(err))
+				return
+			}
+			purged++
+		}
+	}
+	ctx.JSON(http.StatusOK, purgeTrashResponse{Message: "Success empty trash", Purged: purged})
+}
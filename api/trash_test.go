@@ -0,0 +1,128 @@
+package api
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/diantanjung/wecom/util"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func testWorkspace(t *testing.T) *workspace {
+	root := filepath.Join(t.TempDir(), "dian")
+	require.NoError(t, os.MkdirAll(root, 0755))
+	return &workspace{
+		username: "dian",
+		root:     root,
+		realRoot: root,
+		roots:    []workspaceRoot{{path: root, realPath: root, access: accessOwner}},
+	}
+}
+
+func TestPrepareDestinationTrashes(t *testing.T) {
+	server := &Server{}
+	ws := testWorkspace(t)
+	dst := filepath.Join(ws.root, "main.go")
+	require.NoError(t, os.WriteFile(dst, []byte("old"), 0644))
+
+	require.ErrorIs(t, server.prepareDestination(ws, dst, false), errDestinationExists)
+	require.NoError(t, server.prepareDestination(ws, dst, true))
+	require.NoFileExists(t, dst)
+
+	items := listTrash(ws.trashDirOf(dst))
+	require.Len(t, items, 1)
+	require.Equal(t, "main.go", items[0].Name)
+	data, err := os.ReadFile(filepath.Join(ws.trashDirOf(dst), items[0].ID))
+	require.NoError(t, err)
+	require.Equal(t, "old", string(data))
+}
+
+func TestDiscardProjectRoot(t *testing.T) {
+	server := &Server{}
+	ws := testWorkspace(t)
+	project := filepath.Join(ws.root, "project")
+	require.NoError(t, os.MkdirAll(project, 0755))
+	ws.roots = append(ws.roots, workspaceRoot{path: project, realPath: project, access: accessOwner})
+
+	// a project goes to the trash of the home around it, not its own
+	item, err := server.discardPath(ws, project)
+	require.NoError(t, err)
+	require.NotEmpty(t, item.ID)
+	require.NoDirExists(t, project)
+	require.DirExists(t, filepath.Join(ws.root, trashDirName, item.ID))
+}
+
+// writeTrashEntry puts a file deleted at deletedAt into trashDir.
+func writeTrashEntry(t *testing.T, trashDir string, deletedAt time.Time) trashItem {
+	item := trashItem{ID: uuid.NewString(), Path: "dian/main.go", Name: "main.go", DeletedAt: deletedAt}
+	require.NoError(t, os.MkdirAll(trashDir, 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(trashDir, item.ID), []byte("package main"), 0644))
+	data, err := json.Marshal(item)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(trashDir, item.ID+".json"), data, 0600))
+	return item
+}
+
+func TestRestoredName(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"main.go", "main (restored).go", "src", ".env", ".env (restored)"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+
+	tests := []struct {
+		name     string
+		isDir    bool
+		restored string
+	}{
+		{"main.go", false, "main (restored 2).go"},
+		{"util.go", false, "util (restored).go"},
+		{"src", true, "src (restored)"},
+		{"archive.tar.gz", false, "archive.tar (restored).gz"},
+		{"lib.v2", true, "lib.v2 (restored)"},
+		{".env", false, ".env (restored 2)"},
+	}
+	for _, tc := range tests {
+		require.Equal(t, filepath.Join(dir, tc.restored), restoredName(filepath.Join(dir, tc.name), tc.isDir), tc.name)
+	}
+}
+
+func TestPurgeExpiredTrash(t *testing.T) {
+	trashDir := filepath.Join(t.TempDir(), trashDirName)
+	expired := writeTrashEntry(t, trashDir, time.Now().Add(-8*24*time.Hour))
+	recent := writeTrashEntry(t, trashDir, time.Now().Add(-time.Hour))
+
+	// items are kept until purged without a retention
+	server := &Server{}
+	require.Len(t, server.purgeExpiredTrash(trashDir), 2)
+
+	server.config = util.Config{TrashRetentionDays: 7}
+	kept := server.purgeExpiredTrash(trashDir)
+	require.Len(t, kept, 1)
+	require.Equal(t, recent.ID, kept[0].ID)
+	require.NotNil(t, kept[0].ExpiresAt)
+	require.NoFileExists(t, filepath.Join(trashDir, expired.ID))
+	require.NoFileExists(t, filepath.Join(trashDir, expired.ID+".json"))
+	require.FileExists(t, filepath.Join(trashDir, recent.ID))
+}
+
+func TestReadTrashItemIDMismatch(t *testing.T) {
+	trashDir := filepath.Join(t.TempDir(), trashDirName)
+	item := writeTrashEntry(t, trashDir, time.Now())
+	_, err := readTrashItem(trashDir, item.ID)
+	require.NoError(t, err)
+
+	// metadata copied under another id must not resolve to the other entry
+	other := uuid.NewString()
+	data, err := os.ReadFile(filepath.Join(trashDir, item.ID+".json"))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(trashDir, other+".json"), data, 0600))
+	_, err = readTrashItem(trashDir, other)
+	require.ErrorIs(t, err, errTrashItemNotFound)
+
+	_, err = readTrashItem(trashDir, uuid.NewString())
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,274 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/diantanjung/wecom/util"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultTreeDepth = 1
+	// maxTreeNodes bounds the entries of one tree response
+	maxTreeNodes = 20000
+)
+
+// ignoreFileNames are read in every directory of a tree. .wecomignore holds
+// rules for the editor only, such as large generated folders a project
+// keeps in git.
+var ignoreFileNames = []string{".gitignore", ".wecomignore"}
+
+// defaultIgnore collapses the dependency and build folders of the supported
+// languages, relative to the workspace root.
+const defaultIgnore = ".git/\nnode_modules/\nvendor/\ntarget/\n__pycache__/\n/go/pkg/\n/go/bin/\n"
+
+type treeRequest struct {
+	PathStr    string `json:"path_str" binding:"required"`
+	Depth      int    `json:"depth" binding:"min=0,max=32"`
+	Sort       string `json:"sort" binding:"omitempty,oneof=name type size mod_time"`
+	Desc       bool   `json:"desc"`
+	ShowHidden bool   `json:"show_hidden"`
+}
+
+// treeNode is an entry of a directory tree. Ignored entries are listed but
+// their directories are not walked; like directories beyond the requested
+// depth they are collapsed, and the client asks for their subtree when they
+// are expanded.
+type treeNode struct {
+	dirContent
+	Ignored   bool       `json:"ignored,omitempty"`
+	Collapsed bool       `json:"collapsed,omitempty"`
+	Children  []treeNode `json:"children,omitempty"`
+}
+
+type treeResponse struct {
+	treeNode
+	// the tree stopped at maxTreeNodes entries
+	Truncated bool `json:"truncated"`
+}
+
+// treeWalker lists the directories of one tree request.
+type treeWalker struct {
+	root          string
+	showHidden    bool
+	less          func(a, b *treeNode) bool
+	largeFileSize int64
+	nodes         int
+	truncated     bool
+}
+
+func treeLess(key string, desc bool) func(a, b *treeNode) bool {
+	byName := func(a, b *treeNode) bool {
+		an, bn := strings.ToLower(a.Filename), strings.ToLower(b.Filename)
+		if an != bn {
+			return an < bn
+		}
+		return a.Filename < b.Filename
+	}
+	var cmp func(a, b *treeNode) bool
+	switch key {
+	case "type":
+		cmp = func(a, b *treeNode) bool {
+			ae, be := strings.ToLower(filepath.Ext(a.Filename)), strings.ToLower(filepath.Ext(b.Filename))
+			if ae != be {
+				return ae < be
+			}
+			return byName(a, b)
+		}
+	case "size":
+		cmp = func(a, b *treeNode) bool {
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+			return byName(a, b)
+		}
+	case "mod_time":
+		cmp = func(a, b *treeNode) bool {
+			if a.ModTime != b.ModTime {
+				return a.ModTime < b.ModTime
+			}
+			return byName(a, b)
+		}
+	default:
+		cmp = byName
+	}
+
+	// directories come first whatever the order
+	return func(a, b *treeNode) bool {
+		if a.IsDir != b.IsDir {
+			return a.IsDir
+		}
+		if desc {
+			return cmp(b, a)
+		}
+		return cmp(a, b)
+	}
+}
+
+// addIgnoreFiles extends ig with the ignore files of dir, whose path relative
+// to the workspace root is rel.
+func addIgnoreFiles(ig util.Ignore, dir, rel string) util.Ignore {
+	for _, name := range ignoreFileNames {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err == nil {
+			ig = ig.Add(rel, string(data))
+		}
+	}
+	return ig
+}
+
+// ignoreRules returns the rules in effect in dir: the defaults and the ignore
+// files of root and of every directory down to dir.
+func ignoreRules(root, dir string) util.Ignore {
+	ig := util.Ignore{}.Add("", defaultIgnore)
+	ig = addIgnoreFiles(ig, root, "")
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == "." {
+		return ig
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i := range parts {
+		relDir := strings.Join(parts[:i+1], "/")
+		ig = addIgnoreFiles(ig, filepath.Join(root, relDir), relDir)
+	}
+	return ig
+}
+
+// ignoreCache gives the ignore rules of the directories below a listed
+// directory, reading each ignore file once.
+type ignoreCache struct {
+	root string
+	top  string
+	dirs map[string]util.Ignore
+}
+
+func newIgnoreCache(root, dir string) *ignoreCache {
+	return &ignoreCache{
+		root: root,
+		top:  dir,
+		dirs: map[string]util.Ignore{dir: ignoreRules(root, dir)},
+	}
+}
+
+// rules returns the rules in effect in dir, a directory below the listed one.
+func (c *ignoreCache) rules(dir string) util.Ignore {
+	if ig, ok := c.dirs[dir]; ok {
+		return ig
+	}
+	if !isWithin(c.top, dir) {
+		return c.dirs[c.top]
+	}
+	rel, _ := filepath.Rel(c.root, dir)
+	ig := addIgnoreFiles(c.rules(filepath.Dir(dir)), dir, filepath.ToSlash(rel))
+	c.dirs[dir] = ig
+	return ig
+}
+
+// match reports whether the file at path is ignored.
+func (c *ignoreCache) match(path string) bool {
+	rel, err := filepath.Rel(c.root, path)
+	if err != nil {
+		return false
+	}
+	return c.rules(filepath.Dir(path)).Match(filepath.ToSlash(rel), false)
+}
+
+// walk lists dirPath and, while depth allows, its subdirectories.
+func (w *treeWalker) walk(dirPath, pathStr string, ig util.Ignore, depth int) []treeNode {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil
+	}
+
+	var nodes []treeNode
+	for _, entry := range entries {
+		if !w.showHidden && isHiddenName(entry.Name()) {
+			continue
+		}
+		if w.nodes >= maxTreeNodes {
+			w.truncated = true
+			break
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(dirPath, entry.Name())
+		rel, _ := filepath.Rel(w.root, path)
+		node := treeNode{
+			dirContent: newDirContent(path, pathStr+"/"+entry.Name(), info, w.largeFileSize),
+			Ignored:    ig.Match(filepath.ToSlash(rel), info.IsDir()),
+		}
+		node.Collapsed = node.IsDir && (node.Ignored || depth <= 1)
+		nodes = append(nodes, node)
+		w.nodes++
+	}
+
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return w.less(&nodes[i], &nodes[j])
+	})
+
+	for i := range nodes {
+		node := &nodes[i]
+		if !node.IsDir || node.Collapsed || w.truncated {
+			continue
+		}
+		path := filepath.Join(dirPath, node.Filename)
+		rel, _ := filepath.Rel(w.root, path)
+		node.Children = w.walk(path, node.Path, addIgnoreFiles(ig, path, filepath.ToSlash(rel)), depth-1)
+	}
+	return nodes
+}
+
+// GetTree lists a directory tree down to the requested depth, skipping the
+// directories ignored by .gitignore, .wecomignore and the default rules.
+func (server *Server) GetTree(ctx *gin.Context) {
+	var req treeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	if req.Depth == 0 {
+		req.Depth = defaultTreeDepth
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	dirPath, err := ws.resolve(req.PathStr, accessReadOnly)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	info, err := os.Stat(dirPath)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	if !info.IsDir() {
+		ctx.JSON(http.StatusBadRequest, errorResponse(errors.New("Path is not a directory.")))
+		return
+	}
+
+	root := ws.rootOf(dirPath)
+	w := &treeWalker{
+		root:          root,
+		showHidden:    req.ShowHidden,
+		less:          treeLess(req.Sort, req.Desc),
+		largeFileSize: server.config.LargeFileSize,
+	}
+	pathStr := strings.TrimSuffix(req.PathStr, "/")
+
+	var res treeResponse
+	res.dirContent = newDirContent(dirPath, pathStr, info, server.config.LargeFileSize)
+	res.Children = w.walk(dirPath, pathStr, ignoreRules(root, dirPath), req.Depth)
+	res.Truncated = w.truncated
+	ctx.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,352 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	db "github.com/diantanjung/wecom/db/sqlc"
+	"github.com/diantanjung/wecom/util"
+	"github.com/gin-gonic/gin"
+)
+
+var errQuotaExceeded = errors.New("Disk quota exceeded.")
+
+// buildCacheRules single out, relative to a home, the dependencies and build
+// output of the supported languages. They can be rebuilt, so users are told
+// apart how much of their usage they are.
+var buildCacheRules = util.Ignore{}.Add("", "target/\nnode_modules/\n__pycache__/\n/go/pkg/\n/go/bin/\n/.cache/\n/.cargo/\n/.rustup/\n")
+
+type usageCategories struct {
+	Source     int64 `json:"source"`
+	BuildCache int64 `json:"build_cache"`
+	Trash      int64 `json:"trash"`
+}
+
+// workspaceUsage is the usage of a top level folder of a home.
+type workspaceUsage struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+}
+
+// diskUsage is the space a home takes, counted in file sizes like the
+// growth of the writes checked against the quota.
+type diskUsage struct {
+	Username   string           `json:"username"`
+	UsedBytes  int64            `json:"used_bytes"`
+	QuotaBytes int64            `json:"quota_bytes"`
+	OverQuota  bool             `json:"over_quota"`
+	Categories usageCategories  `json:"categories"`
+	Workspaces []workspaceUsage `json:"workspaces"`
+	MeasuredAt time.Time        `json:"measured_at"`
+}
+
+// measureUsage walks home and sums the sizes of its entries by category and
+// top level folder. Hard linked files are counted once.
+func measureUsage(home string) diskUsage {
+	usage := diskUsage{Workspaces: []workspaceUsage{}, MeasuredAt: time.Now().UTC()}
+	workspaces := make(map[string]int64)
+	type inode struct{ dev, ino uint64 }
+	seen := make(map[inode]bool)
+
+	filepath.WalkDir(home, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || path == home {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+		if stat.Nlink > 1 && !info.IsDir() {
+			key := inode{uint64(stat.Dev), uint64(stat.Ino)}
+			if seen[key] {
+				return nil
+			}
+			seen[key] = true
+		}
+		size := info.Size()
+
+		rel, _ := filepath.Rel(home, path)
+		rel = filepath.ToSlash(rel)
+		switch {
+		case rel == trashDirName || strings.HasPrefix(rel, trashDirName+"/"):
+			usage.Categories.Trash += size
+		case buildCacheRules.Match(rel, info.IsDir()):
+			usage.Categories.BuildCache += size
+		default:
+			usage.Categories.Source += size
+		}
+		usage.UsedBytes += size
+
+		if top, _, _ := strings.Cut(rel, "/"); !isHiddenName(top) && (top != rel || info.IsDir()) {
+			workspaces[top] += size
+		}
+		return nil
+	})
+
+	for top, size := range workspaces {
+		usage.Workspaces = append(usage.Workspaces, workspaceUsage{
+			Path:  strings.TrimPrefix(filepath.Join(home, top), "/"),
+			Bytes: size,
+		})
+	}
+	sort.Slice(usage.Workspaces, func(i, j int) bool {
+		return usage.Workspaces[i].Bytes > usage.Workspaces[j].Bytes
+	})
+	return usage
+}
+
+// usageCache keeps the last measured usage of each home, adjusted by the
+// writes made through the API since. The zero value is ready to use.
+type usageCache struct {
+	mu    sync.Mutex
+	homes map[string]diskUsage
+}
+
+// get returns the usage of home, measuring it again when older than maxAge.
+func (c *usageCache) get(home string, maxAge time.Duration) diskUsage {
+	c.mu.Lock()
+	usage, ok := c.homes[home]
+	c.mu.Unlock()
+	if ok && time.Since(usage.MeasuredAt) < maxAge {
+		return usage
+	}
+
+	// measure unlocked, walking a large home must not stall other users
+	usage = measureUsage(home)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.homes == nil {
+		c.homes = make(map[string]diskUsage)
+	}
+	c.homes[home] = usage
+	return usage
+}
+
+// add accounts for delta bytes written to the sources of home.
+func (c *usageCache) add(home string, delta int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if usage, ok := c.homes[home]; ok {
+		usage.UsedBytes += delta
+		usage.Categories.Source += delta
+		c.homes[home] = usage
+	}
+}
+
+// homeOf returns the home below the workspace root containing path and its
+// owner. Paths outside the homes are not subject to quotas.
+func (server *Server) homeOf(path string) (string, string, bool) {
+	rel, err := filepath.Rel(server.config.WorkspaceRoot, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", "", false
+	}
+	username, _, _ := strings.Cut(filepath.ToSlash(rel), "/")
+	return filepath.Join(server.config.WorkspaceRoot, username), username, true
+}
+
+// quotaOf returns the quota of a user in bytes, 0 when unlimited. A user
+// quota of 0 falls back to DEFAULT_QUOTA_BYTES and a negative one lifts
+// the limit.
+func quotaOf(user db.User, defaultQuota int64) int64 {
+	switch {
+	case user.QuotaBytes < 0:
+		return 0
+	case user.QuotaBytes > 0:
+		return user.QuotaBytes
+	}
+	return defaultQuota
+}
+
+func (server *Server) userQuota(ctx *gin.Context, username string) int64 {
+	user, err := server.querier.GetUser(ctx, username)
+	if err != nil {
+		return server.config.DefaultQuotaBytes
+	}
+	return quotaOf(user, server.config.DefaultQuotaBytes)
+}
+
+// reserveQuota accounts for a write growing the home containing path by
+// growth bytes. It responds with 507 and returns false when the write would
+// take the owner over quota. The returned func gives the reservation back
+// when the write fails. Usage changed outside the API, e.g. from the
+// terminal, is caught up with every USAGE_REFRESH.
+func (server *Server) reserveQuota(ctx *gin.Context, path string, growth int64) (func(), bool) {
+	home, username, ok := server.homeOf(path)
+	if !ok {
+		return func() {}, true
+	}
+	if !server.fitsQuota(ctx, home, username, growth) {
+		ctx.JSON(http.StatusInsufficientStorage, errorResponse(errQuotaExceeded))
+		return nil, false
+	}
+	server.usage.add(home, growth)
+	return func() { server.usage.add(home, -growth) }, true
+}
+
+// reserveQuotaChanges is reserveQuota for writing changes at once. The
+// growth is checked in total per home and nothing is reserved when any home
+// would go over quota. The returned func gives the reservation back when
+// the changes could not be applied.
+func (server *Server) reserveQuotaChanges(ctx *gin.Context, changes []fileChange) (func(), bool) {
+	growth := make(map[string]int64)
+	owners := make(map[string]string)
+	for _, change := range changes {
+		home, username, ok := server.homeOf(change.path)
+		if !ok {
+			continue
+		}
+		growth[home] += int64(len(change.after) - len(change.before))
+		owners[home] = username
+	}
+	for home, delta := range growth {
+		if !server.fitsQuota(ctx, home, owners[home], delta) {
+			ctx.JSON(http.StatusInsufficientStorage, errorResponse(errQuotaExceeded))
+			return nil, false
+		}
+	}
+	for home, delta := range growth {
+		server.usage.add(home, delta)
+	}
+	release := func() {
+		for home, delta := range growth {
+			server.usage.add(home, -delta)
+		}
+	}
+	return release, true
+}
+
+// fitsQuota tells whether home of username may grow by growth bytes.
+func (server *Server) fitsQuota(ctx *gin.Context, home, username string, growth int64) bool {
+	if growth <= 0 {
+		return true
+	}
+	limit := server.userQuota(ctx, username)
+	if limit <= 0 {
+		return true
+	}
+	usage := server.usage.get(home, server.config.UsageRefresh)
+	return usage.UsedBytes+growth <= limit
+}
+
+// fileGrowth returns how much writing size bytes to path grows it.
+func fileGrowth(path string, size int64) int64 {
+	if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+		return size - info.Size()
+	}
+	return size
+}
+
+func (server *Server) homeUsage(home, username string, quota int64, refresh bool) diskUsage {
+	maxAge := server.config.UsageRefresh
+	if refresh {
+		maxAge = 0
+	}
+	usage := server.usage.get(home, maxAge)
+	usage.Username = username
+	usage.QuotaBytes = quota
+	usage.OverQuota = quota > 0 && usage.UsedBytes > quota
+	return usage
+}
+
+type usageRequest struct {
+	// measure again instead of using the cached usage
+	Refresh bool `form:"refresh"`
+}
+
+// GetUsage reports the disk usage of the user's home against their quota.
+func (server *Server) GetUsage(ctx *gin.Context) {
+	var req usageRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+	quota := server.userQuota(ctx, ws.username)
+	ctx.JSON(http.StatusOK, server.homeUsage(ws.root, ws.username, quota, req.Refresh))
+}
+
+// ListUsage reports the usage of every user, the heaviest first.
+func (server *Server) ListUsage(ctx *gin.Context) {
+	var req usageRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	users, err := server.querier.ListUsers(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+	res := []diskUsage{}
+	for _, user := range users {
+		home, username, ok := server.homeOf(filepath.Join(server.config.WorkspaceRoot, user.Username))
+		if !ok || username != user.Username {
+			continue
+		}
+		quota := quotaOf(user, server.config.DefaultQuotaBytes)
+		res = append(res, server.homeUsage(home, username, quota, req.Refresh))
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].UsedBytes > res[j].UsedBytes
+	})
+	ctx.JSON(http.StatusOK, res)
+}
+
+type userQuotaURI struct {
+	Username string `uri:"username" binding:"required"`
+}
+
+type userQuotaRequest struct {
+	// 0 applies DEFAULT_QUOTA_BYTES, -1 lifts the limit
+	QuotaBytes *int64 `json:"quota_bytes" binding:"required,min=-1"`
+}
+
+// SetUserQuota changes the quota of a user.
+func (server *Server) SetUserQuota(ctx *gin.Context) {
+	var uri userQuotaURI
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	var req userQuotaRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	user, err := server.querier.UpdateUserQuota(ctx, db.UpdateUserQuotaParams{
+		Username:   uri.Username,
+		QuotaBytes: *req.QuotaBytes,
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.JSON(http.StatusNotFound, errorResponse(err))
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+
+	home := filepath.Join(server.config.WorkspaceRoot, user.Username)
+	quota := quotaOf(user, server.config.DefaultQuotaBytes)
+	ctx.JSON(http.StatusOK, server.homeUsage(home, user.Username, quota, false))
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	db "github.com/diantanjung/wecom/db/sqlc"
+	"github.com/diantanjung/wecom/util"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMeasureUsage(t *testing.T) {
+	home := t.TempDir()
+	write := func(rel string, size int) {
+		path := filepath.Join(home, rel)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, bytes.Repeat([]byte("x"), size), 0644))
+	}
+	write("project/main.go", 10000)
+	write("project/target/debug/app", 20000)
+	write("go/pkg/mod/cache.zip", 3000)
+	write(".wecom/trash/item/old.go", 40000)
+	before := measureUsage(home)
+	require.NoError(t, os.Link(filepath.Join(home, "project/main.go"), filepath.Join(home, "project/link.go")))
+
+	usage := measureUsage(home)
+	require.Greater(t, usage.Categories.Source, int64(0))
+	require.GreaterOrEqual(t, usage.Categories.BuildCache, int64(23000))
+	require.GreaterOrEqual(t, usage.Categories.Trash, int64(40000))
+	require.Equal(t, usage.UsedBytes, usage.Categories.Source+usage.Categories.BuildCache+usage.Categories.Trash)
+
+	// the hard link is counted once and the trash is not a workspace
+	require.Equal(t, before.UsedBytes, usage.UsedBytes)
+	require.Len(t, usage.Workspaces, 2)
+	require.Equal(t, filepath.Join(home, "project")[1:], usage.Workspaces[0].Path)
+}
+
+// quotaQuerier serves users with the default quota.
+type quotaQuerier struct {
+	db.Querier
+}
+
+func (quotaQuerier) GetUser(ctx context.Context, username string) (db.User, error) {
+	return db.User{Username: username}, nil
+}
+
+func TestReserveQuotaChanges(t *testing.T) {
+	root := t.TempDir()
+	home := filepath.Join(root, "dian")
+	require.NoError(t, os.MkdirAll(home, 0755))
+	server := &Server{
+		config: util.Config{
+			WorkspaceRoot:     root,
+			DefaultQuotaBytes: 1000,
+			UsageRefresh:      time.Hour,
+		},
+		querier: quotaQuerier{},
+	}
+	used := func() int64 {
+		return server.usage.get(home, time.Hour).UsedBytes
+	}
+	change := func(name string, size int) fileChange {
+		return fileChange{path: filepath.Join(home, name), after: bytes.Repeat([]byte("x"), size)}
+	}
+	reserve := func(changes ...fileChange) (func(), int) {
+		recorder := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(recorder)
+		ctx.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+		release, ok := server.reserveQuotaChanges(ctx, changes)
+		if !ok {
+			return nil, recorder.Code
+		}
+		return release, http.StatusOK
+	}
+	start := used()
+
+	// every file fits on its own, together they do not
+	_, code := reserve(change("a.go", 600), change("b.go", 600))
+	require.Equal(t, http.StatusInsufficientStorage, code)
+	require.Equal(t, start, used())
+
+	release, code := reserve(change("a.go", 300), change("b.go", 300))
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, start+600, used())
+	release()
+	require.Equal(t, start, used())
+
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+	release, ok := server.reserveQuota(ctx, filepath.Join(home, "a.go"), 900)
+	require.True(t, ok)
+	require.Equal(t, start+900, used())
+	_, ok = server.reserveQuota(ctx, filepath.Join(home, "b.go"), 200)
+	require.False(t, ok)
+	require.Equal(t, http.StatusInsufficientStorage, recorder.Code)
+	release()
+	require.Equal(t, start, used())
+}
@@ -0,0 +1,449 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diantanjung/wecom/watch"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// events on the same path within this window are sent as one
+	watchDebounce     = 150 * time.Millisecond
+	watchPingInterval = 20 * time.Second
+	watchWriteTimeout = 10 * time.Second
+	// batches queued for a slow client before it is asked to reload
+	watchQueueSize = 64
+)
+
+// watchEvent is a change pushed to the client, shaped like the entries of
+// directory listings.
+type watchEvent struct {
+	Op      string `json:"op"`
+	OldPath string `json:"old_path,omitempty"`
+	dirContent
+}
+
+type watchRequest struct {
+	Type    string `json:"type"`
+	PathStr string `json:"path_str"`
+}
+
+type watchMessage struct {
+	Type    string       `json:"type"`
+	PathStr string       `json:"path_str,omitempty"`
+	Error   string       `json:"error,omitempty"`
+	Events  []watchEvent `json:"events,omitempty"`
+}
+
+// watchHub shares one watcher between all connections and routes its events
+// to the connections subscribed to the directories they happen in.
+type watchHub struct {
+	maxWatches int
+	notify     func(path string)
+
+	mu      sync.Mutex
+	watcher *watch.Watcher
+	clients map[*watchClient]struct{}
+}
+
+func newWatchHub(maxWatches int, notify func(path string)) *watchHub {
+	return &watchHub{
+		maxWatches: maxWatches,
+		notify:     notify,
+		clients:    make(map[*watchClient]struct{}),
+	}
+}
+
+// start creates the watcher on first use. Callers hold mu.
+func (hub *watchHub) start() error {
+	if hub.watcher != nil {
+		return nil
+	}
+	watcher, err := watch.New(hub.maxWatches)
+	if err != nil {
+		return err
+	}
+	hub.watcher = watcher
+	go hub.run(watcher)
+	return nil
+}
+
+func (hub *watchHub) run(watcher *watch.Watcher) {
+	for event := range watcher.Events() {
+		hub.notify(event.Path)
+		if event.OldPath != "" {
+			hub.notify(event.OldPath)
+		}
+
+		hub.mu.Lock()
+		for client := range hub.clients {
+			if client.watches(event.Path) || event.OldPath != "" && client.watches(event.OldPath) {
+				client.queue(event)
+			}
+		}
+		hub.mu.Unlock()
+	}
+}
+
+// Add watches root for the index service, which gets its events through
+// notify. A root with more directories than can be watched is not watched
+// at all, its index is refreshed instead.
+func (hub *watchHub) Add(root string) error {
+	hub.mu.Lock()
+	err := hub.start()
+	watcher := hub.watcher
+	hub.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	err = watcher.Add(root)
+	if errors.Is(err, watch.ErrTooManyWatches) {
+		watcher.Remove(root)
+	}
+	return err
+}
+
+// Remove stops watching a root added with Add.
+func (hub *watchHub) Remove(root string) {
+	hub.mu.Lock()
+	watcher := hub.watcher
+	hub.mu.Unlock()
+	if watcher != nil {
+		watcher.Remove(root)
+	}
+}
+
+// subscribe watches dir for the client. The watcher is used without holding
+// mu: it may be blocked delivering events to run, which needs mu.
+func (hub *watchHub) subscribe(client *watchClient, dir string) error {
+	hub.mu.Lock()
+	err := hub.start()
+	watcher := hub.watcher
+	hub.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if client.hasDir(dir) {
+		return nil
+	}
+
+	err = watcher.Add(dir)
+	if err != nil && !errors.Is(err, watch.ErrTooManyWatches) {
+		return err
+	}
+	client.addDir(dir)
+
+	hub.mu.Lock()
+	hub.clients[client] = struct{}{}
+	hub.mu.Unlock()
+	return err
+}
+
+func (hub *watchHub) unsubscribe(client *watchClient, dir string) {
+	if client.removeDir(dir) {
+		hub.watcher.Remove(dir)
+	}
+}
+
+// leave drops every subscription of a closed connection.
+func (hub *watchHub) leave(client *watchClient) {
+	hub.mu.Lock()
+	delete(hub.clients, client)
+	hub.mu.Unlock()
+
+	for _, dir := range client.removeAll() {
+		hub.watcher.Remove(dir)
+	}
+}
+
+// watchClient is one WebSocket connection. Its events are collected for
+// watchDebounce and coalesced per path before they are sent.
+type watchClient struct {
+	out           chan watchMessage
+	largeFileSize int64
+
+	mu      sync.Mutex
+	dirs    map[string]struct{}
+	pending map[string]watch.Event
+	order   []string
+	timer   *time.Timer
+	closed  bool
+}
+
+func newWatchClient(largeFileSize int64) *watchClient {
+	return &watchClient{
+		out:           make(chan watchMessage, watchQueueSize),
+		largeFileSize: largeFileSize,
+		dirs:          make(map[string]struct{}),
+		pending:       make(map[string]watch.Event),
+	}
+}
+
+func (client *watchClient) hasDir(dir string) bool {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	_, ok := client.dirs[dir]
+	return ok
+}
+
+func (client *watchClient) addDir(dir string) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.dirs[dir] = struct{}{}
+}
+
+func (client *watchClient) removeDir(dir string) bool {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	_, ok := client.dirs[dir]
+	delete(client.dirs, dir)
+	return ok
+}
+
+func (client *watchClient) removeAll() []string {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	var dirs []string
+	for dir := range client.dirs {
+		dirs = append(dirs, dir)
+	}
+	client.dirs = make(map[string]struct{})
+	client.closed = true
+	if client.timer != nil {
+		client.timer.Stop()
+	}
+	return dirs
+}
+
+func (client *watchClient) watches(path string) bool {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	for dir := range client.dirs {
+		if isWithin(dir, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// queue adds an event to the pending batch, merging it with an earlier event
+// on the same path.
+func (client *watchClient) queue(event watch.Event) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.closed {
+		return
+	}
+
+	if event.Op == watch.Rename {
+		// a file created and renamed within the window is just created
+		if prev, ok := client.pending[event.OldPath]; ok && prev.Op == watch.Create {
+			delete(client.pending, event.OldPath)
+			event = watch.Event{Op: watch.Create, Path: event.Path, IsDir: event.IsDir}
+		}
+	}
+
+	prev, ok := client.pending[event.Path]
+	switch {
+	case !ok:
+		client.order = append(client.order, event.Path)
+	case prev.Op == watch.Create && event.Op == watch.Modify:
+		event = prev
+	case prev.Op == watch.Create && event.Op == watch.Delete:
+		delete(client.pending, event.Path)
+		event = watch.Event{}
+	case prev.Op == watch.Delete && event.Op == watch.Create:
+		event.Op = watch.Modify
+	case prev.Op == watch.Rename && event.Op == watch.Modify:
+		event = prev
+	}
+	if event.Op != "" {
+		client.pending[event.Path] = event
+	}
+
+	if client.timer == nil {
+		client.timer = time.AfterFunc(watchDebounce, client.flush)
+	}
+}
+
+// flush sends the pending batch. A client too slow to keep up gets a
+// "reload" message instead, telling it to list its directories again.
+func (client *watchClient) flush() {
+	client.mu.Lock()
+	var events []watch.Event
+	for _, path := range client.order {
+		if event, ok := client.pending[path]; ok {
+			events = append(events, event)
+		}
+	}
+	client.pending = make(map[string]watch.Event)
+	client.order = nil
+	client.timer = nil
+	closed := client.closed
+	client.mu.Unlock()
+
+	if closed || len(events) == 0 {
+		return
+	}
+	msg := watchMessage{Type: "events"}
+	for _, event := range events {
+		msg.Events = append(msg.Events, newWatchEvent(event, client.largeFileSize))
+	}
+	select {
+	case client.out <- msg:
+	default:
+		select {
+		case client.out <- watchMessage{Type: "reload"}:
+		default:
+		}
+	}
+}
+
+func newWatchEvent(event watch.Event, largeFileSize int64) watchEvent {
+	pathStr := strings.TrimPrefix(event.Path, "/")
+	res := watchEvent{
+		Op: event.Op,
+		dirContent: dirContent{
+			Id:       entryID(event.Path),
+			Filename: filepath.Base(event.Path),
+			IsDir:    event.IsDir,
+			Path:     pathStr,
+		},
+	}
+	if event.OldPath != "" {
+		res.OldPath = strings.TrimPrefix(event.OldPath, "/")
+	}
+	if event.Op == watch.Delete {
+		return res
+	}
+
+	info, err := os.Lstat(event.Path)
+	if err != nil {
+		// gone again before the batch was sent
+		res.Op = watch.Delete
+		return res
+	}
+	res.dirContent = newDirContent(event.Path, pathStr, info, largeFileSize)
+	return res
+}
+
+// Watch upgrades to a WebSocket pushing file system changes. The client sends
+// {"type": "subscribe", "path_str": ...} or "unsubscribe" messages and gets
+// batches of events for the directory trees it subscribed to. Browsers cannot
+// set headers on WebSockets, so the access token may come in the token query
+// parameter; without a token the guest workspace is used.
+func (server *Server) Watch(ctx *gin.Context) {
+	accessToken := ctx.Query("token")
+	if fields := strings.Fields(ctx.GetHeader(authorizationHeaderKey)); len(fields) == 2 && strings.ToLower(fields[0]) == authorizationTypeBearer {
+		accessToken = fields[1]
+	}
+	if accessToken != "" {
+		payload, err := server.tokenMaker.VerifyToken(accessToken)
+		if err != nil {
+			ctx.JSON(http.StatusUnauthorized, errorResponse(err))
+			return
+		}
+		ctx.Set(authorizationPayloadKey, payload)
+	}
+
+	ws, err := server.userWorkspace(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, errorResponse(err))
+		return
+	}
+
+	upgrader := getConnectionUpgrader([]string{"localhost", server.config.DomainName}, 1024)
+	connection, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	if err != nil {
+		log.Println("failed to upgrade connection:", err)
+		return
+	}
+	defer connection.Close()
+
+	client := newWatchClient(server.config.LargeFileSize)
+	defer server.watches.leave(client)
+
+	done := make(chan struct{})
+	defer close(done)
+	go writeWatchMessages(connection, client.out, done)
+
+	connection.SetReadLimit(4096)
+	connection.SetReadDeadline(time.Now().Add(2 * watchPingInterval))
+	connection.SetPongHandler(func(string) error {
+		return connection.SetReadDeadline(time.Now().Add(2 * watchPingInterval))
+	})
+
+	for {
+		var req watchRequest
+		if err := connection.ReadJSON(&req); err != nil {
+			return
+		}
+
+		reply := watchMessage{Type: "subscribed", PathStr: req.PathStr}
+		switch req.Type {
+		case "subscribe":
+			var dir string
+			dir, err = ws.resolve(req.PathStr, accessReadOnly)
+			if err == nil {
+				err = server.watches.subscribe(client, dir)
+			}
+			if errors.Is(err, watch.ErrTooManyWatches) {
+				// the part of the tree that fits is watched
+				reply.Error = err.Error()
+				err = nil
+			}
+		case "unsubscribe":
+			var dir string
+			dir, err = ws.resolve(req.PathStr, accessReadOnly)
+			if err == nil {
+				server.watches.unsubscribe(client, dir)
+			}
+			reply.Type = "unsubscribed"
+		default:
+			err = errors.New("Unknown message type.")
+		}
+		if err != nil {
+			reply = watchMessage{Type: "error", PathStr: req.PathStr, Error: err.Error()}
+		}
+
+		select {
+		case client.out <- reply:
+		case <-time.After(watchWriteTimeout):
+			return
+		}
+	}
+}
+
+// writeWatchMessages is the only writer of the connection. It also keeps the
+// connection alive with pings.
+func writeWatchMessages(connection *websocket.Conn, out <-chan watchMessage, done <-chan struct{}) {
+	ticker := time.NewTicker(watchPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg := <-out:
+			connection.SetWriteDeadline(time.Now().Add(watchWriteTimeout))
+			if err := connection.WriteJSON(msg); err != nil {
+				connection.Close()
+				return
+			}
+		case <-ticker.C:
+			connection.SetWriteDeadline(time.Now().Add(watchWriteTimeout))
+			if err := connection.WriteMessage(websocket.PingMessage, nil); err != nil {
+				connection.Close()
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/diantanjung/wecom/token"
+	"github.com/gin-gonic/gin"
+)
+
+// errPathForbidden is returned for every path that resolves outside of the
+// caller's workspace, so clients always get the same 403 body.
+var errPathForbidden = errors.New("path is outside of the user workspace")
+
+// Access levels stored in directory.access. Each level includes the ones
+// below it.
+const (
+	accessReadOnly  = "read-only"
+	accessReadWrite = "read-write"
+	accessOwner     = "owner"
+)
+
+var accessRank = map[string]int{
+	accessReadOnly:  1,
+	accessReadWrite: 2,
+	accessOwner:     3,
+}
+
+// workspaceRoot is a directory the user may access with a given level.
+type workspaceRoot struct {
+	path     string
+	realPath string
+	access   string
+}
+
+// workspace is the part of the host file system a user is allowed to touch:
+// their own home plus the directories shared with them.
+type workspace struct {
+	username string
+	userID   int64
+	root     string
+	realRoot string
+	roots    []workspaceRoot
+}
+
+// payloadUsername returns the unix username of a token payload. Google tokens
+// carry the email, which is also the username created by loginGoogle.
+func payloadUsername(payload *token.Payload) string {
+	if payload.Username != "" {
+		return payload.Username
+	}
+	return payload.Email
+}
+
+// requestUsername returns the username of the authenticated user, or the
+// guest username for routes that are not behind authMiddleware.
+func (server *Server) requestUsername(ctx *gin.Context) string {
+	if payload, ok := ctx.Get(authorizationPayloadKey); ok {
+		return payloadUsername(payload.(*token.Payload))
+	}
+	return server.config.GuestUsername
+}
+
+// userWorkspace returns the workspace of the authenticated user, or the guest
+// workspace for routes that are not behind authMiddleware.
+func (server *Server) userWorkspace(ctx *gin.Context) (*workspace, error) {
+	username := server.requestUsername(ctx)
+	if username == "" || username == "." || username == ".." || strings.ContainsAny(username, "/\x00") {
+		return nil, errPathForbidden
+	}
+
+	root := filepath.Join(server.config.WorkspaceRoot, username)
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, errPathForbidden
+	}
+
+	ws := &workspace{
+		username: username,
+		root:     root,
+		realRoot: realRoot,
+		roots:    []workspaceRoot{{path: root, realPath: realRoot, access: accessOwner}},
+	}
+
+	user, err := server.querier.GetUser(ctx, username)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println("cannot load user for workspace:", err)
+		}
+		return ws, nil
+	}
+	ws.userID = user.UserID
+
+	dirs, err := server.querier.GetUserDirs(ctx, user.UserID)
+	if err != nil {
+		// fail closed: without grants the user keeps access to their home only
+		log.Println("cannot load directory grants:", err)
+		return ws, nil
+	}
+	for _, dir := range dirs {
+		path := filepath.Clean(dir.Name)
+		realPath, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			continue
+		}
+		ws.roots = append(ws.roots, workspaceRoot{path: path, realPath: realPath, access: dir.Access})
+	}
+
+	return ws, nil
+}
+
+// resolve maps a request path such as "home/dian/project/main.go" onto an
+// absolute host path. The path must stay inside a workspace root granting at
+// least the access level need, both lexically and after following symlinks.
+func (ws *workspace) resolve(pathStr string, need string) (string, error) {
+	for _, elem := range strings.Split(filepath.ToSlash(pathStr), "/") {
+		if elem == ".." {
+			return "", errPathForbidden
+		}
+	}
+
+	absPath := filepath.Clean("/" + pathStr)
+	realPath, err := evalExistingSymlinks(absPath)
+	if err != nil {
+		return "", errPathForbidden
+	}
+
+	for _, root := range ws.roots {
+		if accessRank[root.access] < accessRank[need] {
+			continue
+		}
+		if isWithin(root.path, absPath) && isWithin(root.realPath, realPath) {
+			return absPath, nil
+		}
+	}
+
+	return "", errPathForbidden
+}
+
+// rootOf returns the innermost workspace root containing path, which must
+// have been resolved already.
+func (ws *workspace) rootOf(path string) string {
+	best := ""
+	for _, root := range ws.roots {
+		if isWithin(root.path, path) && len(root.path) > len(best) {
+			best = root.path
+		}
+	}
+	return best
+}
+
+// accessOf returns the highest access the user has on path through any of
+// the roots containing it, or "" when none does.
+func (ws *workspace) accessOf(path string) string {
+	best := ""
+	for _, root := range ws.roots {
+		if isWithin(root.path, path) && accessRank[root.access] > accessRank[best] {
+			best = root.access
+		}
+	}
+	return best
+}
+
+// isWithin reports whether path is root itself or one of its descendants.
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// evalExistingSymlinks follows symlinks in the longest existing prefix of
+// path, so paths of files that are about to be created can be checked too.
+func evalExistingSymlinks(path string) (string, error) {
+	realPath, err := filepath.EvalSymlinks(path)
+	if err == nil {
+		return realPath, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	parent := filepath.Dir(path)
+	if parent == path {
+		return "", err
+	}
+	realParent, err := evalExistingSymlinks(parent)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(realParent, filepath.Base(path)), nil
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkspaceResolve(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "dian")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "project"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(base, "other"), 0755))
+	require.NoError(t, os.Symlink(filepath.Join(base, "other"), filepath.Join(root, "escape")))
+
+	ws := &workspace{
+		username: "dian",
+		root:     root,
+		realRoot: root,
+		roots:    []workspaceRoot{{path: root, realPath: root, access: accessOwner}},
+	}
+
+	path, err := ws.resolve(root[1:]+"/project/main.go", accessReadOnly)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(root, "project", "main.go"), path)
+
+	_, err = ws.resolve(root+"/../other", accessReadOnly)
+	require.ErrorIs(t, err, errPathForbidden)
+
+	_, err = ws.resolve(base+"/other", accessReadOnly)
+	require.ErrorIs(t, err, errPathForbidden)
+
+	_, err = ws.resolve(root+"/escape/secret", accessReadOnly)
+	require.ErrorIs(t, err, errPathForbidden)
+}
+
+func TestWorkspaceAccessOf(t *testing.T) {
+	ws := &workspace{
+		roots: []workspaceRoot{
+			{path: "/home/dian", access: accessOwner},
+			{path: "/home/ana/shared", access: accessReadWrite},
+			{path: "/home/ana/shared/docs", access: accessReadOnly},
+		},
+	}
+	require.Equal(t, accessOwner, ws.accessOf("/home/dian/project"))
+	require.Equal(t, accessReadWrite, ws.accessOf("/home/ana/shared/app"))
+	// a nested read-only grant does not lower the access of the outer one
+	require.Equal(t, accessReadWrite, ws.accessOf("/home/ana/shared/docs"))
+	require.Equal(t, "", ws.accessOf("/home/ana"))
+}
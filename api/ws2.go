@@ -67,7 +67,7 @@ func (server *Server) WebSocket2(ctx *gin.Context) {
 	upgrader := getConnectionUpgrader(allowedHostnames, maxBufferSizeBytes)
 	connection, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
 	if err != nil {
-		fmt.Println("failed to upgrade connection: %s", err)
+		fmt.Printf("failed to upgrade connection: %s\n", err)
 		return
 	}
 
@@ -75,7 +75,7 @@ func (server *Server) WebSocket2(ctx *gin.Context) {
 
 	terminal := opts.Command
 	args := opts.Arguments
-	fmt.Println("starting new tty using command '%s' with arguments ['%s']...", terminal, strings.Join(args, "', '"))
+	fmt.Printf("starting new tty using command '%s' with arguments ['%s']...\n", terminal, strings.Join(args, "', '"))
 	// cmd := exec.Command(terminal, args...)
 	cmd := exec.Command("schroot", "-c", "xenial", "-u", username)
 	// cmd := exec.Command("/usr/bin/zsh", "-c","schroot -c xenial -u " + username)
@@ -95,16 +95,16 @@ func (server *Server) WebSocket2(ctx *gin.Context) {
 	defer func() {
 		fmt.Println("gracefully stopping spawned tty...")
 		if err := cmd.Process.Kill(); err != nil {
-			fmt.Println("failed to kill process: %s", err)
+			fmt.Printf("failed to kill process: %s\n", err)
 		}
 		if _, err := cmd.Process.Wait(); err != nil {
-			fmt.Println("failed to wait for process to exit: %s", err)
+			fmt.Printf("failed to wait for process to exit: %s\n", err)
 		}
 		if err := tty.Close(); err != nil {
-			fmt.Println("failed to close spawned tty gracefully: %s", err)
+			fmt.Printf("failed to close spawned tty gracefully: %s\n", err)
 		}
 		if err := connection.Close(); err != nil {
-			fmt.Println("failed to close webscoket connection: %s", err)
+			fmt.Printf("failed to close webscoket connection: %s\n", err)
 		}
 	}()
 
@@ -156,19 +156,19 @@ func (server *Server) WebSocket2(ctx *gin.Context) {
 			buffer := make([]byte, maxBufferSizeBytes)
 			readLength, err := tty.Read(buffer)
 			if err != nil {
-				fmt.Println("failed to read from tty: %s", err)
+				fmt.Printf("failed to read from tty: %s\n", err)
 				if err := connection.WriteMessage(websocket.TextMessage, []byte("bye!\r\n")); err != nil {
-					fmt.Println("failed to send termination message from tty to xterm.js: %s", err)
+					fmt.Printf("failed to send termination message from tty to xterm.js: %s\n", err)
 				}
 				waiter.Done()
 				return
 			}
 			if err := connection.WriteMessage(websocket.BinaryMessage, buffer[:readLength]); err != nil {
-				fmt.Println("failed to send %v bytes from tty to xterm.js", readLength)
+				fmt.Printf("failed to send %v bytes from tty to xterm.js\n", readLength)
 				errorCounter++
 				continue
 			}
-			fmt.Println("sent message of size %v bytes from tty to xterm.js", readLength)
+			fmt.Printf("sent message of size %v bytes from tty to xterm.js\n", readLength)
 			errorCounter = 0
 		}
 	}()
@@ -180,7 +180,7 @@ func (server *Server) WebSocket2(ctx *gin.Context) {
 			messageType, data, err := connection.ReadMessage()
 			if err != nil {
 				if !connectionClosed {
-					fmt.Println("failed to get next reader: %s", err)
+					fmt.Printf("failed to get next reader: %s\n", err)
 				}
 				return
 			}
@@ -190,7 +190,7 @@ func (server *Server) WebSocket2(ctx *gin.Context) {
 			if !ok {
 				dataType = "unknown"
 			}
-			fmt.Println("received %s (type: %v) message of size %v byte(s) from xterm.js with key sequence: %v", dataType, messageType, dataLength, dataBuffer)
+			fmt.Printf("received %s (type: %v) message of size %v byte(s) from xterm.js with key sequence: %v\n", dataType, messageType, dataLength, dataBuffer)
 
 			// process
 			if dataLength == -1 { // invalid
@@ -204,15 +204,15 @@ func (server *Server) WebSocket2(ctx *gin.Context) {
 					ttySize := &TTYSize{}
 					resizeMessage := bytes.Trim(dataBuffer[1:], " \n\r\t\x00\x01")
 					if err := json.Unmarshal(resizeMessage, ttySize); err != nil {
-						fmt.Println("failed to unmarshal received resize message '%s': %s", string(resizeMessage), err)
+						fmt.Printf("failed to unmarshal received resize message '%s': %s\n", string(resizeMessage), err)
 						continue
 					}
-					fmt.Println("resizing tty to use %v rows and %v columns...", ttySize.Rows, ttySize.Cols)
+					fmt.Printf("resizing tty to use %v rows and %v columns...\n", ttySize.Rows, ttySize.Cols)
 					if err := pty.Setsize(tty, &pty.Winsize{
 						Rows: ttySize.Rows,
 						Cols: ttySize.Cols,
 					}); err != nil {
-						fmt.Println("failed to resize tty, error: %s", err)
+						fmt.Printf("failed to resize tty, error: %s\n", err)
 					}
 					continue
 				}
@@ -224,7 +224,7 @@ func (server *Server) WebSocket2(ctx *gin.Context) {
 				fmt.Println(fmt.Sprintf("failed to write %v bytes to tty: %s", len(dataBuffer), err))
 				continue
 			}
-			fmt.Println("%v bytes written to tty...", bytesWritten)
+			fmt.Printf("%v bytes written to tty...\n", bytesWritten)
 		}
 	}()
 
@@ -248,7 +248,7 @@ func getConnectionUpgrader(
 					return true
 				}
 			}
-			fmt.Println("failed to find '%s' in the list of allowed hostnames ('%s')", requesterHostname)
+			fmt.Printf("failed to find '%s' in the list of allowed hostnames ('%s')\n", requesterHostname, strings.Join(allowedHostnames, "', '"))
 			return false
 		},
 		HandshakeTimeout: 0,
@@ -117,7 +117,7 @@ func (server *Server) WsDebug(ctx *gin.Context) {
 	upgrader := getConnectionUpgrader(allowedHostnames, maxBufferSizeBytes)
 	ws, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
 	if err != nil {
-		log.Println("failed to upgrade connection: %s", err)
+		log.Printf("failed to upgrade connection: %s", err)
 		return
 	}
 
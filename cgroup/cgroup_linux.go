@@ -0,0 +1,235 @@
+//go:build linux
+
+package cgroup
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// cpuPeriod is the cpu.max period in microseconds.
+const cpuPeriod = 100000
+
+// controllers are enabled for the groups below the manager's root.
+var controllers = []string{"cpu", "memory", "pids", "io"}
+
+// Manager creates groups below one parent group.
+type Manager struct {
+	root string
+	// "major:minor" of the disk IO limits apply to
+	ioDevice string
+}
+
+// New sets up root as the parent of the groups created by the manager,
+// creating it if needed. root must be in a cgroup v2 hierarchy the server
+// may write to, e.g. /sys/fs/cgroup/wecom. ioDevice is the disk IO limits
+// apply to as "major:minor"; see DeviceOf.
+func New(root, ioDevice string) (*Manager, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	// controllers must be enabled on every level down to the groups
+	for _, dir := range []string{filepath.Dir(root), root} {
+		for _, controller := range controllers {
+			err := os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte("+"+controller), 0644)
+			if err != nil {
+				return nil, fmt.Errorf("cannot enable the %s controller in %s: %w", controller, dir, err)
+			}
+		}
+	}
+	return &Manager{root: root, ioDevice: ioDevice}, nil
+}
+
+// DeviceOf returns the disk holding path as "major:minor". A partition is
+// resolved to the disk it is on, io.max only takes whole disks.
+func DeviceOf(path string) (string, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		return "", &os.PathError{Op: "stat", Path: path, Err: err}
+	}
+	dev := uint64(stat.Dev)
+	major := (dev>>8)&0xfff | (dev>>32)&^0xfff
+	minor := dev&0xff | (dev>>12)&^0xff
+	return diskOf("/sys/dev/block", fmt.Sprintf("%d:%d", major, minor))
+}
+
+// diskOf returns the disk of the block device dev, looked up in sysBlock
+// where each device links to its directory below the one of its disk.
+func diskOf(sysBlock, dev string) (string, error) {
+	dir, err := filepath.EvalSymlinks(filepath.Join(sysBlock, dev))
+	if err != nil {
+		return "", fmt.Errorf("%s is not a block device: %w", dev, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "partition")); errors.Is(err, os.ErrNotExist) {
+		return dev, nil
+	} else if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(dir), "dev"))
+	if err != nil {
+		return "", fmt.Errorf("cannot find the disk of partition %s: %w", dev, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Group is a cgroup processes can be started in.
+type Group struct {
+	path string
+	dir  *os.File
+}
+
+// Create creates the group name with the given limits.
+func (m *Manager) Create(name string, limits Limits) (*Group, error) {
+	path := filepath.Join(m.root, name)
+	if err := os.Mkdir(path, 0755); err != nil {
+		return nil, err
+	}
+	g := &Group{path: path}
+	if err := g.setLimits(limits, m.ioDevice); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	dir, err := os.Open(path)
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	g.dir = dir
+	return g, nil
+}
+
+func (g *Group) write(file, value string) error {
+	return os.WriteFile(filepath.Join(g.path, file), []byte(value), 0644)
+}
+
+func (g *Group) setLimits(limits Limits, ioDevice string) error {
+	if limits.CPUs > 0 {
+		quota := int64(limits.CPUs * cpuPeriod)
+		if err := g.write("cpu.max", fmt.Sprintf("%d %d", quota, cpuPeriod)); err != nil {
+			return err
+		}
+	}
+	if limits.MemoryBytes > 0 {
+		if err := g.write("memory.max", strconv.FormatInt(limits.MemoryBytes, 10)); err != nil {
+			return err
+		}
+		// without swap accounting there is no swap to limit
+		if err := g.write("memory.swap.max", "0"); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if limits.Pids > 0 {
+		if err := g.write("pids.max", strconv.FormatInt(limits.Pids, 10)); err != nil {
+			return err
+		}
+	}
+	if limits.IOReadBps > 0 || limits.IOWriteBps > 0 {
+		if ioDevice == "" {
+			return errors.New("no disk to apply the io limits to")
+		}
+		if err := g.write("io.max", fmt.Sprintf("%s rbps=%s wbps=%s", ioDevice, ioMax(limits.IOReadBps), ioMax(limits.IOWriteBps))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ioMax(bps int64) string {
+	if bps <= 0 {
+		return "max"
+	}
+	return strconv.FormatInt(bps, 10)
+}
+
+// Attach makes cmd start in the group. It must be called before cmd is
+// started, after anything else setting cmd.SysProcAttr.
+func (g *Group) Attach(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(g.dir.Fd())
+}
+
+// Events counts the limits the group ran into.
+type Events struct {
+	// processes killed for running out of memory
+	OOMKills int64
+	// forks refused for reaching the process limit
+	PidsMax int64
+}
+
+// Events returns the limits the processes of the group ran into so far.
+func (g *Group) Events() (Events, error) {
+	var events Events
+	memory, err := readKeyedFile(filepath.Join(g.path, "memory.events"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return events, err
+	}
+	pids, err := readKeyedFile(filepath.Join(g.path, "pids.events"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return events, err
+	}
+	events.OOMKills = memory["oom_kill"]
+	events.PidsMax = pids["max"]
+	return events, nil
+}
+
+// readKeyedFile reads a cgroup file of "key value" lines.
+func readKeyedFile(path string) (map[string]int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	values := make(map[string]int64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if value, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			values[fields[0]] = value
+		}
+	}
+	return values, scanner.Err()
+}
+
+// Close kills the processes left in the group and removes it.
+func (g *Group) Close() error {
+	defer g.dir.Close()
+	if err := g.write("cgroup.kill", "1"); err != nil {
+		// kernels before 5.14 have no cgroup.kill
+		g.killProcs()
+	}
+	// the group can only be removed once its processes are gone
+	var err error
+	for i := 0; i < 50; i++ {
+		if err = os.Remove(g.path); !errors.Is(err, syscall.EBUSY) {
+			return err
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return err
+}
+
+func (g *Group) killProcs() {
+	data, err := os.ReadFile(filepath.Join(g.path, "cgroup.procs"))
+	if err != nil {
+		return
+	}
+	for _, field := range strings.Fields(string(data)) {
+		if pid, err := strconv.Atoi(field); err == nil {
+			syscall.Kill(pid, syscall.SIGKILL)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+//go:build linux
+
+package cgroup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func readFile(t *testing.T, path string) string {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(data)
+}
+
+// The cgroup files are plain files in a temporary directory here; writing
+// them works the same as in a cgroup hierarchy.
+func TestCreate(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "wecom")
+	m, err := New(root, "8:0")
+	require.NoError(t, err)
+
+	g, err := m.Create("run", Limits{CPUs: 1.5, MemoryBytes: 1 << 20, Pids: 32, IOWriteBps: 4096})
+	require.NoError(t, err)
+	defer g.dir.Close()
+	require.Equal(t, "150000 100000", readFile(t, filepath.Join(root, "run", "cpu.max")))
+	require.Equal(t, "1048576", readFile(t, filepath.Join(root, "run", "memory.max")))
+	require.Equal(t, "32", readFile(t, filepath.Join(root, "run", "pids.max")))
+	require.Equal(t, "8:0 rbps=max wbps=4096", readFile(t, filepath.Join(root, "run", "io.max")))
+
+	events, err := g.Events()
+	require.NoError(t, err)
+	require.Equal(t, Events{}, events)
+
+	memoryEvents := "low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\noom_group_kill 0\n"
+	require.NoError(t, os.WriteFile(filepath.Join(root, "run", "memory.events"), []byte(memoryEvents), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "run", "pids.events"), []byte("max 7\n"), 0644))
+	events, err = g.Events()
+	require.NoError(t, err)
+	require.Equal(t, Events{OOMKills: 1, PidsMax: 7}, events)
+
+	// without a disk io limits cannot be applied
+	m.ioDevice = ""
+	_, err = m.Create("io", Limits{IOReadBps: 1})
+	require.Error(t, err)
+	require.NoDirExists(t, filepath.Join(root, "io"))
+}
+
+func TestDeviceOf(t *testing.T) {
+	device, err := DeviceOf(t.TempDir())
+	require.NoError(t, err)
+	require.Regexp(t, `^\d+:\d+$`, device)
+}
+
+func TestDiskOf(t *testing.T) {
+	sys := t.TempDir()
+	sda := filepath.Join(sys, "devices", "sda")
+	require.NoError(t, os.MkdirAll(filepath.Join(sda, "sda1"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(sda, "dev"), []byte("8:0\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(sda, "sda1", "dev"), []byte("8:1\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(sda, "sda1", "partition"), []byte("1\n"), 0644))
+	block := filepath.Join(sys, "dev", "block")
+	require.NoError(t, os.MkdirAll(block, 0755))
+	require.NoError(t, os.Symlink("../../devices/sda", filepath.Join(block, "8:0")))
+	require.NoError(t, os.Symlink("../../devices/sda/sda1", filepath.Join(block, "8:1")))
+
+	tests := []struct {
+		dev  string
+		disk string
+	}{
+		{"8:0", "8:0"},
+		{"8:1", "8:0"},
+	}
+	for _, tc := range tests {
+		disk, err := diskOf(block, tc.dev)
+		require.NoError(t, err)
+		require.Equal(t, tc.disk, disk, tc.dev)
+	}
+
+	// filesystems such as tmpfs are on no disk at all
+	_, err := diskOf(block, "0:42")
+	require.Error(t, err)
+}
@@ -0,0 +1,51 @@
+//go:build !linux
+
+package cgroup
+
+import (
+	"errors"
+	"os/exec"
+)
+
+var errUnsupported = errors.New("cgroups are only supported on linux")
+
+// Manager creates groups below one parent group.
+type Manager struct{}
+
+// New sets up root as the parent of the groups created by the manager. It
+// always fails outside of Linux.
+func New(root, ioDevice string) (*Manager, error) {
+	return nil, errUnsupported
+}
+
+// DeviceOf returns the disk holding path as "major:minor".
+func DeviceOf(path string) (string, error) {
+	return "", errUnsupported
+}
+
+// Group is a cgroup processes can be started in.
+type Group struct{}
+
+// Create creates the group name with the given limits.
+func (m *Manager) Create(name string, limits Limits) (*Group, error) {
+	return nil, errUnsupported
+}
+
+// Attach makes cmd start in the group.
+func (g *Group) Attach(cmd *exec.Cmd) {}
+
+// Events counts the limits the group ran into.
+type Events struct {
+	OOMKills int64
+	PidsMax  int64
+}
+
+// Events returns the limits the processes of the group ran into so far.
+func (g *Group) Events() (Events, error) {
+	return Events{}, nil
+}
+
+// Close kills the processes left in the group and removes it.
+func (g *Group) Close() error {
+	return nil
+}
@@ -0,0 +1,96 @@
+// Package cgroup confines processes to cgroup v2 groups limiting the CPU,
+// memory, processes and disk IO they may use. Groups are only supported on
+// Linux.
+package cgroup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Limits are the resources a group may use. Zero means unlimited.
+type Limits struct {
+	// share of CPU time, 1.5 being one and a half cores
+	CPUs        float64 `json:"cpus,omitempty"`
+	MemoryBytes int64   `json:"memory_bytes,omitempty"`
+	// processes and threads
+	Pids int64 `json:"pids,omitempty"`
+	// bytes per second read from and written to the disk of the workspaces
+	IOReadBps  int64 `json:"io_read_bps,omitempty"`
+	IOWriteBps int64 `json:"io_write_bps,omitempty"`
+}
+
+// Override returns l with the limits set in o replacing its own.
+func (l Limits) Override(o Limits) Limits {
+	if o.CPUs != 0 {
+		l.CPUs = o.CPUs
+	}
+	if o.MemoryBytes != 0 {
+		l.MemoryBytes = o.MemoryBytes
+	}
+	if o.Pids != 0 {
+		l.Pids = o.Pids
+	}
+	if o.IOReadBps != 0 {
+		l.IOReadBps = o.IOReadBps
+	}
+	if o.IOWriteBps != 0 {
+		l.IOWriteBps = o.IOWriteBps
+	}
+	return l
+}
+
+// UserLimits are the limits of one user: those of a plan, with the limits
+// set here replacing the plan's.
+type UserLimits struct {
+	Plan string `json:"plan,omitempty"`
+	Limits
+}
+
+// Plans picks the limits of each user. Users without an entry get the
+// "default" plan, or the default limits when there is no such plan.
+type Plans struct {
+	Default Limits                `json:"-"`
+	Plans   map[string]Limits     `json:"plans"`
+	Users   map[string]UserLimits `json:"users"`
+}
+
+// LoadPlans reads plans from a JSON file such as
+//
+//	{
+//	  "plans": {"default": {"memory_bytes": 536870912}, "pro": {"cpus": 2}},
+//	  "users": {"alice": {"plan": "pro", "pids": 512}}
+//	}
+//
+// Limits a plan leaves unset are those of the default plan, which in turn
+// falls back to def.
+func LoadPlans(path string, def Limits) (*Plans, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	plans := &Plans{Default: def}
+	if err := json.Unmarshal(data, plans); err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %w", path, err)
+	}
+	for username, user := range plans.Users {
+		if _, ok := plans.Plans[user.Plan]; user.Plan != "" && !ok {
+			return nil, fmt.Errorf("user %s of %s has unknown plan %q", username, path, user.Plan)
+		}
+	}
+	return plans, nil
+}
+
+// For returns the limits of username.
+func (p *Plans) For(username string) Limits {
+	limits := p.Default.Override(p.Plans["default"])
+	user, ok := p.Users[username]
+	if !ok {
+		return limits
+	}
+	if user.Plan != "" {
+		limits = limits.Override(p.Plans[user.Plan])
+	}
+	return limits.Override(user.Limits)
+}
@@ -0,0 +1,28 @@
+package cgroup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadPlans(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limits.json")
+	config := `{
+		"plans": {"default": {"memory_bytes": 1024}, "pro": {"cpus": 2, "memory_bytes": 4096}},
+		"users": {"alice": {"plan": "pro", "pids": 512}, "bob": {"io_read_bps": 100}}
+	}`
+	require.NoError(t, os.WriteFile(path, []byte(config), 0644))
+
+	plans, err := LoadPlans(path, Limits{CPUs: 1, MemoryBytes: 512, Pids: 64})
+	require.NoError(t, err)
+	require.Equal(t, Limits{CPUs: 1, MemoryBytes: 1024, Pids: 64}, plans.For("carol"))
+	require.Equal(t, Limits{CPUs: 2, MemoryBytes: 4096, Pids: 512}, plans.For("alice"))
+	require.Equal(t, Limits{CPUs: 1, MemoryBytes: 1024, Pids: 64, IOReadBps: 100}, plans.For("bob"))
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"users": {"alice": {"plan": "gold"}}}`), 0644))
+	_, err = LoadPlans(path, Limits{})
+	require.Error(t, err)
+}
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS directory_name_idx;
+ALTER TABLE "directory" DROP COLUMN IF EXISTS "access";
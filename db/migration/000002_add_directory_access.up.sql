@@ -0,0 +1,3 @@
+ALTER TABLE "directory" ADD COLUMN "access" varchar NOT NULL DEFAULT 'owner';
+
+CREATE INDEX ON "directory" ("name");
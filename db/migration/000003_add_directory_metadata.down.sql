@@ -0,0 +1,2 @@
+ALTER TABLE "directory" DROP COLUMN IF EXISTS "description";
+ALTER TABLE "directory" DROP COLUMN IF EXISTS "language";
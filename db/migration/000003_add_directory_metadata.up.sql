@@ -0,0 +1,2 @@
+ALTER TABLE "directory" ADD COLUMN "language" varchar NOT NULL DEFAULT '';
+ALTER TABLE "directory" ADD COLUMN "description" varchar NOT NULL DEFAULT '';
@@ -0,0 +1,10 @@
+CREATE TABLE "file_revision" (
+                                 "revision_id" bigserial PRIMARY KEY,
+                                 "path" varchar NOT NULL,
+                                 "user_id" bigint NOT NULL,
+                                 "hash" varchar NOT NULL,
+                                 "size" bigint NOT NULL,
+                                 "created_at" timestamp NOT NULL DEFAULT (now())
+);
+
+CREATE INDEX ON "file_revision" ("path");
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS file_operation_change;
+DROP TABLE IF EXISTS file_operation;
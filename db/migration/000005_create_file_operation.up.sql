@@ -0,0 +1,21 @@
+CREATE TABLE "file_operation" (
+                                  "operation_id" bigserial PRIMARY KEY,
+                                  "user_id" bigint NOT NULL,
+                                  "kind" varchar NOT NULL,
+                                  "undone" boolean NOT NULL DEFAULT false,
+                                  "created_at" timestamp NOT NULL DEFAULT (now())
+);
+
+CREATE TABLE "file_operation_change" (
+                                         "change_id" bigserial PRIMARY KEY,
+                                         "operation_id" bigint NOT NULL,
+                                         "path" varchar NOT NULL,
+                                         "before_hash" varchar NOT NULL,
+                                         "after_hash" varchar NOT NULL
+);
+
+CREATE INDEX ON "file_operation" ("user_id");
+
+CREATE INDEX ON "file_operation_change" ("operation_id");
+
+ALTER TABLE "file_operation_change" ADD FOREIGN KEY ("operation_id") REFERENCES "file_operation" ("operation_id") ON DELETE CASCADE;
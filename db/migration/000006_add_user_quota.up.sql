@@ -0,0 +1 @@
+ALTER TABLE "users" ADD COLUMN "quota_bytes" bigint NOT NULL DEFAULT 0;
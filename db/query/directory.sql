@@ -1,9 +1,12 @@
 -- name: CreateUserDir :one
 INSERT INTO directory (
   name,
-  user_id
+  user_id,
+  access,
+  language,
+  description
 ) VALUES (
-  $1, $2
+  $1, $2, $3, $4, $5
 ) RETURNING *;
 
 -- name: GetUserDirs :many
@@ -11,11 +14,42 @@ SELECT * FROM directory
 WHERE user_id = $1
 ORDER BY dir_id;
 
+-- name: GetUserDir :one
+SELECT * FROM directory
+WHERE dir_id = $1 AND user_id = $2
+LIMIT 1;
+
 -- name: CheckUserDir :one
 SELECT * FROM directory
 WHERE user_id = $1 AND name = $2
 LIMIT 1;
 
+-- name: GetDirGrants :many
+SELECT * FROM directory
+WHERE name = $1
+ORDER BY dir_id;
+
+-- name: UpdateUserDirAccess :one
+UPDATE directory
+SET access = $3
+WHERE user_id = $1 AND name = $2
+RETURNING *;
+
+-- name: UpdateDirMetadata :exec
+UPDATE directory
+SET language = $2, description = $3
+WHERE name = $1;
+
+-- name: RenameDirs :exec
+UPDATE directory
+SET name = sqlc.arg(new_name)::varchar || substr(name, length(sqlc.arg(old_name)::varchar) + 1)
+WHERE name = sqlc.arg(old_name)::varchar
+  OR left(name, length(sqlc.arg(old_name)::varchar) + 1) = sqlc.arg(old_name)::varchar || '/';
+
 -- name: DeleteUserDir :exec
 DELETE FROM directory
 WHERE user_id = $1 AND name = $2;
+
+-- name: DeleteDirs :exec
+DELETE FROM directory
+WHERE name = $1 OR left(name, length($1) + 1) = $1 || '/';
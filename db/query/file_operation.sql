@@ -0,0 +1,41 @@
+-- name: CreateFileOperation :one
+INSERT INTO file_operation (
+  user_id,
+  kind
+) VALUES (
+  $1, $2
+) RETURNING *;
+
+-- name: CreateFileOperationChange :one
+INSERT INTO file_operation_change (
+  operation_id,
+  path,
+  before_hash,
+  after_hash
+) VALUES (
+  $1, $2, $3, $4
+) RETURNING *;
+
+-- name: GetFileOperation :one
+SELECT * FROM file_operation
+WHERE operation_id = $1 LIMIT 1;
+
+-- name: ListFileOperationChanges :many
+SELECT * FROM file_operation_change
+WHERE operation_id = $1
+ORDER BY change_id;
+
+-- name: ListFileOperations :many
+SELECT * FROM file_operation
+WHERE user_id = $1
+ORDER BY operation_id DESC
+LIMIT $2;
+
+-- name: SetFileOperationUndone :exec
+UPDATE file_operation
+SET undone = $2
+WHERE operation_id = $1;
+
+-- name: DeleteFileOperation :exec
+DELETE FROM file_operation
+WHERE operation_id = $1;
@@ -0,0 +1,25 @@
+-- name: CreateFileRevision :one
+INSERT INTO file_revision (
+  path,
+  user_id,
+  hash,
+  size
+) VALUES (
+  $1, $2, $3, $4
+) RETURNING *;
+
+-- name: GetFileRevision :one
+SELECT * FROM file_revision
+WHERE revision_id = $1 LIMIT 1;
+
+-- name: GetLatestFileRevision :one
+SELECT * FROM file_revision
+WHERE path = $1
+ORDER BY revision_id DESC
+LIMIT 1;
+
+-- name: ListFileRevisions :many
+SELECT * FROM file_revision
+WHERE path = $1
+ORDER BY revision_id DESC
+LIMIT $2;
@@ -15,3 +15,13 @@ WHERE username = $1 LIMIT 1;
 -- name: GetUserByEmail :one
 SELECT * FROM users
 WHERE email = $1 LIMIT 1;
+
+-- name: ListUsers :many
+SELECT * FROM users
+ORDER BY username;
+
+-- name: UpdateUserQuota :one
+UPDATE users
+SET quota_bytes = $2
+WHERE username = $1
+RETURNING *;
@@ -8,7 +8,7 @@ import (
 )
 
 const checkUserDir = `-- name: CheckUserDir :one
-SELECT dir_id, name, user_id, created_at FROM directory
+SELECT dir_id, name, user_id, created_at, access, language, description FROM directory
 WHERE user_id = $1 AND name = $2
 LIMIT 1
 `
@@ -26,6 +26,9 @@ func (q *Queries) CheckUserDir(ctx context.Context, arg CheckUserDirParams) (Dir
 		&i.Name,
 		&i.UserID,
 		&i.CreatedAt,
+		&i.Access,
+		&i.Language,
+		&i.Description,
 	)
 	return i, err
 }
@@ -33,29 +36,54 @@ func (q *Queries) CheckUserDir(ctx context.Context, arg CheckUserDirParams) (Dir
 const createUserDir = `-- name: CreateUserDir :one
 INSERT INTO directory (
   name,
-  user_id
+  user_id,
+  access,
+  language,
+  description
 ) VALUES (
-  $1, $2
-) RETURNING dir_id, name, user_id, created_at
+  $1, $2, $3, $4, $5
+) RETURNING dir_id, name, user_id, created_at, access, language, description
 `
 
 type CreateUserDirParams struct {
-	Name   string `json:"name"`
-	UserID int64  `json:"user_id"`
+	Name        string `json:"name"`
+	UserID      int64  `json:"user_id"`
+	Access      string `json:"access"`
+	Language    string `json:"language"`
+	Description string `json:"description"`
 }
 
 func (q *Queries) CreateUserDir(ctx context.Context, arg CreateUserDirParams) (Directory, error) {
-	row := q.db.QueryRowContext(ctx, createUserDir, arg.Name, arg.UserID)
+	row := q.db.QueryRowContext(ctx, createUserDir,
+		arg.Name,
+		arg.UserID,
+		arg.Access,
+		arg.Language,
+		arg.Description,
+	)
 	var i Directory
 	err := row.Scan(
 		&i.DirID,
 		&i.Name,
 		&i.UserID,
 		&i.CreatedAt,
+		&i.Access,
+		&i.Language,
+		&i.Description,
 	)
 	return i, err
 }
 
+const deleteDirs = `-- name: DeleteDirs :exec
+DELETE FROM directory
+WHERE name = $1 OR left(name, length($1) + 1) = $1 || '/'
+`
+
+func (q *Queries) DeleteDirs(ctx context.Context, name string) error {
+	_, err := q.db.ExecContext(ctx, deleteDirs, name)
+	return err
+}
+
 const deleteUserDir = `-- name: DeleteUserDir :exec
 DELETE FROM directory
 WHERE user_id = $1 AND name = $2
@@ -71,8 +99,71 @@ func (q *Queries) DeleteUserDir(ctx context.Context, arg DeleteUserDirParams) er
 	return err
 }
 
+const getDirGrants = `-- name: GetDirGrants :many
+SELECT dir_id, name, user_id, created_at, access, language, description FROM directory
+WHERE name = $1
+ORDER BY dir_id
+`
+
+func (q *Queries) GetDirGrants(ctx context.Context, name string) ([]Directory, error) {
+	rows, err := q.db.QueryContext(ctx, getDirGrants, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Directory{}
+	for rows.Next() {
+		var i Directory
+		if err := rows.Scan(
+			&i.DirID,
+			&i.Name,
+			&i.UserID,
+			&i.CreatedAt,
+			&i.Access,
+			&i.Language,
+			&i.Description,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUserDir = `-- name: GetUserDir :one
+SELECT dir_id, name, user_id, created_at, access, language, description FROM directory
+WHERE dir_id = $1 AND user_id = $2
+LIMIT 1
+`
+
+type GetUserDirParams struct {
+	DirID  int64 `json:"dir_id"`
+	UserID int64 `json:"user_id"`
+}
+
+func (q *Queries) GetUserDir(ctx context.Context, arg GetUserDirParams) (Directory, error) {
+	row := q.db.QueryRowContext(ctx, getUserDir, arg.DirID, arg.UserID)
+	var i Directory
+	err := row.Scan(
+		&i.DirID,
+		&i.Name,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.Access,
+		&i.Language,
+		&i.Description,
+	)
+	return i, err
+}
+
 const getUserDirs = `-- name: GetUserDirs :many
-SELECT dir_id, name, user_id, created_at FROM directory
+SELECT dir_id, name, user_id, created_at, access, language, description FROM directory
 WHERE user_id = $1
 ORDER BY dir_id
 `
@@ -91,6 +182,9 @@ func (q *Queries) GetUserDirs(ctx context.Context, userID int64) ([]Directory, e
 			&i.Name,
 			&i.UserID,
 			&i.CreatedAt,
+			&i.Access,
+			&i.Language,
+			&i.Description,
 		); err != nil {
 			return nil, err
 		}
@@ -104,3 +198,65 @@ func (q *Queries) GetUserDirs(ctx context.Context, userID int64) ([]Directory, e
 	}
 	return items, nil
 }
+
+const renameDirs = `-- name: RenameDirs :exec
+UPDATE directory
+SET name = $1::varchar || substr(name, length($2::varchar) + 1)
+WHERE name = $2::varchar
+  OR left(name, length($2::varchar) + 1) = $2::varchar || '/'
+`
+
+type RenameDirsParams struct {
+	NewName string `json:"new_name"`
+	OldName string `json:"old_name"`
+}
+
+func (q *Queries) RenameDirs(ctx context.Context, arg RenameDirsParams) error {
+	_, err := q.db.ExecContext(ctx, renameDirs, arg.NewName, arg.OldName)
+	return err
+}
+
+const updateDirMetadata = `-- name: UpdateDirMetadata :exec
+UPDATE directory
+SET language = $2, description = $3
+WHERE name = $1
+`
+
+type UpdateDirMetadataParams struct {
+	Name        string `json:"name"`
+	Language    string `json:"language"`
+	Description string `json:"description"`
+}
+
+func (q *Queries) UpdateDirMetadata(ctx context.Context, arg UpdateDirMetadataParams) error {
+	_, err := q.db.ExecContext(ctx, updateDirMetadata, arg.Name, arg.Language, arg.Description)
+	return err
+}
+
+const updateUserDirAccess = `-- name: UpdateUserDirAccess :one
+UPDATE directory
+SET access = $3
+WHERE user_id = $1 AND name = $2
+RETURNING dir_id, name, user_id, created_at, access, language, description
+`
+
+type UpdateUserDirAccessParams struct {
+	UserID int64  `json:"user_id"`
+	Name   string `json:"name"`
+	Access string `json:"access"`
+}
+
+func (q *Queries) UpdateUserDirAccess(ctx context.Context, arg UpdateUserDirAccessParams) (Directory, error) {
+	row := q.db.QueryRowContext(ctx, updateUserDirAccess, arg.UserID, arg.Name, arg.Access)
+	var i Directory
+	err := row.Scan(
+		&i.DirID,
+		&i.Name,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.Access,
+		&i.Language,
+		&i.Description,
+	)
+	return i, err
+}
@@ -7,17 +7,46 @@ import (
 )
 
 type Directory struct {
-	DirID     int64     `json:"dir_id"`
-	Name      string    `json:"name"`
-	UserID    int64     `json:"user_id"`
-	CreatedAt time.Time `json:"created_at"`
+	DirID       int64     `json:"dir_id"`
+	Name        string    `json:"name"`
+	UserID      int64     `json:"user_id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Access      string    `json:"access"`
+	Language    string    `json:"language"`
+	Description string    `json:"description"`
+}
+
+type FileOperation struct {
+	OperationID int64     `json:"operation_id"`
+	UserID      int64     `json:"user_id"`
+	Kind        string    `json:"kind"`
+	Undone      bool      `json:"undone"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type FileOperationChange struct {
+	ChangeID    int64  `json:"change_id"`
+	OperationID int64  `json:"operation_id"`
+	Path        string `json:"path"`
+	BeforeHash  string `json:"before_hash"`
+	AfterHash   string `json:"after_hash"`
+}
+
+type FileRevision struct {
+	RevisionID int64     `json:"revision_id"`
+	Path       string    `json:"path"`
+	UserID     int64     `json:"user_id"`
+	Hash       string    `json:"hash"`
+	Size       int64     `json:"size"`
+	CreatedAt  time.Time `json:"created_at"`
 }
 
 type User struct {
-	UserID    int64     `json:"user_id"`
-	Name      string    `json:"name"`
-	Username  string    `json:"username"`
-	Email     string    `json:"email"`
-	Password  string    `json:"password"`
-	CreatedAt time.Time `json:"created_at"`
+	UserID     int64     `json:"user_id"`
+	Name       string    `json:"name"`
+	Username   string    `json:"username"`
+	Email      string    `json:"email"`
+	Password   string    `json:"password"`
+	CreatedAt  time.Time `json:"created_at"`
+	QuotaBytes int64     `json:"quota_bytes"`
 }
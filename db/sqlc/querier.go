@@ -8,12 +8,31 @@ import (
 
 type Querier interface {
 	CheckUserDir(ctx context.Context, arg CheckUserDirParams) (Directory, error)
+	CreateFileOperation(ctx context.Context, arg CreateFileOperationParams) (FileOperation, error)
+	CreateFileOperationChange(ctx context.Context, arg CreateFileOperationChangeParams) (FileOperationChange, error)
+	CreateFileRevision(ctx context.Context, arg CreateFileRevisionParams) (FileRevision, error)
 	CreateUser(ctx context.Context, arg CreateUserParams) (User, error)
 	CreateUserDir(ctx context.Context, arg CreateUserDirParams) (Directory, error)
+	DeleteDirs(ctx context.Context, name string) error
+	DeleteFileOperation(ctx context.Context, operationID int64) error
 	DeleteUserDir(ctx context.Context, arg DeleteUserDirParams) error
+	GetDirGrants(ctx context.Context, name string) ([]Directory, error)
+	GetFileOperation(ctx context.Context, operationID int64) (FileOperation, error)
+	GetFileRevision(ctx context.Context, revisionID int64) (FileRevision, error)
+	GetLatestFileRevision(ctx context.Context, path string) (FileRevision, error)
 	GetUser(ctx context.Context, username string) (User, error)
 	GetUserByEmail(ctx context.Context, email string) (User, error)
+	GetUserDir(ctx context.Context, arg GetUserDirParams) (Directory, error)
 	GetUserDirs(ctx context.Context, userID int64) ([]Directory, error)
+	ListFileOperationChanges(ctx context.Context, operationID int64) ([]FileOperationChange, error)
+	ListFileOperations(ctx context.Context, arg ListFileOperationsParams) ([]FileOperation, error)
+	ListFileRevisions(ctx context.Context, arg ListFileRevisionsParams) ([]FileRevision, error)
+	ListUsers(ctx context.Context) ([]User, error)
+	RenameDirs(ctx context.Context, arg RenameDirsParams) error
+	SetFileOperationUndone(ctx context.Context, arg SetFileOperationUndoneParams) error
+	UpdateDirMetadata(ctx context.Context, arg UpdateDirMetadataParams) error
+	UpdateUserDirAccess(ctx context.Context, arg UpdateUserDirAccessParams) (Directory, error)
+	UpdateUserQuota(ctx context.Context, arg UpdateUserQuotaParams) (User, error)
 }
 
 var _ Querier = (*Queries)(nil)
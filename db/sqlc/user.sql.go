@@ -15,7 +15,7 @@ INSERT INTO users (
   password
 ) VALUES (
   $1, $2, $3, $4
-) RETURNING user_id, name, username, email, password, created_at
+) RETURNING user_id, name, username, email, password, created_at, quota_bytes
 `
 
 type CreateUserParams struct {
@@ -40,12 +40,13 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.Email,
 		&i.Password,
 		&i.CreatedAt,
+		&i.QuotaBytes,
 	)
 	return i, err
 }
 
 const getUser = `-- name: GetUser :one
-SELECT user_id, name, username, email, password, created_at FROM users
+SELECT user_id, name, username, email, password, created_at, quota_bytes FROM users
 WHERE username = $1 LIMIT 1
 `
 
@@ -59,12 +60,13 @@ func (q *Queries) GetUser(ctx context.Context, username string) (User, error) {
 		&i.Email,
 		&i.Password,
 		&i.CreatedAt,
+		&i.QuotaBytes,
 	)
 	return i, err
 }
 
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT user_id, name, username, email, password, created_at FROM users
+SELECT user_id, name, username, email, password, created_at, quota_bytes FROM users
 WHERE email = $1 LIMIT 1
 `
 
@@ -78,6 +80,70 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.Email,
 		&i.Password,
 		&i.CreatedAt,
+		&i.QuotaBytes,
+	)
+	return i, err
+}
+
+const listUsers = `-- name: ListUsers :many
+SELECT user_id, name, username, email, password, created_at, quota_bytes FROM users
+ORDER BY username
+`
+
+func (q *Queries) ListUsers(ctx context.Context) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, listUsers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []User{}
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.UserID,
+			&i.Name,
+			&i.Username,
+			&i.Email,
+			&i.Password,
+			&i.CreatedAt,
+			&i.QuotaBytes,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const updateUserQuota = `-- name: UpdateUserQuota :one
+UPDATE users
+SET quota_bytes = $2
+WHERE username = $1
+RETURNING user_id, name, username, email, password, created_at, quota_bytes
+`
+
+type UpdateUserQuotaParams struct {
+	Username   string `json:"username"`
+	QuotaBytes int64  `json:"quota_bytes"`
+}
+
+func (q *Queries) UpdateUserQuota(ctx context.Context, arg UpdateUserQuotaParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUserQuota, arg.Username, arg.QuotaBytes)
+	var i User
+	err := row.Scan(
+		&i.UserID,
+		&i.Name,
+		&i.Username,
+		&i.Email,
+		&i.Password,
+		&i.CreatedAt,
+		&i.QuotaBytes,
 	)
 	return i, err
 }
@@ -0,0 +1,136 @@
+package index
+
+import (
+	"sort"
+	"strings"
+)
+
+// Scores of fuzzy matching. Every matched character scores, more so at the
+// start of a path element or word and right after the previous match.
+const (
+	scoreMatch       = 16
+	bonusBoundary    = 10
+	bonusCamelCase   = 8
+	bonusConsecutive = 12
+	bonusBasename    = 6
+)
+
+// Match is a file found by Find.
+type Match struct {
+	Path  string
+	Score int
+}
+
+// Find ranks the files below dir whose path contains the characters of
+// query in order, like the "go to file" box of an editor. At most limit
+// matches are returned, best first.
+func (idx *Index) Find(dir, query string, limit int) []Match {
+	query = strings.ToLower(strings.ReplaceAll(query, " ", ""))
+
+	idx.mu.RLock()
+	var matches []Match
+	for _, file := range idx.files {
+		if !isBelow(dir, file.Path) {
+			continue
+		}
+		if score, ok := fuzzyScore(file.Path, query); ok {
+			matches = append(matches, Match{Path: file.Path, Score: score})
+		}
+	}
+	idx.mu.RUnlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		if len(matches[i].Path) != len(matches[j].Path) {
+			return len(matches[i].Path) < len(matches[j].Path)
+		}
+		return matches[i].Path < matches[j].Path
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// fuzzyScore returns the best score of query, already lower cased, as a
+// subsequence of path. It runs in O(len(path) * len(query)).
+func fuzzyScore(path, query string) (int, bool) {
+	if query == "" {
+		return 0, true
+	}
+	lower := strings.ToLower(path)
+
+	// quick rejection before scoring
+	j := 0
+	for i := 0; i < len(lower) && j < len(query); i++ {
+		if lower[i] == query[j] {
+			j++
+		}
+	}
+	if j < len(query) {
+		return 0, false
+	}
+
+	baseStart := strings.LastIndexByte(path, '/') + 1
+	bonus := make([]int, len(path))
+	for i := range path {
+		switch {
+		case i == 0 || strings.IndexByte("/_-. ", path[i-1]) >= 0:
+			bonus[i] = bonusBoundary
+		case isLowerASCII(path[i-1]) && isUpperASCII(path[i]):
+			bonus[i] = bonusCamelCase
+		}
+		if i >= baseStart {
+			bonus[i] += bonusBasename
+		}
+	}
+
+	// prev[i] is the best score with the previous query character at i
+	const none = -1 << 30
+	prev := make([]int, len(path))
+	cur := make([]int, len(path))
+	for i := range prev {
+		prev[i] = none
+		if lower[i] == query[0] {
+			prev[i] = scoreMatch + bonus[i]
+		}
+	}
+	for q := 1; q < len(query); q++ {
+		best := none
+		for i := range cur {
+			cur[i] = none
+			if i >= 2 && prev[i-2] > best {
+				best = prev[i-2]
+			}
+			if lower[i] != query[q] || i == 0 {
+				continue
+			}
+			score := best
+			if prev[i-1] != none && prev[i-1]+bonusConsecutive > score {
+				score = prev[i-1] + bonusConsecutive
+			}
+			if score != none {
+				cur[i] = score + scoreMatch + bonus[i]
+			}
+		}
+		prev, cur = cur, prev
+	}
+
+	best := none
+	for _, score := range prev {
+		if score > best {
+			best = score
+		}
+	}
+	return best, best != none
+}
+
+func isLowerASCII(c byte) bool {
+	return c >= 'a' && c <= 'z'
+}
+
+func isUpperASCII(c byte) bool {
+	return c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,443 @@
+// Package index keeps a list of the files below a directory together with a
+// trigram index of their content, so that file lookups and content searches
+// don't have to walk the tree.
+package index
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxFileSize bounds the files whose content is indexed. Larger files are
+// listed but never returned as search candidates.
+const MaxFileSize = 4 << 20
+
+// bytes sniffed for a NUL byte to tell binaries apart
+const sniffSize = 8000
+
+// File is an indexed file. Path is slash separated and relative to the root
+// of the index.
+type File struct {
+	Path     string
+	Size     int64
+	ModTime  time.Time
+	Binary   bool
+	Large    bool
+	Trigrams []uint32
+}
+
+// Index is the file list and trigram index of one directory tree. Hidden
+// entries and symlinks are left out, like in the directory listings.
+type Index struct {
+	root     string
+	maxFiles int
+
+	// refreshMu serializes refreshes, mu guards the data
+	refreshMu sync.Mutex
+	mu        sync.RWMutex
+	files     map[string]*File
+	postings  map[uint32]map[*File]struct{}
+	truncated bool
+	refreshed time.Time
+	dirty     bool
+}
+
+// New returns an empty index of root holding at most maxFiles files.
+func New(root string, maxFiles int) *Index {
+	return &Index{
+		root:     filepath.Clean(root),
+		maxFiles: maxFiles,
+		files:    make(map[string]*File),
+		postings: make(map[uint32]map[*File]struct{}),
+	}
+}
+
+// Root returns the directory indexed by idx.
+func (idx *Index) Root() string {
+	return idx.root
+}
+
+// Truncated reports whether the tree holds more files than the index does.
+// Lookups on a truncated index are incomplete.
+func (idx *Index) Truncated() bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.truncated
+}
+
+// Refresh brings the index up to date with the tree. Only files whose size
+// or modification time changed are read again.
+func (idx *Index) Refresh() error {
+	idx.refreshMu.Lock()
+	defer idx.refreshMu.Unlock()
+	return idx.refresh()
+}
+
+// refreshIfOlder refreshes the index unless another caller did so within
+// maxAge. It reports whether it refreshed.
+func (idx *Index) refreshIfOlder(maxAge time.Duration) (bool, error) {
+	idx.refreshMu.Lock()
+	defer idx.refreshMu.Unlock()
+
+	if !idx.stale(maxAge) {
+		return false, nil
+	}
+	return true, idx.refresh()
+}
+
+// needsBuild reports whether the index was never checked against the tree.
+func (idx *Index) needsBuild() bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.refreshed.IsZero()
+}
+
+// stale reports whether the index was last refreshed more than maxAge ago.
+func (idx *Index) stale(maxAge time.Duration) bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return time.Since(idx.refreshed) > maxAge
+}
+
+// refresh does the work of Refresh. Callers hold refreshMu.
+func (idx *Index) refresh() error {
+	seen, truncated, err := idx.walk(idx.root)
+	if err != nil {
+		return err
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for rel, file := range idx.files {
+		if !seen[rel] {
+			idx.remove(file)
+		}
+	}
+	if idx.truncated != truncated {
+		idx.truncated = truncated
+		idx.dirty = true
+	}
+	idx.refreshed = time.Now()
+	return nil
+}
+
+// refreshDir brings the files below dir, a path below the root, up to date.
+// Unlike a refresh it leaves the rest of the index alone and only ever sets
+// the truncated flag.
+func (idx *Index) refreshDir(dir, rel string) {
+	seen, truncated, err := idx.walk(dir)
+	if err != nil {
+		return
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for filePath, file := range idx.files {
+		if isBelow(rel, filePath) && !seen[filePath] {
+			idx.remove(file)
+		}
+	}
+	if truncated && !idx.truncated {
+		idx.truncated = true
+		idx.dirty = true
+	}
+}
+
+// walk indexes the new and changed files below dir and returns the paths of
+// all files it found. It stops when the index would hold more than maxFiles
+// files and reports the tree as truncated.
+func (idx *Index) walk(dir string) (map[string]bool, bool, error) {
+	seen := make(map[string]bool)
+	truncated := false
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			return nil
+		}
+		if path != dir && isHidden(d.Name()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(idx.root, path)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		idx.mu.RLock()
+		file := idx.files[rel]
+		// a whole tree counts what it found, a subtree what it adds
+		full := len(seen) >= idx.maxFiles
+		if dir != idx.root {
+			full = file == nil && len(idx.files) >= idx.maxFiles
+		}
+		idx.mu.RUnlock()
+		if full {
+			truncated = true
+			return filepath.SkipAll
+		}
+		seen[rel] = true
+		if file == nil || file.Size != info.Size() || !file.ModTime.Equal(info.ModTime()) {
+			file = readFile(path, rel, info)
+			idx.mu.Lock()
+			idx.put(file)
+			idx.mu.Unlock()
+		}
+		return nil
+	})
+	return seen, truncated, err
+}
+
+// Update indexes the current state of path, which may have been created,
+// changed or removed. A directory is indexed with everything below it. Paths
+// outside of the root or hidden are ignored.
+func (idx *Index) Update(path string) {
+	rel, ok := idx.rel(path)
+	if !ok || rel == "" {
+		return
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		idx.Remove(path)
+		return
+	}
+	if info.IsDir() {
+		// a directory created, copied or moved in with its files
+		idx.refreshDir(path, rel)
+		return
+	}
+	if !info.Mode().IsRegular() {
+		idx.Remove(path)
+		return
+	}
+	file := readFile(path, rel, info)
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.files[rel] == nil && len(idx.files) >= idx.maxFiles {
+		idx.truncated = true
+		return
+	}
+	idx.put(file)
+}
+
+// Remove drops path and, when it was a directory, everything below it.
+func (idx *Index) Remove(path string) {
+	rel, ok := idx.rel(path)
+	if !ok {
+		return
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for filePath, file := range idx.files {
+		if rel == "" || filePath == rel || strings.HasPrefix(filePath, rel+"/") {
+			idx.remove(file)
+		}
+	}
+}
+
+// rel returns path relative to the root, or false when path is outside of
+// the root or hidden below it.
+func (idx *Index) rel(path string) (string, bool) {
+	rel, err := filepath.Rel(idx.root, filepath.Clean(path))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", false
+	}
+	if rel == "." {
+		return "", true
+	}
+	rel = filepath.ToSlash(rel)
+	for _, elem := range strings.Split(rel, "/") {
+		if isHidden(elem) {
+			return "", false
+		}
+	}
+	return rel, true
+}
+
+// put adds or replaces a file. Callers hold mu.
+func (idx *Index) put(file *File) {
+	if old := idx.files[file.Path]; old != nil {
+		idx.remove(old)
+	}
+	idx.files[file.Path] = file
+	for _, tri := range file.Trigrams {
+		posting := idx.postings[tri]
+		if posting == nil {
+			posting = make(map[*File]struct{})
+			idx.postings[tri] = posting
+		}
+		posting[file] = struct{}{}
+	}
+	idx.dirty = true
+}
+
+// remove drops a file. Callers hold mu.
+func (idx *Index) remove(file *File) {
+	delete(idx.files, file.Path)
+	for _, tri := range file.Trigrams {
+		posting := idx.postings[tri]
+		delete(posting, file)
+		if len(posting) == 0 {
+			delete(idx.postings, tri)
+		}
+	}
+	idx.dirty = true
+}
+
+// Files returns the files below dir, a slash separated path relative to the
+// root, sorted by path. The trigrams of the returned files are not set.
+func (idx *Index) Files(dir string) []File {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	var files []File
+	for _, file := range idx.files {
+		if isBelow(dir, file.Path) {
+			files = append(files, withoutTrigrams(file))
+		}
+	}
+	sortFiles(files)
+	return files
+}
+
+// Candidates returns the text files below dir that may contain all of the
+// literals, ignoring case. Without a literal of at least three bytes every
+// text file below dir is a candidate.
+func (idx *Index) Candidates(dir string, literals []string) []File {
+	var trigrams []uint32
+	for _, literal := range literals {
+		trigrams = append(trigrams, Trigrams([]byte(strings.ToLower(literal)))...)
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	var files []File
+	add := func(file *File) {
+		if !file.Binary && !file.Large && isBelow(dir, file.Path) {
+			files = append(files, withoutTrigrams(file))
+		}
+	}
+
+	if len(trigrams) == 0 {
+		for _, file := range idx.files {
+			add(file)
+		}
+		sortFiles(files)
+		return files
+	}
+
+	// intersect starting from the shortest posting list
+	sort.Slice(trigrams, func(i, j int) bool {
+		return len(idx.postings[trigrams[i]]) < len(idx.postings[trigrams[j]])
+	})
+	for file := range idx.postings[trigrams[0]] {
+		matched := true
+		for _, tri := range trigrams[1:] {
+			if _, ok := idx.postings[tri][file]; !ok {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			add(file)
+		}
+	}
+	sortFiles(files)
+	return files
+}
+
+// Trigrams returns the sorted distinct trigrams of data.
+func Trigrams(data []byte) []uint32 {
+	if len(data) < 3 {
+		return nil
+	}
+	set := make(map[uint32]struct{})
+	for i := 0; i+3 <= len(data); i++ {
+		set[uint32(data[i])<<16|uint32(data[i+1])<<8|uint32(data[i+2])] = struct{}{}
+	}
+	trigrams := make([]uint32, 0, len(set))
+	for tri := range set {
+		trigrams = append(trigrams, tri)
+	}
+	sort.Slice(trigrams, func(i, j int) bool { return trigrams[i] < trigrams[j] })
+	return trigrams
+}
+
+// readFile builds the index entry of a file. Content is indexed lower cased
+// so that one index serves case sensitive and insensitive searches.
+func readFile(path, rel string, info fs.FileInfo) *File {
+	file := &File{
+		Path:    rel,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+	if file.Size > MaxFileSize {
+		file.Large = true
+		return file
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		file.Binary = true
+		return file
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, MaxFileSize+1))
+	if err != nil {
+		file.Binary = true
+		return file
+	}
+
+	head := data
+	if len(head) > sniffSize {
+		head = head[:sniffSize]
+	}
+	if bytes.IndexByte(head, 0) >= 0 {
+		file.Binary = true
+		return file
+	}
+	file.Trigrams = Trigrams(bytes.ToLower(data))
+	return file
+}
+
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// isBelow reports whether the relative path is inside dir, "" being the
+// root.
+func isBelow(dir, path string) bool {
+	return dir == "" || path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+func withoutTrigrams(file *File) File {
+	copy := *file
+	copy.Trigrams = nil
+	return copy
+}
+
+func sortFiles(files []File) {
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+}
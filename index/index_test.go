@@ -0,0 +1,176 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, root, rel, content string) {
+	path := filepath.Join(root, filepath.FromSlash(rel))
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+}
+
+func paths(files []File) []string {
+	var res []string
+	for _, file := range files {
+		res = append(res, file.Path)
+	}
+	return res
+}
+
+func TestIndexCandidates(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "main.go", "package main\n\nfunc HandleRequest() {}\n")
+	writeFile(t, root, "api/server.go", "package api\n\ntype Server struct{}\n")
+	writeFile(t, root, "bin/tool", "\x00\x01ELF handlerequest")
+	writeFile(t, root, ".git/config", "handlerequest")
+
+	idx := New(root, 100)
+	require.NoError(t, idx.Refresh())
+	require.Equal(t, []string{"api/server.go", "bin/tool", "main.go"}, paths(idx.Files("")))
+
+	require.Equal(t, []string{"main.go"}, paths(idx.Candidates("", []string{"handlerequest"})))
+	require.Equal(t, []string{"api/server.go"}, paths(idx.Candidates("api", []string{"package"})))
+	require.Empty(t, idx.Candidates("", []string{"missing"}))
+
+	// changes are picked up incrementally
+	writeFile(t, root, "api/server.go", "package api\n\nfunc HandleRequest() {}\n")
+	require.NoError(t, os.Chtimes(filepath.Join(root, "api/server.go"), time.Now(), time.Now().Add(time.Second)))
+	require.NoError(t, os.Remove(filepath.Join(root, "main.go")))
+	require.NoError(t, idx.Refresh())
+	require.Equal(t, []string{"api/server.go"}, paths(idx.Candidates("", []string{"HandleRequest"})))
+
+	// snapshots round trip
+	snapshot := filepath.Join(t.TempDir(), "index.gob")
+	require.NoError(t, idx.save(snapshot))
+	loaded := New(root, 100)
+	require.NoError(t, loaded.load(snapshot))
+	require.Equal(t, paths(idx.Files("")), paths(loaded.Files("")))
+	require.Equal(t, []string{"api/server.go"}, paths(loaded.Candidates("", []string{"handlerequest"})))
+}
+
+func TestIndexFind(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "api/server.go", "")
+	writeFile(t, root, "api/search.go", "")
+	writeFile(t, root, "util/string_view.go", "")
+	writeFile(t, root, "docs/some/very/random/es/readme.md", "")
+
+	idx := New(root, 100)
+	require.NoError(t, idx.Refresh())
+
+	matches := idx.Find("", "srv", 10)
+	require.NotEmpty(t, matches)
+	require.Equal(t, "api/server.go", matches[0].Path)
+
+	matches = idx.Find("", "sv", 10)
+	require.Equal(t, "util/string_view.go", matches[0].Path)
+
+	require.Empty(t, idx.Find("", "xyz", 10))
+	require.Len(t, idx.Find("api", "", 10), 2)
+}
+
+func TestIndexUpdateDir(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "main.go", "package main")
+	idx := New(root, 100)
+	require.NoError(t, idx.Refresh())
+
+	// a directory copied in is indexed with its files
+	writeFile(t, root, "lib/a.go", "package lib")
+	writeFile(t, root, "lib/sub/b.go", "package sub")
+	idx.Update(filepath.Join(root, "lib"))
+	require.Equal(t, []string{"lib/a.go", "lib/sub/b.go", "main.go"}, paths(idx.Files("")))
+
+	require.NoError(t, os.Remove(filepath.Join(root, "lib/sub/b.go")))
+	idx.Update(filepath.Join(root, "lib"))
+	require.Equal(t, []string{"lib/a.go", "main.go"}, paths(idx.Files("")))
+}
+
+// fakeWatcher records the roots watched.
+type fakeWatcher struct {
+	err   error
+	roots map[string]int
+}
+
+func (w *fakeWatcher) Add(root string) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.roots[root]++
+	return nil
+}
+
+func (w *fakeWatcher) Remove(root string) {
+	w.roots[root]--
+}
+
+func TestServiceWatched(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "main.go", "package main")
+	s := NewService(t.TempDir(), 100, 10, 0, time.Hour)
+	watcher := &fakeWatcher{roots: make(map[string]int)}
+	s.SetWatcher(watcher)
+
+	idx, err := s.Get(root)
+	require.NoError(t, err)
+	require.Equal(t, 1, watcher.roots[root])
+	require.Equal(t, []string{"main.go"}, paths(idx.Files("")))
+
+	// a watched index is not walked again, changes come in through Notify
+	writeFile(t, root, "util.go", "package main")
+	idx, err = s.Get(root)
+	require.NoError(t, err)
+	require.Equal(t, []string{"main.go"}, paths(idx.Files("")))
+	s.Notify(filepath.Join(root, "util.go"))
+	require.Equal(t, []string{"main.go", "util.go"}, paths(idx.Files("")))
+}
+
+func TestServiceUnwatched(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "main.go", "package main")
+	s := NewService(t.TempDir(), 100, 10, 0, time.Hour)
+	s.SetWatcher(&fakeWatcher{err: os.ErrPermission})
+
+	idx, err := s.Get(root)
+	require.NoError(t, err)
+	writeFile(t, root, "util.go", "package main")
+
+	// a stale index is refreshed in the background
+	_, err = s.Get(root)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return len(idx.Files("")) == 2
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestServiceEvicts(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	writeFile(t, first, "main.go", "package main")
+	dir := t.TempDir()
+	s := NewService(dir, 100, 1, time.Hour, time.Hour)
+	watcher := &fakeWatcher{roots: make(map[string]int)}
+	s.SetWatcher(watcher)
+
+	_, err := s.Get(first)
+	require.NoError(t, err)
+	_, err = s.Get(second)
+	require.NoError(t, err)
+
+	// the least recently used index is saved and no longer watched
+	require.Len(t, s.indexes, 1)
+	require.NotNil(t, s.indexes[second])
+	require.Equal(t, 0, watcher.roots[first])
+	require.FileExists(t, s.snapshotPath(first))
+
+	// and loaded again from its snapshot
+	idx, err := s.Get(first)
+	require.NoError(t, err)
+	require.Equal(t, []string{"main.go"}, paths(idx.Files("")))
+	require.Equal(t, 0, watcher.roots[second])
+}
@@ -0,0 +1,286 @@
+package index
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// snapshotVersion is bumped whenever the persisted format changes, so old
+// snapshots are rebuilt instead of misread.
+const snapshotVersion = 1
+
+type snapshot struct {
+	Version   int
+	Root      string
+	Truncated bool
+	Files     []*File
+}
+
+// Watcher reports changes below the roots it watches, made through the API
+// or not, back to the service's Notify.
+type Watcher interface {
+	Add(root string) error
+	Remove(root string)
+}
+
+// Service keeps the indexes of the workspace roots. Indexes are loaded from
+// disk or built on first use and then kept up to date by Notify, which the
+// watcher calls for changes made outside of the API, e.g. from the terminal.
+// Only indexes of roots that cannot be watched are walked again, in the
+// background once they are older than refresh. The least recently used
+// indexes are persisted and dropped when more than maxLoaded are loaded or
+// they have not been used for idle.
+type Service struct {
+	dir       string
+	maxFiles  int
+	maxLoaded int
+	refresh   time.Duration
+	idle      time.Duration
+
+	mu      sync.Mutex
+	watcher Watcher
+	indexes map[string]*loadedIndex
+}
+
+type loadedIndex struct {
+	idx  *Index
+	used time.Time
+	// whether the watcher reports every change below the root, set before
+	// ready is closed
+	watched bool
+	ready   chan struct{}
+}
+
+// NewService returns a service persisting its indexes in dir.
+func NewService(dir string, maxFiles, maxLoaded int, refresh, idle time.Duration) *Service {
+	return &Service{
+		dir:       dir,
+		maxFiles:  maxFiles,
+		maxLoaded: maxLoaded,
+		refresh:   refresh,
+		idle:      idle,
+		indexes:   make(map[string]*loadedIndex),
+	}
+}
+
+// SetWatcher makes the service watch the roots of the indexes it loads.
+func (s *Service) SetWatcher(w Watcher) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.watcher = w
+}
+
+// Get returns the index of root. An index is built or checked against the
+// tree when it is loaded; after that only indexes of unwatched roots are
+// refreshed, in the background, when they are stale.
+func (s *Service) Get(root string) (*Index, error) {
+	root = filepath.Clean(root)
+
+	s.mu.Lock()
+	loaded := s.indexes[root]
+	created := loaded == nil
+	if created {
+		loaded = &loadedIndex{idx: New(root, s.maxFiles), ready: make(chan struct{})}
+		if err := loaded.idx.load(s.snapshotPath(root)); err != nil && !os.IsNotExist(err) {
+			log.Println("cannot load index snapshot:", err)
+		}
+		s.indexes[root] = loaded
+	}
+	loaded.used = time.Now()
+	evicted := s.evictLocked()
+	watcher := s.watcher
+	s.mu.Unlock()
+	s.drop(evicted)
+
+	idx := loaded.idx
+	if created {
+		// watch first, so nothing changing during the walk is missed
+		if watcher != nil {
+			if err := watcher.Add(root); err != nil {
+				log.Println("cannot watch", root+", its index is refreshed periodically:", err)
+			} else {
+				loaded.watched = true
+			}
+		}
+		close(loaded.ready)
+	}
+	<-loaded.ready
+
+	if idx.needsBuild() {
+		if _, err := idx.refreshIfOlder(s.refresh); err != nil {
+			return nil, err
+		}
+		s.save(idx)
+	} else if !loaded.watched && idx.stale(s.refresh) {
+		go func() {
+			if refreshed, err := idx.refreshIfOlder(s.refresh); err != nil {
+				log.Println("cannot refresh index of", root+":", err)
+			} else if refreshed {
+				s.save(idx)
+			}
+		}()
+	}
+	return idx, nil
+}
+
+// evictLocked removes the indexes that were idle for too long and the least
+// recently used ones beyond maxLoaded. Callers hold mu and pass the removed
+// indexes to drop.
+func (s *Service) evictLocked() []*loadedIndex {
+	var evicted []*loadedIndex
+	for root, loaded := range s.indexes {
+		if s.idle > 0 && time.Since(loaded.used) > s.idle {
+			delete(s.indexes, root)
+			evicted = append(evicted, loaded)
+		}
+	}
+	for s.maxLoaded > 0 && len(s.indexes) > s.maxLoaded {
+		var oldest string
+		for root, loaded := range s.indexes {
+			if oldest == "" || loaded.used.Before(s.indexes[oldest].used) {
+				oldest = root
+			}
+		}
+		evicted = append(evicted, s.indexes[oldest])
+		delete(s.indexes, oldest)
+	}
+	return evicted
+}
+
+// drop persists evicted indexes and stops watching their roots.
+func (s *Service) drop(evicted []*loadedIndex) {
+	for _, loaded := range evicted {
+		<-loaded.ready
+		if loaded.watched {
+			s.watcher.Remove(loaded.idx.root)
+		}
+		s.save(loaded.idx)
+	}
+}
+
+func (s *Service) save(idx *Index) {
+	if err := idx.save(s.snapshotPath(idx.root)); err != nil {
+		log.Println("cannot save index snapshot:", err)
+	}
+}
+
+// ExpireIdle drops the indexes not used for the idle time and persists the
+// changes of the others, every quarter of the idle time.
+func (s *Service) ExpireIdle() {
+	if s.idle <= 0 {
+		return
+	}
+	for {
+		time.Sleep(s.idle / 4)
+		s.mu.Lock()
+		evicted := s.evictLocked()
+		var loaded []*Index
+		for _, l := range s.indexes {
+			loaded = append(loaded, l.idx)
+		}
+		s.mu.Unlock()
+		s.drop(evicted)
+		for _, idx := range loaded {
+			s.save(idx)
+		}
+	}
+}
+
+// Notify tells the loaded indexes containing path that it changed.
+func (s *Service) Notify(path string) {
+	s.mu.Lock()
+	var indexes []*Index
+	for root, loaded := range s.indexes {
+		if path == root || strings.HasPrefix(path, root+string(filepath.Separator)) {
+			indexes = append(indexes, loaded.idx)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, idx := range indexes {
+		idx.Update(path)
+	}
+}
+
+func (s *Service) snapshotPath(root string) string {
+	sum := sha256.Sum256([]byte(root))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".gob")
+}
+
+// load fills an empty index from a snapshot. The snapshot is kept stale so
+// the next Get checks it against the tree.
+func (idx *Index) load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var snap snapshot
+	if err := gob.NewDecoder(f).Decode(&snap); err != nil {
+		return err
+	}
+	if snap.Version != snapshotVersion || snap.Root != idx.root {
+		return nil
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for _, file := range snap.Files {
+		idx.put(file)
+	}
+	idx.truncated = snap.Truncated
+	idx.dirty = false
+	return nil
+}
+
+// save writes the index to path if it changed since it was last saved.
+func (idx *Index) save(path string) (err error) {
+	idx.mu.Lock()
+	if !idx.dirty {
+		idx.mu.Unlock()
+		return nil
+	}
+	snap := snapshot{
+		Version:   snapshotVersion,
+		Root:      idx.root,
+		Truncated: idx.truncated,
+	}
+	for _, file := range idx.files {
+		snap.Files = append(snap.Files, file)
+	}
+	idx.dirty = false
+	idx.mu.Unlock()
+	defer func() {
+		if err != nil {
+			idx.mu.Lock()
+			idx.dirty = true
+			idx.mu.Unlock()
+		}
+	}()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".index-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := gob.NewEncoder(tmp).Encode(&snap); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,100 @@
+package lang
+
+// Defaults returns the built-in languages. A new slice is returned on each
+// call, so callers may modify it.
+func Defaults() []*Language {
+	return []*Language{
+		{
+			Name:       "go",
+			Extensions: []string{".go"},
+			Mode:       "go",
+			Comments:   Comments{Line: "//", BlockStart: "/*", BlockEnd: "*/"},
+			Formatter:  []string{"goimports", "-srcdir", FileArg},
+			Runner:     []string{"/usr/local/go/bin/go", "run", FileArg},
+			FuncRunner: "go",
+			Definition: []string{"godef", "-f", FileArg, "-o", OffsetArg},
+		},
+		{
+			Name:       "racket",
+			Extensions: []string{".rkt", ".rktl", ".scrbl"},
+			Shebangs:   []string{"racket"},
+			Mode:       "racket",
+			Comments:   Comments{Line: ";", BlockStart: "#|", BlockEnd: "|#"},
+			Formatter:  []string{"raco", "fmt"},
+			Runner:     []string{"/usr/racket/bin/racket", FileArg},
+			FuncRunner: "racket",
+		},
+		{
+			Name:       "rust",
+			Extensions: []string{".rs"},
+			Mode:       "rust",
+			Comments:   Comments{Line: "//", BlockStart: "/*", BlockEnd: "*/"},
+			Formatter:  []string{"rustfmt", "--emit", "stdout", "--edition", "2021"},
+			FuncRunner: "rust",
+		},
+		{
+			Name:       "python",
+			Extensions: []string{".py", ".pyw"},
+			Shebangs:   []string{"python", "python3"},
+			Mode:       "python",
+			Comments:   Comments{Line: "#"},
+			Formatter:  []string{"black", "-q", "-"},
+			Runner:     []string{"python3", FileArg},
+		},
+		{
+			Name:       "c",
+			Extensions: []string{".c", ".h"},
+			Mode:       "c",
+			Comments:   Comments{Line: "//", BlockStart: "/*", BlockEnd: "*/"},
+			Formatter:  []string{"clang-format"},
+		},
+		{
+			Name:       "javascript",
+			Extensions: []string{".js", ".mjs", ".cjs", ".jsx"},
+			Shebangs:   []string{"node"},
+			Mode:       "javascript",
+			Comments:   Comments{Line: "//", BlockStart: "/*", BlockEnd: "*/"},
+			Runner:     []string{"node", FileArg},
+		},
+		{
+			Name:       "shell",
+			Extensions: []string{".sh", ".bash"},
+			Filenames:  []string{".bashrc", ".profile"},
+			Shebangs:   []string{"sh", "bash", "zsh"},
+			Mode:       "shell",
+			Comments:   Comments{Line: "#"},
+			Runner:     []string{"bash", FileArg},
+		},
+		{
+			Name:       "makefile",
+			Filenames:  []string{"Makefile", "makefile", "GNUmakefile"},
+			Extensions: []string{".mk"},
+			Mode:       "makefile",
+			Comments:   Comments{Line: "#"},
+		},
+		{
+			Name:       "markdown",
+			Extensions: []string{".md", ".markdown"},
+			Mode:       "markdown",
+			Comments:   Comments{BlockStart: "<!--", BlockEnd: "-->"},
+		},
+		{
+			Name:       "json",
+			Extensions: []string{".json"},
+			Mode:       "json",
+		},
+		{
+			Name:       "toml",
+			Extensions: []string{".toml"},
+			Filenames:  []string{"Cargo.lock"},
+			Mode:       "toml",
+			Comments:   Comments{Line: "#"},
+		},
+		{
+			Name:      "gomod",
+			Filenames: []string{"go.mod", "go.work"},
+			Mode:      "go",
+			Comments:  Comments{Line: "//"},
+		},
+	}
+}
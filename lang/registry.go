@@ -0,0 +1,186 @@
+// Package lang describes the programming languages known to the editor: how
+// files are recognized and which tools format, run and navigate them.
+package lang
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Placeholders substituted in tool commands.
+const (
+	FileArg   = "{file}"
+	OffsetArg = "{offset}"
+)
+
+// Comments is the comment syntax of a language. Either part may be empty.
+type Comments struct {
+	Line       string `json:"line,omitempty"`
+	BlockStart string `json:"block_start,omitempty"`
+	BlockEnd   string `json:"block_end,omitempty"`
+}
+
+// Language describes one language. Commands are argument lists whose
+// elements may contain the FileArg and OffsetArg placeholders.
+type Language struct {
+	Name       string   `json:"name"`
+	Extensions []string `json:"extensions,omitempty"`
+	Filenames  []string `json:"filenames,omitempty"`
+	// interpreters named in a "#!" line, e.g. "python3" or "bash"
+	Shebangs []string `json:"shebangs,omitempty"`
+	// editor mode used by the frontend for highlighting
+	Mode     string   `json:"mode"`
+	Comments Comments `json:"comments"`
+	// formatter reading the source on stdin and writing it to stdout; FileArg
+	// is the path the source belongs to, e.g. to resolve imports
+	Formatter []string `json:"formatter,omitempty"`
+	// runner executing a file that is not executable itself
+	Runner []string `json:"runner,omitempty"`
+	// built-in runner calling a single function of a file
+	FuncRunner string `json:"func_runner,omitempty"`
+	// definition provider printing "file:line:column" for a byte offset
+	Definition []string `json:"definition,omitempty"`
+}
+
+// Plaintext is the language of files no other language matches.
+var Plaintext = &Language{Name: "plaintext", Mode: "plaintext"}
+
+// Command returns the argument list of a tool command with the placeholders
+// replaced.
+func Command(command []string, file string, offset int) []string {
+	args := make([]string, len(command))
+	for i, arg := range command {
+		arg = strings.ReplaceAll(arg, FileArg, file)
+		args[i] = strings.ReplaceAll(arg, OffsetArg, fmt.Sprint(offset))
+	}
+	return args
+}
+
+// Registry finds the language of files.
+type Registry struct {
+	languages  []*Language
+	byName     map[string]*Language
+	byExt      map[string]*Language
+	byFilename map[string]*Language
+	byShebang  map[string]*Language
+}
+
+// NewRegistry builds a registry. Later languages override earlier ones with
+// the same name.
+func NewRegistry(languages []*Language) *Registry {
+	r := &Registry{
+		byName:     make(map[string]*Language),
+		byExt:      make(map[string]*Language),
+		byFilename: make(map[string]*Language),
+		byShebang:  make(map[string]*Language),
+	}
+	for _, language := range languages {
+		if old, ok := r.byName[language.Name]; ok {
+			for i := range r.languages {
+				if r.languages[i] == old {
+					r.languages[i] = language
+				}
+			}
+		} else {
+			r.languages = append(r.languages, language)
+		}
+		r.byName[language.Name] = language
+	}
+
+	for _, language := range r.languages {
+		for _, ext := range language.Extensions {
+			r.byExt[strings.ToLower(ext)] = language
+		}
+		for _, name := range language.Filenames {
+			r.byFilename[name] = language
+		}
+		for _, interpreter := range language.Shebangs {
+			r.byShebang[interpreter] = language
+		}
+	}
+	return r
+}
+
+// Load returns the default registry extended with the languages of a JSON
+// file holding an array of languages. A language with the name of a default
+// one replaces it.
+func Load(path string) (*Registry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var languages []*Language
+	if err := json.Unmarshal(data, &languages); err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %w", path, err)
+	}
+	for i, language := range languages {
+		if language.Name == "" {
+			return nil, fmt.Errorf("language %d of %s has no name", i, path)
+		}
+		if language.Mode == "" {
+			language.Mode = language.Name
+		}
+	}
+	return NewRegistry(append(Defaults(), languages...)), nil
+}
+
+// Languages returns every language of the registry.
+func (r *Registry) Languages() []*Language {
+	return r.languages
+}
+
+// Lookup returns the language with the given name.
+func (r *Registry) Lookup(name string) (*Language, bool) {
+	language, ok := r.byName[name]
+	return language, ok
+}
+
+// Detect returns the language of a file from its name and, failing that,
+// from the "#!" line at the start of head. It never returns nil.
+func (r *Registry) Detect(path string, head []byte) *Language {
+	name := filepath.Base(path)
+	if language, ok := r.byFilename[name]; ok {
+		return language
+	}
+	if ext := filepath.Ext(name); ext != "" {
+		if language, ok := r.byExt[strings.ToLower(ext)]; ok {
+			return language
+		}
+	}
+	if interpreter := shebangInterpreter(head); interpreter != "" {
+		if language, ok := r.byShebang[interpreter]; ok {
+			return language
+		}
+	}
+	return Plaintext
+}
+
+// shebangInterpreter returns the interpreter named by a "#!" line, looking
+// through "/usr/bin/env".
+func shebangInterpreter(head []byte) string {
+	if !bytes.HasPrefix(head, []byte("#!")) {
+		return ""
+	}
+	line := head[2:]
+	if end := bytes.IndexByte(line, '\n'); end >= 0 {
+		line = line[:end]
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) == 0 {
+		return ""
+	}
+	interpreter := filepath.Base(fields[0])
+	if interpreter == "env" {
+		for _, field := range fields[1:] {
+			if !strings.HasPrefix(field, "-") {
+				return filepath.Base(field)
+			}
+		}
+		return ""
+	}
+	return interpreter
+}
@@ -0,0 +1,45 @@
+package lang
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetect(t *testing.T) {
+	r := NewRegistry(Defaults())
+
+	require.Equal(t, "go", r.Detect("/home/dian/main.go", nil).Name)
+	require.Equal(t, "racket", r.Detect("lib.RKT", nil).Name)
+	require.Equal(t, "gomod", r.Detect("project/go.mod", nil).Name)
+	require.Equal(t, "makefile", r.Detect("Makefile", nil).Name)
+	require.Equal(t, "python", r.Detect("bin/tool", []byte("#!/usr/bin/env -S python3\nprint(1)")).Name)
+	require.Equal(t, "shell", r.Detect("run", []byte("#!/bin/bash\n")).Name)
+	require.Equal(t, Plaintext, r.Detect("notes.txt", []byte("hello")))
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "languages.json")
+	config := `[
+		{"name": "rust", "extensions": [".rs"], "mode": "rust", "runner": ["cargo", "run"]},
+		{"name": "lua", "extensions": [".lua"], "comments": {"line": "--"}}
+	]`
+	require.NoError(t, os.WriteFile(path, []byte(config), 0644))
+
+	r, err := Load(path)
+	require.NoError(t, err)
+
+	lua := r.Detect("init.lua", nil)
+	require.Equal(t, "lua", lua.Name)
+	require.Equal(t, "lua", lua.Mode)
+	require.Equal(t, "--", lua.Comments.Line)
+
+	rust, ok := r.Lookup("rust")
+	require.True(t, ok)
+	require.Equal(t, []string{"cargo", "run"}, rust.Runner)
+	require.Equal(t, len(Defaults())+1, len(r.Languages()))
+
+	require.Equal(t, []string{"godef", "-f", "a.go", "-o", "42"}, Command([]string{"godef", "-f", FileArg, "-o", OffsetArg}, "a.go", 42))
+}
@@ -3,6 +3,9 @@ package util
 import (
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -21,7 +24,37 @@ type Config struct {
 	GoogleClientSecret string
 	GithubClientId     string
 	GithubClientSecret string
-	DomainName string
+	DomainName         string
+	WorkspaceRoot      string
+	GuestUsername      string
+	RevisionDir        string
+	MaxUploadSize      int64
+	MaxExtractSize     int64
+	MaxArchiveEntries  int64
+	IndexDir           string
+	IndexMaxFiles      int64
+	IndexRefresh       time.Duration
+	IndexMaxLoaded     int64
+	IndexIdle          time.Duration
+	WatchMaxDirs       int64
+	LanguagesFile      string
+	LargeFileSize      int64
+	CodebaseMaxBytes   int64
+	TrashRetentionDays int64
+	DefaultQuotaBytes  int64
+	UsageRefresh       time.Duration
+	AdminUsers         []string
+	MaxRunDuration     time.Duration
+	CgroupRoot         string
+	RunLimitsFile      string
+	RunCPUs            float64
+	RunMemoryMax       int64
+	RunPidsMax         int64
+	RunIOReadBps       int64
+	RunIOWriteBps      int64
+	RunIODevice        string
+	RunPath            string
+	RunEnv             []string
 }
 
 func LoadConfig(path string) (config Config, err error) {
@@ -45,6 +78,108 @@ func LoadConfig(path string) (config Config, err error) {
 	config.GithubClientId = os.Getenv("GITHUB_CLIENT_ID")
 	config.GithubClientSecret = os.Getenv("GITHUB_CLIENT_SECRET")
 	config.DomainName = os.Getenv("DOMAIN_NAME")
+	config.WorkspaceRoot = os.Getenv("WORKSPACE_ROOT")
+	if config.WorkspaceRoot == "" {
+		config.WorkspaceRoot = "/home"
+	}
+	config.GuestUsername = os.Getenv("GUEST_USERNAME")
+	config.RevisionDir = os.Getenv("REVISION_DIR")
+	if config.RevisionDir == "" {
+		config.RevisionDir = "/var/lib/wecom/revisions"
+	}
+	config.MaxUploadSize = getEnvInt64("MAX_UPLOAD_SIZE", 100<<20)
+	config.MaxExtractSize = getEnvInt64("MAX_EXTRACT_SIZE", 500<<20)
+	config.MaxArchiveEntries = getEnvInt64("MAX_ARCHIVE_ENTRIES", 10000)
+	config.IndexDir = os.Getenv("INDEX_DIR")
+	if config.IndexDir == "" {
+		config.IndexDir = "/var/lib/wecom/index"
+	}
+	config.IndexMaxFiles = getEnvInt64("INDEX_MAX_FILES", 200000)
+	// indexes are kept up to date by watching their roots, only those that
+	// cannot be watched, e.g. beyond WATCH_MAX_DIRS, are walked this often
+	config.IndexRefresh = getEnvDuration("INDEX_REFRESH", 10*time.Second)
+	// indexes beyond the most recently used ones or unused for INDEX_IDLE are
+	// saved and dropped from memory
+	config.IndexMaxLoaded = getEnvInt64("INDEX_MAX_LOADED", 64)
+	config.IndexIdle = getEnvDuration("INDEX_IDLE", 30*time.Minute)
+	config.WatchMaxDirs = getEnvInt64("WATCH_MAX_DIRS", 8192)
+	config.LanguagesFile = os.Getenv("LANGUAGES_FILE")
+	config.LargeFileSize = getEnvInt64("LARGE_FILE_SIZE", 10<<20)
+	config.CodebaseMaxBytes = getEnvInt64("CODEBASE_MAX_BYTES", 16<<20)
+	// 0 keeps deleted files until the trash is emptied
+	config.TrashRetentionDays = getEnvInt64("TRASH_RETENTION_DAYS", 30)
+	// 0 leaves users without a quota of their own unlimited
+	config.DefaultQuotaBytes = getEnvInt64("DEFAULT_QUOTA_BYTES", 0)
+	config.UsageRefresh = getEnvDuration("USAGE_REFRESH", 5*time.Minute)
+	for _, username := range strings.Split(os.Getenv("ADMIN_USERS"), ",") {
+		if username = strings.TrimSpace(username); username != "" {
+			config.AdminUsers = append(config.AdminUsers, username)
+		}
+	}
+	// 0 lets runs go on for as long as the client waits
+	config.MaxRunDuration = getEnvDuration("MAX_RUN_DURATION", 5*time.Minute)
+	// an empty CGROUP_ROOT runs user code without resource limits, any other
+	// must be a cgroup v2 group the server can set up or it does not start
+	config.CgroupRoot = "/sys/fs/cgroup/wecom"
+	if root, ok := os.LookupEnv("CGROUP_ROOT"); ok {
+		config.CgroupRoot = root
+	}
+	config.RunLimitsFile = os.Getenv("RUN_LIMITS_FILE")
+	config.RunCPUs = getEnvFloat64("RUN_CPUS", 1)
+	config.RunMemoryMax = getEnvInt64("RUN_MEMORY_MAX", 1<<30)
+	config.RunPidsMax = getEnvInt64("RUN_PIDS_MAX", 256)
+	config.RunIOReadBps = getEnvInt64("RUN_IO_READ_BPS", 0)
+	config.RunIOWriteBps = getEnvInt64("RUN_IO_WRITE_BPS", 0)
+	// "major:minor" of the disk io limits apply to, by default the one
+	// holding the workspaces. io.max only takes whole disks, not partitions
+	// such as 8:1, so the partition of the workspaces is resolved to its
+	// disk. With io limits set the server does not start without one.
+	config.RunIODevice = os.Getenv("RUN_IO_DEVICE")
+	// user code runs with a clean environment holding only these variables
+	// besides HOME, USER and the like
+	config.RunPath = os.Getenv("RUN_PATH")
+	if config.RunPath == "" {
+		config.RunPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin:/usr/local/go/bin:/nfs/rust/cargo/bin:/usr/racket/bin"
+	}
+	config.RunEnv = []string{"RUSTUP_HOME=/nfs/rust/rustup"}
+	if env, ok := os.LookupEnv("RUN_ENV"); ok {
+		config.RunEnv = nil
+		for _, variable := range strings.Split(env, ",") {
+			if variable = strings.TrimSpace(variable); variable != "" {
+				config.RunEnv = append(config.RunEnv, variable)
+			}
+		}
+	}
 
 	return
 }
+
+// getEnvInt64 reads an integer environment variable, falling back to def when
+// it is unset or invalid.
+func getEnvInt64(key string, def int64) int64 {
+	value, err := strconv.ParseInt(os.Getenv(key), 10, 64)
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// getEnvFloat64 reads a number environment variable, falling back to def when
+// it is unset or invalid.
+func getEnvFloat64(key string, def float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// getEnvDuration reads a duration such as "30s" from the environment, falling
+// back to def when it is unset or invalid.
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return value
+}
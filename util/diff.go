@@ -0,0 +1,231 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Line operations of a diff, printed as the unified diff line prefix.
+const (
+	OpEqual  = ' '
+	OpDelete = '-'
+	OpInsert = '+'
+)
+
+// maxDiffCost bounds the edit distance explored by DiffLines. Beyond it the
+// changed middle part is reported as replaced wholesale.
+const maxDiffCost = 1000
+
+// LineEdit is one line of a line-based diff.
+type LineEdit struct {
+	Op   byte
+	Text string
+}
+
+// Hunk is a group of changes with their surrounding context lines. Start
+// lines are 1-based like in unified diff headers.
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	Edits    []LineEdit
+}
+
+// SplitLines splits s into lines, keeping the line terminators so that
+// joining the result gives s back.
+func SplitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// DiffLines returns a shortest edit script turning a into b, using Myers'
+// algorithm on the part between the common prefix and suffix.
+func DiffLines(a, b []string) []LineEdit {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var edits []LineEdit
+	for _, line := range a[:prefix] {
+		edits = append(edits, LineEdit{OpEqual, line})
+	}
+	edits = append(edits, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		edits = append(edits, LineEdit{OpEqual, line})
+	}
+	return edits
+}
+
+func myers(a, b []string) []LineEdit {
+	n, m := len(a), len(b)
+	max := n + m
+	if max == 0 {
+		return nil
+	}
+	offset := max + 1
+	v := make([]int, 2*max+3)
+
+	// trace[d] keeps v for diagonals -d..d as it was before step d
+	var trace [][]int
+	found := false
+	for d := 0; d <= max && d <= maxDiffCost && !found; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+
+	if !found {
+		var edits []LineEdit
+		for _, line := range a {
+			edits = append(edits, LineEdit{OpDelete, line})
+		}
+		for _, line := range b {
+			edits = append(edits, LineEdit{OpInsert, line})
+		}
+		return edits
+	}
+
+	var reversed []LineEdit
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		vs := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && vs[k-1+d] < vs[k+1+d]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := vs[prevK+d]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			reversed = append(reversed, LineEdit{OpEqual, a[x-1]})
+			x--
+			y--
+		}
+		if prevK == k+1 {
+			reversed = append(reversed, LineEdit{OpInsert, b[y-1]})
+		} else {
+			reversed = append(reversed, LineEdit{OpDelete, a[x-1]})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 {
+		reversed = append(reversed, LineEdit{OpEqual, a[x-1]})
+		x--
+	}
+
+	edits := make([]LineEdit, len(reversed))
+	for i, edit := range reversed {
+		edits[len(reversed)-1-i] = edit
+	}
+	return edits
+}
+
+// Hunks groups the changes of edits with up to context unchanged lines
+// around them. Changes closer than 2*context lines share a hunk.
+func Hunks(edits []LineEdit, context int) []Hunk {
+	var hunks []Hunk
+	oldLine, newLine := make([]int, len(edits)+1), make([]int, len(edits)+1)
+	for i, edit := range edits {
+		oldLine[i+1], newLine[i+1] = oldLine[i], newLine[i]
+		if edit.Op != OpInsert {
+			oldLine[i+1]++
+		}
+		if edit.Op != OpDelete {
+			newLine[i+1]++
+		}
+	}
+
+	for i := 0; i < len(edits); {
+		if edits[i].Op == OpEqual {
+			i++
+			continue
+		}
+
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for j := i; j < len(edits) && j <= end+2*context; j++ {
+			if edits[j].Op != OpEqual {
+				end = j
+			}
+		}
+		i = end + 1
+		end += context
+		if end >= len(edits) {
+			end = len(edits) - 1
+		}
+
+		hunk := Hunk{
+			OldStart: oldLine[start] + 1,
+			OldLines: oldLine[end+1] - oldLine[start],
+			NewStart: newLine[start] + 1,
+			NewLines: newLine[end+1] - newLine[start],
+			Edits:    edits[start : end+1],
+		}
+		// an empty range points at the line before it, as in diff -u
+		if hunk.OldLines == 0 {
+			hunk.OldStart--
+		}
+		if hunk.NewLines == 0 {
+			hunk.NewStart--
+		}
+		hunks = append(hunks, hunk)
+	}
+	return hunks
+}
+
+// UnifiedDiff returns the unified diff between two texts, or an empty
+// string when they are equal.
+func UnifiedDiff(fromName, toName, a, b string) string {
+	hunks := Hunks(DiffLines(SplitLines(a), SplitLines(b)), 3)
+	if len(hunks) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+	for _, hunk := range hunks {
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", hunk.OldStart, hunk.OldLines, hunk.NewStart, hunk.NewLines)
+		for _, edit := range hunk.Edits {
+			sb.WriteByte(edit.Op)
+			sb.WriteString(edit.Text)
+			if !strings.HasSuffix(edit.Text, "\n") {
+				sb.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,47 @@
+package util
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	a := "package main\n\nfunc main() {\n\tprintln(\"a\")\n}\n"
+	b := "package main\n\nfunc main() {\n\tprintln(\"b\")\n\tprintln(\"c\")\n}\n"
+
+	diff := UnifiedDiff("a/main.go", "b/main.go", a, b)
+	require.Equal(t, "--- a/main.go\n"+
+		"+++ b/main.go\n"+
+		"@@ -1,5 +1,6 @@\n"+
+		" package main\n"+
+		" \n"+
+		" func main() {\n"+
+		"-\tprintln(\"a\")\n"+
+		"+\tprintln(\"b\")\n"+
+		"+\tprintln(\"c\")\n"+
+		" }\n", diff)
+
+	require.Empty(t, UnifiedDiff("a", "b", a, a))
+	require.Contains(t, UnifiedDiff("a", "b", "x", "y"), "\\ No newline at end of file")
+}
+
+func TestDiffLinesRoundTrip(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		a := strings.Join(strings.Split(RandomString(30), ""), "\n")
+		b := strings.Join(strings.Split(RandomString(30), ""), "\n")
+
+		var oldText, newText strings.Builder
+		for _, edit := range DiffLines(SplitLines(a), SplitLines(b)) {
+			if edit.Op != OpInsert {
+				oldText.WriteString(edit.Text)
+			}
+			if edit.Op != OpDelete {
+				newText.WriteString(edit.Text)
+			}
+		}
+		require.Equal(t, a, oldText.String())
+		require.Equal(t, b, newText.String())
+	}
+}
@@ -0,0 +1,171 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// Line endings of a text file.
+const (
+	LineEndingLF   = "lf"
+	LineEndingCRLF = "crlf"
+)
+
+// TextFormat is how a text file is stored on disk: its character encoding,
+// whether it starts with a byte order mark and its line endings.
+type TextFormat struct {
+	Encoding   string
+	BOM        bool
+	LineEnding string
+}
+
+var (
+	bomUTF8    = []byte{0xef, 0xbb, 0xbf}
+	bomUTF16LE = []byte{0xff, 0xfe}
+	bomUTF16BE = []byte{0xfe, 0xff}
+)
+
+// textEncodings are the encodings DecodeText detects. Others can still be
+// given to EncodeText by their WHATWG name, e.g. "shift_jis".
+var textEncodings = map[string]encoding.Encoding{
+	"utf-8":        unicode.UTF8,
+	"utf-16le":     unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM),
+	"utf-16be":     unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM),
+	"windows-1252": charmap.Windows1252,
+	"iso-8859-1":   charmap.ISO8859_1,
+}
+
+func lookupEncoding(name string) (encoding.Encoding, string, error) {
+	name = strings.ToLower(name)
+	if enc, ok := textEncodings[name]; ok {
+		return enc, name, nil
+	}
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, "", fmt.Errorf("unknown encoding %q", name)
+	}
+	canonical, _ := htmlindex.Name(enc)
+	return enc, canonical, nil
+}
+
+func bomOf(name string) []byte {
+	switch name {
+	case "utf-8":
+		return bomUTF8
+	case "utf-16le":
+		return bomUTF16LE
+	case "utf-16be":
+		return bomUTF16BE
+	}
+	return nil
+}
+
+// DecodeText decodes the content of a text file into UTF-8 with "\n" line
+// endings and reports its format. Encoding the text again with that format
+// gives data back byte for byte. It returns false for data that does not
+// look like text.
+func DecodeText(data []byte) (string, TextFormat, bool) {
+	format := TextFormat{Encoding: "utf-8", LineEnding: LineEndingLF}
+	var text string
+	switch {
+	case bytes.HasPrefix(data, bomUTF8) && utf8.Valid(data[len(bomUTF8):]):
+		format.BOM = true
+		text = string(data[len(bomUTF8):])
+	case bytes.HasPrefix(data, bomUTF16LE) || bytes.HasPrefix(data, bomUTF16BE):
+		format.BOM = true
+		format.Encoding = "utf-16le"
+		if bytes.HasPrefix(data, bomUTF16BE) {
+			format.Encoding = "utf-16be"
+		}
+		decoded, err := textEncodings[format.Encoding].NewDecoder().Bytes(data[2:])
+		if err != nil {
+			return "", format, false
+		}
+		text = string(decoded)
+	case bytes.IndexByte(data, 0) >= 0:
+		return "", format, false
+	case utf8.Valid(data) && !hasControlBytes(data):
+		text = string(data)
+	case !hasControlBytes(data):
+		// legacy single byte text; windows-1252 leaves a few bytes undefined
+		for _, name := range []string{"windows-1252", "iso-8859-1"} {
+			decoded, err := textEncodings[name].NewDecoder().Bytes(data)
+			if err == nil {
+				format.Encoding = name
+				text = string(decoded)
+				if encoded, err := EncodeText(text, format); err == nil && bytes.Equal(encoded, data) {
+					break
+				}
+			}
+		}
+	default:
+		return "", format, false
+	}
+
+	if strings.Contains(text, "\r\n") && strings.Count(text, "\n") == strings.Count(text, "\r\n") {
+		crlf := format
+		crlf.LineEnding = LineEndingCRLF
+		normalized := strings.ReplaceAll(text, "\r\n", "\n")
+		if encoded, err := EncodeText(normalized, crlf); err == nil && bytes.Equal(encoded, data) {
+			return normalized, crlf, true
+		}
+	}
+	if encoded, err := EncodeText(text, format); err != nil || !bytes.Equal(encoded, data) {
+		return "", format, false
+	}
+	return text, format, true
+}
+
+// hasControlBytes reports whether data holds control characters that do not
+// occur in text files.
+func hasControlBytes(data []byte) bool {
+	for _, b := range data {
+		if b < 0x20 && b != '\t' && b != '\n' && b != '\r' && b != '\f' && b != '\v' && b != 0x1b {
+			return true
+		}
+	}
+	return false
+}
+
+// EncodeText encodes UTF-8 text in the given format. With CRLF line endings
+// every line break becomes "\r\n", whether text used "\n" or "\r\n".
+func EncodeText(text string, format TextFormat) ([]byte, error) {
+	name := format.Encoding
+	if name == "" {
+		name = "utf-8"
+	}
+	enc, name, err := lookupEncoding(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if format.LineEnding == LineEndingCRLF {
+		text = strings.ReplaceAll(text, "\r\n", "\n")
+		text = strings.ReplaceAll(text, "\n", "\r\n")
+	}
+
+	var data []byte
+	if name == "utf-8" {
+		if !utf8.ValidString(text) {
+			return nil, fmt.Errorf("text is not valid UTF-8")
+		}
+		data = []byte(text)
+	} else {
+		data, err = enc.NewEncoder().Bytes([]byte(text))
+		if err != nil {
+			return nil, fmt.Errorf("text cannot be encoded as %s", name)
+		}
+	}
+
+	if bom := bomOf(name); format.BOM && bom != nil {
+		data = append(append([]byte{}, bom...), data...)
+	}
+	return data, nil
+}
@@ -0,0 +1,60 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeTextRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		text   string
+		format TextFormat
+	}{
+		{"empty", []byte{}, "", TextFormat{Encoding: "utf-8", LineEnding: LineEndingLF}},
+		{"spaces", []byte("  indented\n\n  "), "  indented\n\n  ", TextFormat{Encoding: "utf-8", LineEnding: LineEndingLF}},
+		{"crlf", []byte("a\r\nb\r\n"), "a\nb\n", TextFormat{Encoding: "utf-8", LineEnding: LineEndingCRLF}},
+		{"mixed", []byte("a\r\nb\n"), "a\r\nb\n", TextFormat{Encoding: "utf-8", LineEnding: LineEndingLF}},
+		{"bom", []byte("\xef\xbb\xbfé\r\n"), "é\n", TextFormat{Encoding: "utf-8", BOM: true, LineEnding: LineEndingCRLF}},
+		{"utf-16le", []byte("\xff\xfeh\x00i\x00\n\x00"), "hi\n", TextFormat{Encoding: "utf-16le", BOM: true, LineEnding: LineEndingLF}},
+		{"windows-1252", []byte("caf\xe9 \x80\n"), "café €\n", TextFormat{Encoding: "windows-1252", LineEnding: LineEndingLF}},
+		{"latin-1", []byte("\x81\xe9"), "\u0081é", TextFormat{Encoding: "iso-8859-1", LineEnding: LineEndingLF}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			text, format, ok := DecodeText(tc.data)
+			require.True(t, ok)
+			require.Equal(t, tc.text, text)
+			require.Equal(t, tc.format, format)
+
+			data, err := EncodeText(text, format)
+			require.NoError(t, err)
+			require.Equal(t, tc.data, data)
+		})
+	}
+}
+
+func TestDecodeTextBinary(t *testing.T) {
+	_, _, ok := DecodeText([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"))
+	require.False(t, ok)
+	_, _, ok = DecodeText([]byte("\x7fELF\x02\x01\x01"))
+	require.False(t, ok)
+}
+
+func TestEncodeText(t *testing.T) {
+	data, err := EncodeText("a\r\nb\n", TextFormat{LineEnding: LineEndingCRLF})
+	require.NoError(t, err)
+	require.Equal(t, []byte("a\r\nb\r\n"), data)
+
+	data, err = EncodeText("日本", TextFormat{Encoding: "Shift_JIS"})
+	require.NoError(t, err)
+	require.Equal(t, []byte("\x93\xfa\x96\x7b"), data)
+
+	_, err = EncodeText("€ and ✓", TextFormat{Encoding: "iso-8859-1"})
+	require.Error(t, err)
+	_, err = EncodeText("x", TextFormat{Encoding: "klingon"})
+	require.Error(t, err)
+}
@@ -0,0 +1,92 @@
+package util
+
+import (
+	"regexp"
+	"strings"
+)
+
+// CompileGlob turns a glob into a regexp matching slash separated relative
+// paths. "*" and "?" stay within one path element, "**" spans elements and
+// [...] is a character class. A pattern without a slash matches the name of
+// an entry at any depth, and a pattern matching a directory also matches
+// everything below it.
+func CompileGlob(pattern string) (*regexp.Regexp, error) {
+	pattern = strings.TrimPrefix(pattern, "./")
+	var sb strings.Builder
+	sb.WriteString("^")
+	if !strings.Contains(strings.TrimSuffix(pattern, "/"), "/") {
+		sb.WriteString("(?:.*/)?")
+	}
+	pattern = strings.TrimPrefix(strings.TrimSuffix(pattern, "/"), "/")
+
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					i++
+					sb.WriteString("(?:.*/)?")
+				} else {
+					sb.WriteString(".*")
+				}
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				c = pattern[i]
+			}
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("(?:/.*)?$")
+	return regexp.Compile(sb.String())
+}
+
+// GlobSet matches paths against several globs at once.
+type GlobSet []*regexp.Regexp
+
+// CompileGlobs compiles every pattern of a GlobSet.
+func CompileGlobs(patterns []string) (GlobSet, error) {
+	var set GlobSet
+	for _, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		re, err := CompileGlob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		set = append(set, re)
+	}
+	return set, nil
+}
+
+// Match reports whether any glob of the set matches the relative path.
+func (set GlobSet) Match(path string) bool {
+	for _, re := range set {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompileGlob(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "api/server.go", true},
+		{"*.go", "main.gox", false},
+		{"api/*.go", "api/server.go", true},
+		{"api/*.go", "api/token/maker.go", false},
+		{"api/**/*.go", "api/token/maker.go", true},
+		{"api/**/*.go", "api/server.go", true},
+		{"**/vendor", "a/b/vendor/lib.go", true},
+		{"node_modules", "web/node_modules/x/index.js", true},
+		{"build/", "build/out.bin", true},
+		{"/build", "src/build", false},
+		{"file?.txt", "file1.txt", true},
+		{"file[0-9].txt", "filea.txt", false},
+		{"file[!0-9].txt", "filea.txt", true},
+	}
+
+	for _, tc := range testCases {
+		re, err := CompileGlob(tc.pattern)
+		require.NoError(t, err)
+		require.Equal(t, tc.match, re.MatchString(tc.path), "%s ~ %s", tc.pattern, tc.path)
+	}
+}
@@ -0,0 +1,90 @@
+package util
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+type ignoreRule struct {
+	// slash separated directory of the ignore file, "" for the root
+	base    string
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Ignore matches paths against the rules of .gitignore style files. The
+// zero value ignores nothing. Adding rules returns a new Ignore, so the
+// rules of a directory can be extended while walking its subdirectories.
+type Ignore struct {
+	rules []ignoreRule
+}
+
+// Add returns ig extended with the rules of an ignore file found in dir, a
+// slash separated path relative to the root of the matched paths. Lines are
+// gitignore patterns: "#" starts a comment, "!" re-includes, a trailing "/"
+// only matches directories and a slash elsewhere anchors the pattern to dir.
+// Invalid patterns are skipped like git does.
+func (ig Ignore) Add(dir, content string) Ignore {
+	rules := append([]ignoreRule{}, ig.rules...)
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if !strings.HasSuffix(line, `\ `) {
+			line = strings.TrimRight(line, " \t")
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := ignoreRule{base: dir}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+
+		re, err := CompileGlob(line)
+		if err != nil {
+			continue
+		}
+		rule.re = re
+		rules = append(rules, rule)
+	}
+	return Ignore{rules: rules}
+}
+
+// Match reports whether the slash separated relative path is ignored. The
+// last matching rule decides, as in git, and everything below an ignored
+// directory is ignored too.
+func (ig Ignore) Match(rel string, isDir bool) bool {
+	ignored := false
+	for _, rule := range ig.rules {
+		name := rel
+		if rule.base != "" {
+			if !strings.HasPrefix(rel, rule.base+"/") {
+				continue
+			}
+			name = rel[len(rule.base)+1:]
+		}
+		if rule.dirOnly && !isDir {
+			// only the directories containing the file can match
+			name = path.Dir(name)
+			if name == "." {
+				continue
+			}
+		}
+		if rule.re.MatchString(name) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
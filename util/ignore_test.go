@@ -0,0 +1,40 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIgnore(t *testing.T) {
+	var ig Ignore
+	ig = ig.Add("", "# build output\n/bin\ntarget/\n*.log\n!keep.log\n\\#notes\n")
+	ig = ig.Add("web", "node_modules\ndist/*.map\n")
+
+	testCases := []struct {
+		path   string
+		isDir  bool
+		ignore bool
+	}{
+		{"bin", true, true},
+		{"cmd/bin", true, false},
+		{"target", true, true},
+		{"crates/core/target", true, true},
+		{"target", false, false},
+		{"target/debug/app", false, true},
+		{"server.log", false, true},
+		{"logs/keep.log", false, false},
+		{"#notes", false, true},
+		{"web/node_modules", true, true},
+		{"web/src/node_modules", true, true},
+		{"node_modules", true, false},
+		{"web/dist/app.js.map", false, true},
+		{"web/dist/app.js", false, false},
+		{"main.go", false, false},
+	}
+	for _, tc := range testCases {
+		require.Equal(t, tc.ignore, ig.Match(tc.path, tc.isDir), tc.path)
+	}
+
+	require.False(t, Ignore{}.Match("anything", false))
+}
@@ -0,0 +1,231 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// FilePatch is the part of a unified diff changing one file. A created file
+// has no old name and a deleted one no new name.
+type FilePatch struct {
+	OldName string
+	NewName string
+	Hunks   []Hunk
+}
+
+// IsCreate reports whether the patch creates its file.
+func (p FilePatch) IsCreate() bool { return p.OldName == "" }
+
+// IsDelete reports whether the patch deletes its file.
+func (p FilePatch) IsDelete() bool { return p.NewName == "" }
+
+// HunkConflict tells why a hunk does not apply. Hunk is 1-based.
+type HunkConflict struct {
+	Hunk   int
+	Line   int
+	Reason string
+}
+
+var hunkHeaderRe = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// patchName returns the file name of a "---" or "+++" line, without the
+// timestamp diff may add.
+func patchName(line string) string {
+	name := line[4:]
+	if i := strings.IndexByte(name, '\t'); i >= 0 {
+		name = name[:i]
+	}
+	name = strings.TrimSpace(name)
+	if name == "/dev/null" {
+		return ""
+	}
+	if unquoted, err := strconv.Unquote(name); err == nil && strings.HasPrefix(name, `"`) {
+		name = unquoted
+	}
+	return name
+}
+
+// stripGitPrefixes removes the a/ and b/ prefixes git puts before the old
+// and new names. Names outside of a git diff keep them unless both carry
+// them, so diff -u of a real a/ directory targets the right files.
+func stripGitPrefixes(patch *FilePatch, git bool) {
+	if !git && !(strings.HasPrefix(patch.OldName, "a/") && strings.HasPrefix(patch.NewName, "b/")) {
+		return
+	}
+	patch.OldName = strings.TrimPrefix(patch.OldName, "a/")
+	patch.NewName = strings.TrimPrefix(patch.NewName, "b/")
+}
+
+func atoiDefault(s string, def int) int {
+	if s == "" {
+		return def
+	}
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// ParsePatch parses a unified diff covering one or more files, as written
+// by diff -u or git diff. Lines outside of file sections, such as git's
+// "diff --git" and "index" headers, are skipped.
+func ParsePatch(diff string) ([]FilePatch, error) {
+	lines := SplitLines(diff)
+	var patches []FilePatch
+	// whether the file section being read started with a "diff --git" line
+	git := false
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSuffix(lines[i], "\n")
+		if strings.HasPrefix(line, "Binary files ") || strings.HasPrefix(line, "GIT binary patch") {
+			return nil, fmt.Errorf("line %d: binary patches are not supported", i+1)
+		}
+		if strings.HasPrefix(line, "diff ") {
+			git = strings.HasPrefix(line, "diff --git ")
+		}
+		if !strings.HasPrefix(line, "--- ") {
+			continue
+		}
+		if i+1 >= len(lines) || !strings.HasPrefix(lines[i+1], "+++ ") {
+			return nil, fmt.Errorf("line %d: \"---\" is not followed by \"+++\"", i+1)
+		}
+		patch := FilePatch{
+			OldName: patchName(line),
+			NewName: patchName(strings.TrimSuffix(lines[i+1], "\n")),
+		}
+		if patch.OldName == "" && patch.NewName == "" {
+			return nil, fmt.Errorf("line %d: both file names are /dev/null", i+1)
+		}
+		stripGitPrefixes(&patch, git)
+		git = false
+		i += 2
+
+		for i < len(lines) {
+			m := hunkHeaderRe.FindStringSubmatch(lines[i])
+			if m == nil {
+				break
+			}
+			hunk := Hunk{
+				OldStart: atoiDefault(m[1], 0),
+				OldLines: atoiDefault(m[2], 1),
+				NewStart: atoiDefault(m[3], 0),
+				NewLines: atoiDefault(m[4], 1),
+			}
+			header := i + 1
+			i++
+			oldLeft, newLeft := hunk.OldLines, hunk.NewLines
+			for (oldLeft > 0 || newLeft > 0) && i < len(lines) {
+				text := lines[i]
+				op := byte(OpEqual)
+				// editors strip the space of empty context lines
+				if text != "\n" && text != "\r\n" {
+					op, text = text[0], text[1:]
+				}
+				switch op {
+				case OpEqual:
+					oldLeft--
+					newLeft--
+				case OpDelete:
+					oldLeft--
+				case OpInsert:
+					newLeft--
+				case '\\':
+					// a "\ No newline at end of file" between the lines
+					// of a replaced last line
+					if n := len(hunk.Edits); n > 0 {
+						hunk.Edits[n-1].Text = strings.TrimSuffix(hunk.Edits[n-1].Text, "\n")
+					}
+					i++
+					continue
+				default:
+					return nil, fmt.Errorf("line %d: unexpected line in hunk", i+1)
+				}
+				hunk.Edits = append(hunk.Edits, LineEdit{Op: op, Text: text})
+				i++
+			}
+			if oldLeft != 0 || newLeft != 0 {
+				return nil, fmt.Errorf("line %d: hunk is shorter than its header says", header)
+			}
+			if i < len(lines) && strings.HasPrefix(lines[i], `\`) {
+				if n := len(hunk.Edits); n > 0 {
+					hunk.Edits[n-1].Text = strings.TrimSuffix(hunk.Edits[n-1].Text, "\n")
+				}
+				i++
+			}
+			patch.Hunks = append(patch.Hunks, hunk)
+		}
+		i--
+		patches = append(patches, patch)
+	}
+	if len(patches) == 0 {
+		return nil, fmt.Errorf("no file changes found")
+	}
+	return patches, nil
+}
+
+// ApplyHunks applies hunks to text. A hunk is placed at its line number or,
+// if lines were added or removed above it, at the nearest place where its
+// context and removed lines match exactly. Hunks that match nowhere are
+// reported as conflicts and skipped.
+func ApplyHunks(text string, hunks []Hunk) (string, []HunkConflict) {
+	lines := SplitLines(text)
+	var out []string
+	var conflicts []HunkConflict
+	pos, offset := 0, 0
+	for n, hunk := range hunks {
+		var old, new []string
+		for _, edit := range hunk.Edits {
+			if edit.Op != OpInsert {
+				old = append(old, edit.Text)
+			}
+			if edit.Op != OpDelete {
+				new = append(new, edit.Text)
+			}
+		}
+
+		// an empty old range starts after the line it names
+		want := hunk.OldStart - 1 + offset
+		if hunk.OldLines == 0 {
+			want++
+		}
+		at := findLines(lines, old, want, pos)
+		if at < 0 {
+			conflicts = append(conflicts, HunkConflict{
+				Hunk:   n + 1,
+				Line:   hunk.OldStart,
+				Reason: "the lines to change do not match the file",
+			})
+			continue
+		}
+		out = append(out, lines[pos:at]...)
+		out = append(out, new...)
+		pos = at + len(old)
+		offset = at - (want - offset)
+	}
+	out = append(out, lines[pos:]...)
+	return strings.Join(out, ""), conflicts
+}
+
+// findLines returns the index of want's nearest occurrence of needle in
+// lines at or after min, or -1.
+func findLines(lines, needle []string, want, min int) int {
+	matches := func(at int) bool {
+		if at < min || at+len(needle) > len(lines) {
+			return false
+		}
+		for i, line := range needle {
+			if lines[at+i] != line {
+				return false
+			}
+		}
+		return true
+	}
+	for delta := 0; want-delta >= min || want+delta <= len(lines); delta++ {
+		if matches(want - delta) {
+			return want - delta
+		}
+		if matches(want + delta) {
+			return want + delta
+		}
+	}
+	return -1
+}
@@ -0,0 +1,101 @@
+package util
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyPatchRoundTrip(t *testing.T) {
+	cases := [][2]string{
+		{"a\nb\nc\n", "a\nB\nc\n"},
+		{"", "new\nfile\n"},
+		{"old\n", ""},
+		{"x\ny", "x\nz"},
+		{"x\ny\n", "x\ny"},
+		{"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", "0\n1\n2\n3\n4\n5\n6\n7\n8\nnine\n10\n"},
+	}
+	for _, c := range cases {
+		diff := UnifiedDiff("a/file.txt", "b/file.txt", c[0], c[1])
+		patches, err := ParsePatch(diff)
+		require.NoError(t, err, diff)
+		require.Len(t, patches, 1)
+		require.Equal(t, "file.txt", patches[0].NewName)
+
+		got, conflicts := ApplyHunks(c[0], patches[0].Hunks)
+		require.Empty(t, conflicts, diff)
+		require.Equal(t, c[1], got, diff)
+	}
+}
+
+func TestParsePatchFiles(t *testing.T) {
+	diff := "diff --git a/new.go b/new.go\nnew file mode 100644\n--- /dev/null\n+++ b/new.go\n@@ -0,0 +1 @@\n+package main\n" +
+		"diff --git a/old.go b/old.go\ndeleted file mode 100644\n--- a/old.go\n+++ /dev/null\n@@ -1 +0,0 @@\n-package old\n"
+	patches, err := ParsePatch(diff)
+	require.NoError(t, err)
+	require.Len(t, patches, 2)
+	require.True(t, patches[0].IsCreate())
+	require.Equal(t, "new.go", patches[0].NewName)
+	require.True(t, patches[1].IsDelete())
+	require.Equal(t, "old.go", patches[1].OldName)
+
+	_, err = ParsePatch("--- a/x\n+++ b/x\n@@ -1,2 +1,2 @@\n a\n")
+	require.Error(t, err)
+}
+
+func TestApplyHunksOffsetAndConflict(t *testing.T) {
+	before := "a\nb\nc\nd\ne\nf\ng\nh\n"
+	patches, err := ParsePatch(UnifiedDiff("x", "x", before, strings.Replace(before, "f\n", "F\n", 1)))
+	require.NoError(t, err)
+
+	// two lines were added above the hunk since the diff was made
+	got, conflicts := ApplyHunks("0\n00\n"+before, patches[0].Hunks)
+	require.Empty(t, conflicts)
+	require.Equal(t, "0\n00\n"+strings.Replace(before, "f\n", "F\n", 1), got)
+
+	got, conflicts = ApplyHunks(strings.Replace(before, "f\n", "changed\n", 1), patches[0].Hunks)
+	require.Equal(t, []HunkConflict{{Hunk: 1, Line: 3, Reason: "the lines to change do not match the file"}}, conflicts)
+	require.Equal(t, strings.Replace(before, "f\n", "changed\n", 1), got)
+}
+
+func TestParsePatchEmptyHunkWithoutNewline(t *testing.T) {
+	patches, err := ParsePatch("--- a/x\n+++ b/x\n@@ -1,0 +1,0 @@\n\\ No newline at end of file\n")
+	require.NoError(t, err)
+	require.Len(t, patches, 1)
+	require.Len(t, patches[0].Hunks, 1)
+	require.Empty(t, patches[0].Hunks[0].Edits)
+}
+
+func TestParsePatchNames(t *testing.T) {
+	tests := []struct {
+		diff    string
+		oldName string
+		newName string
+	}{
+		// git diffs, also of a file that does not change its name
+		{"diff --git a/x.go b/x.go\n--- a/x.go\n+++ b/x.go\n", "x.go", "x.go"},
+		{"diff --git a/b/x.go b/b/x.go\n--- /dev/null\n+++ b/b/x.go\n", "", "b/x.go"},
+		// diff -u with prefixes on both sides
+		{"--- a/x.go\t2024-01-01\n+++ b/x.go\t2024-01-02\n", "x.go", "x.go"},
+		// diff -u of real a/ and b/ directories
+		{"diff -u a/x.go a/y.go\n--- a/x.go\n+++ a/y.go\n", "a/x.go", "a/y.go"},
+		{"--- b/x.go\n+++ b/x.go\n", "b/x.go", "b/x.go"},
+		{"--- /dev/null\n+++ b/x.go\n", "", "b/x.go"},
+	}
+	for _, tc := range tests {
+		patches, err := ParsePatch(tc.diff + "@@ -0,0 +1 @@\n+y\n")
+		require.NoError(t, err, tc.diff)
+		require.Equal(t, tc.oldName, patches[0].OldName, tc.diff)
+		require.Equal(t, tc.newName, patches[0].NewName, tc.diff)
+	}
+}
+
+func TestParsePatchCRLFEmptyContext(t *testing.T) {
+	before := "a\r\n\r\nb\r\n"
+	patches, err := ParsePatch("--- x\r\n+++ x\r\n@@ -1,3 +1,3 @@\r\n a\r\n\r\n-b\r\n+c\r\n")
+	require.NoError(t, err)
+	got, conflicts := ApplyHunks(before, patches[0].Hunks)
+	require.Empty(t, conflicts)
+	require.Equal(t, "a\r\n\r\nc\r\n", got)
+}
@@ -0,0 +1,305 @@
+//go:build linux
+
+package watch
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_ATTRIB |
+	syscall.IN_DELETE | syscall.IN_DELETE_SELF | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_ONLYDIR | syscall.IN_DONT_FOLLOW | syscall.IN_EXCL_UNLINK
+
+// Watcher watches directory trees. Every directory needs its own inotify
+// watch, so the number of directories is bounded by maxWatches.
+type Watcher struct {
+	fd         int
+	file       *os.File
+	maxWatches int
+	events     chan Event
+
+	mu    sync.Mutex
+	roots map[string]int
+	wds   map[string]int
+	paths map[int]string
+}
+
+// New starts a watcher using at most maxWatches inotify watches.
+func New(maxWatches int) (*Watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+
+	w := &Watcher{
+		fd:         fd,
+		file:       os.NewFile(uintptr(fd), "inotify"),
+		maxWatches: maxWatches,
+		events:     make(chan Event, 1024),
+		roots:      make(map[string]int),
+		wds:        make(map[string]int),
+		paths:      make(map[int]string),
+	}
+	go w.readEvents()
+	return w, nil
+}
+
+// Events returns the channel events are delivered on. It is closed when the
+// watcher is closed.
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+// Close stops the watcher.
+func (w *Watcher) Close() error {
+	return w.file.Close()
+}
+
+// Add watches root and its visible subdirectories. Roots are reference
+// counted, so every Add needs a matching Remove.
+func (w *Watcher) Add(root string) error {
+	root = filepath.Clean(root)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.roots[root]++
+	if w.roots[root] > 1 {
+		return nil
+	}
+	err := w.addTree(root, false)
+	if err != nil && err != ErrTooManyWatches {
+		w.roots[root]--
+		if w.roots[root] == 0 {
+			delete(w.roots, root)
+		}
+	}
+	return err
+}
+
+// Remove stops watching root once it was removed as often as it was added.
+// Directories still below another root stay watched.
+func (w *Watcher) Remove(root string) {
+	root = filepath.Clean(root)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.roots[root] == 0 {
+		return
+	}
+	w.roots[root]--
+	if w.roots[root] > 0 {
+		return
+	}
+	delete(w.roots, root)
+
+	for path, wd := range w.wds {
+		if isWithin(root, path) && !w.covered(path) {
+			syscall.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.wds, path)
+			delete(w.paths, wd)
+		}
+	}
+}
+
+// covered reports whether path is below one of the roots. Callers hold mu.
+func (w *Watcher) covered(path string) bool {
+	for root := range w.roots {
+		if isWithin(root, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// addTree watches dir and its visible subdirectories. With report set the
+// entries found are reported as created: they appeared in a new directory
+// before it could be watched. Callers hold mu.
+func (w *Watcher) addTree(dir string, report bool) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			return nil
+		}
+		if path != dir {
+			if isHidden(d.Name()) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if report {
+				w.events <- Event{Op: Create, Path: path, IsDir: d.IsDir()}
+			}
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if _, ok := w.wds[path]; ok {
+			return nil
+		}
+		if len(w.wds) >= w.maxWatches {
+			return ErrTooManyWatches
+		}
+
+		wd, err := syscall.InotifyAddWatch(w.fd, path, inotifyMask)
+		if err != nil {
+			if path == dir {
+				return os.NewSyscallError("inotify_add_watch", err)
+			}
+			return nil
+		}
+		w.wds[path] = wd
+		w.paths[wd] = path
+		return nil
+	})
+}
+
+// dropTree forgets the watches of a deleted directory tree. The kernel
+// removes the watches themselves. Callers hold mu.
+func (w *Watcher) dropTree(dir string) {
+	for path, wd := range w.wds {
+		if isWithin(dir, path) {
+			delete(w.wds, path)
+			delete(w.paths, wd)
+		}
+	}
+}
+
+// renameTree moves the watches of a renamed directory tree to its new
+// paths. Callers hold mu.
+func (w *Watcher) renameTree(oldDir, newDir string) {
+	for path, wd := range w.wds {
+		if isWithin(oldDir, path) {
+			newPath := newDir + strings.TrimPrefix(path, oldDir)
+			delete(w.wds, path)
+			w.wds[newPath] = wd
+			w.paths[wd] = newPath
+		}
+	}
+}
+
+// pendingMove is the first half of a rename, waiting for its second half
+// with the same cookie.
+type pendingMove struct {
+	path   string
+	isDir  bool
+	hidden bool
+}
+
+func (w *Watcher) readEvents() {
+	defer close(w.events)
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+
+		w.mu.Lock()
+		moves := make(map[uint32]pendingMove)
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[nameStart:nameStart+int(raw.Len)]), "\x00")
+			offset = nameStart + int(raw.Len)
+
+			w.handle(int(raw.Wd), raw.Mask, raw.Cookie, name, moves)
+		}
+		// renames out of the watched trees are deletions
+		for _, move := range moves {
+			if move.hidden {
+				continue
+			}
+			if move.isDir {
+				w.dropTree(move.path)
+			}
+			w.events <- Event{Op: Delete, Path: move.path, IsDir: move.isDir}
+		}
+		w.mu.Unlock()
+	}
+}
+
+// handle turns one inotify event into events. Callers hold mu.
+func (w *Watcher) handle(wd int, mask, cookie uint32, name string, moves map[uint32]pendingMove) {
+	if mask&syscall.IN_IGNORED != 0 {
+		if path, ok := w.paths[wd]; ok {
+			delete(w.wds, path)
+			delete(w.paths, wd)
+		}
+		return
+	}
+	dir, ok := w.paths[wd]
+	if !ok {
+		return
+	}
+	path := dir
+	if name != "" {
+		path = filepath.Join(dir, name)
+	}
+	isDir := mask&syscall.IN_ISDIR != 0
+	hidden := isHidden(name)
+
+	switch {
+	case mask&syscall.IN_MOVED_FROM != 0:
+		moves[cookie] = pendingMove{path: path, isDir: isDir, hidden: hidden}
+		return
+	case mask&syscall.IN_MOVED_TO != 0:
+		from, paired := moves[cookie]
+		delete(moves, cookie)
+		switch {
+		case hidden:
+			if paired && !from.hidden {
+				if from.isDir {
+					w.dropTree(from.path)
+				}
+				w.events <- Event{Op: Delete, Path: from.path, IsDir: from.isDir}
+			}
+		case paired && from.hidden:
+			// a file written next to its target and renamed over it
+			w.events <- Event{Op: Modify, Path: path, IsDir: isDir}
+		case paired:
+			if isDir {
+				w.renameTree(from.path, path)
+			}
+			w.events <- Event{Op: Rename, Path: path, OldPath: from.path, IsDir: isDir}
+		default:
+			w.events <- Event{Op: Create, Path: path, IsDir: isDir}
+			if isDir {
+				w.addTree(path, true)
+			}
+		}
+		return
+	}
+
+	if hidden {
+		return
+	}
+	switch {
+	case mask&syscall.IN_CREATE != 0:
+		w.events <- Event{Op: Create, Path: path, IsDir: isDir}
+		if isDir {
+			w.addTree(path, true)
+		}
+	case mask&syscall.IN_DELETE != 0:
+		if isDir {
+			w.dropTree(path)
+		}
+		w.events <- Event{Op: Delete, Path: path, IsDir: isDir}
+	case mask&syscall.IN_DELETE_SELF != 0:
+		// deletions below a watched parent are reported by the parent
+		if _, ok := w.roots[dir]; ok {
+			w.events <- Event{Op: Delete, Path: dir, IsDir: true}
+		}
+	case mask&(syscall.IN_MODIFY|syscall.IN_ATTRIB) != 0:
+		w.events <- Event{Op: Modify, Path: path, IsDir: isDir}
+	}
+}
@@ -0,0 +1,53 @@
+//go:build linux
+
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func nextEvent(t *testing.T, w *Watcher) Event {
+	select {
+	case event := <-w.Events():
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event received")
+		return Event{}
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	root := t.TempDir()
+	w, err := New(100)
+	require.NoError(t, err)
+	defer w.Close()
+	require.NoError(t, w.Add(root))
+
+	file := filepath.Join(root, "main.go")
+	require.NoError(t, os.WriteFile(file, nil, 0644))
+	require.Equal(t, Event{Op: Create, Path: file}, nextEvent(t, w))
+
+	// new directories are watched too
+	dir := filepath.Join(root, "pkg")
+	require.NoError(t, os.Mkdir(dir, 0755))
+	require.Equal(t, Event{Op: Create, Path: dir, IsDir: true}, nextEvent(t, w))
+	inner := filepath.Join(dir, "lib.go")
+	require.NoError(t, os.WriteFile(inner, nil, 0644))
+	require.Equal(t, Event{Op: Create, Path: inner}, nextEvent(t, w))
+
+	renamed := filepath.Join(root, "lib")
+	require.NoError(t, os.Rename(dir, renamed))
+	require.Equal(t, Event{Op: Rename, Path: renamed, OldPath: dir, IsDir: true}, nextEvent(t, w))
+	require.NoError(t, os.Remove(filepath.Join(renamed, "lib.go")))
+	require.Equal(t, Event{Op: Delete, Path: filepath.Join(renamed, "lib.go")}, nextEvent(t, w))
+
+	// hidden entries are not reported
+	require.NoError(t, os.WriteFile(filepath.Join(root, ".swp"), nil, 0644))
+	require.NoError(t, os.Rename(filepath.Join(root, ".swp"), file))
+	require.Equal(t, Event{Op: Modify, Path: file}, nextEvent(t, w))
+}
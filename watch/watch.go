@@ -0,0 +1,40 @@
+// Package watch reports changes below directory trees. On Linux it is backed
+// by inotify.
+package watch
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+)
+
+// Operations reported in events.
+const (
+	Create = "create"
+	Modify = "modify"
+	Delete = "delete"
+	Rename = "rename"
+)
+
+// ErrTooManyWatches is returned when a tree has more directories than the
+// watcher is allowed to watch. The part watched so far keeps reporting.
+var ErrTooManyWatches = errors.New("too many directories to watch")
+
+// Event is a change of one file or directory. OldPath is set for renames
+// within the watched trees.
+type Event struct {
+	Op      string
+	Path    string
+	OldPath string
+	IsDir   bool
+}
+
+// isHidden reports whether a directory is skipped when adding a tree.
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// isWithin reports whether path is root or below it.
+func isWithin(root, path string) bool {
+	return path == root || strings.HasPrefix(path, root+string(filepath.Separator))
+}
@@ -0,0 +1,33 @@
+//go:build !linux
+
+package watch
+
+import "errors"
+
+var errUnsupported = errors.New("file watching is only supported on linux")
+
+// Watcher watches directory trees.
+type Watcher struct{}
+
+// New starts a watcher. It always fails outside of Linux.
+func New(maxWatches int) (*Watcher, error) {
+	return nil, errUnsupported
+}
+
+// Events returns the channel events are delivered on.
+func (w *Watcher) Events() <-chan Event {
+	return nil
+}
+
+// Close stops the watcher.
+func (w *Watcher) Close() error {
+	return nil
+}
+
+// Add watches root and its visible subdirectories.
+func (w *Watcher) Add(root string) error {
+	return errUnsupported
+}
+
+// Remove stops watching root.
+func (w *Watcher) Remove(root string) {}